@@ -0,0 +1,132 @@
+package ai
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"mime/multipart"
+	"net/http"
+	"os"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Transcriber turns a clip of recorded speech into text
+type Transcriber interface {
+	// Transcribe returns what was said in audio, encoded as format (a file extension
+	// such as "webm", "wav" or "m4a"). Silence gives an empty string.
+	Transcribe(ctx context.Context, audio []byte, format string) (string, error)
+}
+
+// TranscriberFromEnv picks the speech-to-text backend: TRANSCRIBER=fake for the
+// deterministic fake, otherwise Whisper when WHISPER_URL is set. It returns nil when
+// server-side transcription isn't configured.
+func TranscriberFromEnv() Transcriber {
+	if os.Getenv("TRANSCRIBER") == "fake" {
+		return NewFakeTranscriber()
+	}
+	url := os.Getenv("WHISPER_URL")
+	if url == "" {
+		return nil
+	}
+	apiKey := os.Getenv("WHISPER_API_KEY")
+	if apiKey == "" {
+		apiKey = os.Getenv("OPENAI_API_KEY")
+	}
+	model := os.Getenv("WHISPER_MODEL")
+	if model == "" {
+		model = "whisper-1"
+	}
+	return &WhisperTranscriber{URL: url, APIKey: apiKey, Model: model}
+}
+
+// WhisperTranscriber calls a Whisper-compatible /audio/transcriptions endpoint: OpenAI,
+// Azure OpenAI (URL containing /openai/deployments/) or a self-hosted server.
+type WhisperTranscriber struct {
+	URL    string // Full transcriptions URL, including any api-version query
+	APIKey string
+	Model  string
+	Client *http.Client // Defaults to a client with a 30s timeout
+}
+
+func (w *WhisperTranscriber) Transcribe(ctx context.Context, audio []byte, format string) (string, error) {
+	var body bytes.Buffer
+	form := multipart.NewWriter(&body)
+	file, err := form.CreateFormFile("file", "audio."+format)
+	if err != nil {
+		return "", err
+	}
+	if _, err := file.Write(audio); err != nil {
+		return "", err
+	}
+	form.WriteField("model", w.Model)
+	form.WriteField("response_format", "json")
+	if err := form.Close(); err != nil {
+		return "", err
+	}
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, w.URL, &body)
+	if err != nil {
+		return "", err
+	}
+	req.Header.Set("Content-Type", form.FormDataContentType())
+	if w.APIKey != "" {
+		if strings.Contains(w.URL, "/openai/deployments/") {
+			req.Header.Set("api-key", w.APIKey)
+		} else {
+			req.Header.Set("Authorization", "Bearer "+w.APIKey)
+		}
+	}
+
+	client := w.Client
+	if client == nil {
+		client = &http.Client{Timeout: 30 * time.Second}
+	}
+	resp, err := client.Do(req)
+	if err != nil {
+		return "", err
+	}
+	defer resp.Body.Close()
+
+	raw, _ := io.ReadAll(resp.Body)
+	if resp.StatusCode != http.StatusOK {
+		return "", fmt.Errorf("transcription failed with status %d: %s", resp.StatusCode, raw)
+	}
+	var parsed struct {
+		Text string `json:"text"`
+	}
+	if err := json.Unmarshal(raw, &parsed); err != nil {
+		return "", fmt.Errorf("unreadable transcription response: %w", err)
+	}
+	return strings.TrimSpace(parsed.Text), nil
+}
+
+// FakeTranscriber is a deterministic Transcriber for tests and local development. It
+// returns its lines in order, cycling; with no lines it describes the clip it was given.
+type FakeTranscriber struct {
+	mu    sync.Mutex
+	lines []string
+	next  int
+}
+
+// NewFakeTranscriber returns a FakeTranscriber that "hears" lines in order
+func NewFakeTranscriber(lines ...string) *FakeTranscriber {
+	return &FakeTranscriber{lines: lines}
+}
+
+func (f *FakeTranscriber) Transcribe(_ context.Context, audio []byte, format string) (string, error) {
+	if len(audio) == 0 {
+		return "", nil
+	}
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	if len(f.lines) == 0 {
+		return fmt.Sprintf("(%d bytes of %s audio)", len(audio), format), nil
+	}
+	line := f.lines[f.next%len(f.lines)]
+	f.next++
+	return line, nil
+}
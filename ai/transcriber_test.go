@@ -0,0 +1,90 @@
+package ai
+
+import (
+	"context"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+func TestFakeTranscriber(t *testing.T) {
+	tests := []struct {
+		name   string
+		lines  []string
+		clips  [][]byte
+		format string
+		want   []string
+	}{
+		{"lines in order", []string{"hello", "how are you"}, [][]byte{{1}, {2}}, "webm", []string{"hello", "how are you"}},
+		{"lines cycle", []string{"a", "b"}, [][]byte{{1}, {2}, {3}, {4}, {5}}, "webm", []string{"a", "b", "a", "b", "a"}},
+		{"silence doesn't use a line", []string{"a", "b"}, [][]byte{{1}, nil, {2}}, "webm", []string{"a", "", "b"}},
+		{"no lines describes the clip", nil, [][]byte{make([]byte, 1234)}, "wav", []string{"(1234 bytes of wav audio)"}},
+		{"no lines and silence", nil, [][]byte{{}}, "wav", []string{""}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			f := NewFakeTranscriber(tt.lines...)
+			for i, clip := range tt.clips {
+				got, err := f.Transcribe(context.Background(), clip, tt.format)
+				if err != nil {
+					t.Fatal(err)
+				}
+				if got != tt.want[i] {
+					t.Errorf("clip %d = %q, want %q", i, got, tt.want[i])
+				}
+			}
+		})
+	}
+}
+
+func TestWhisperTranscriber(t *testing.T) {
+	tests := []struct {
+		name       string
+		path       string
+		status     int
+		response   string
+		wantHeader string // Header carrying the key
+		wantValue  string
+		want       string
+		wantErr    bool
+	}{
+		{"openai", "/v1/audio/transcriptions", 200, `{"text":" I feel unheard. "}`, "Authorization", "Bearer k", "I feel unheard.", false},
+		{"azure", "/openai/deployments/whisper/audio/transcriptions", 200, `{"text":"ok"}`, "api-key", "k", "ok", false},
+		{"server error", "/v1/audio/transcriptions", 500, `{"error":"boom"}`, "Authorization", "Bearer k", "", true},
+		{"not json", "/v1/audio/transcriptions", 200, `hello`, "Authorization", "Bearer k", "", true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				if got := r.Header.Get(tt.wantHeader); got != tt.wantValue {
+					t.Errorf("%s header = %q, want %q", tt.wantHeader, got, tt.wantValue)
+				}
+				if r.FormValue("model") != "whisper-1" {
+					t.Errorf("model = %q", r.FormValue("model"))
+				}
+				file, header, err := r.FormFile("file")
+				if err != nil {
+					t.Errorf("no audio file in the form: %v", err)
+					return
+				}
+				audio, _ := io.ReadAll(file)
+				if header.Filename != "audio.webm" || string(audio) != "RIFF" {
+					t.Errorf("file = %s with %q", header.Filename, audio)
+				}
+				w.WriteHeader(tt.status)
+				io.WriteString(w, tt.response)
+			}))
+			defer srv.Close()
+
+			w := &WhisperTranscriber{URL: srv.URL + tt.path, APIKey: "k", Model: "whisper-1"}
+			got, err := w.Transcribe(context.Background(), []byte("RIFF"), "webm")
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("error = %v, want error: %v", err, tt.wantErr)
+			}
+			if got != tt.want {
+				t.Errorf("text = %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
@@ -0,0 +1,22 @@
+// Command fakellm runs the offline fake LLM server for local end-to-end runs:
+//
+//	go run ./cmd/fakellm
+//	LLM_PROVIDER=local LLM_BASE_URL=http://localhost:8089/v1 go run .
+package main
+
+import (
+	"log"
+	"net/http"
+	"os"
+
+	"mend/llm/fakellm"
+)
+
+func main() {
+	addr := os.Getenv("FAKE_LLM_ADDR")
+	if addr == "" {
+		addr = ":8089"
+	}
+	log.Printf("🧪 Fake LLM listening on %s (LLM_PROVIDER=local LLM_BASE_URL=http://localhost%s/v1)\n", addr, addr)
+	log.Fatal(http.ListenAndServe(addr, fakellm.New()))
+}
@@ -3,6 +3,7 @@ package config
 import (
 	"log"
 	"os"
+	"strconv"
 
 	"github.com/joho/godotenv"
 )
@@ -18,3 +19,12 @@ func LoadEnv() {
 		}
 	}
 }
+
+// GetInt reads an integer env var, falling back when it is unset or invalid
+func GetInt(key string, fallback int) int {
+	value, err := strconv.Atoi(os.Getenv(key))
+	if err != nil {
+		return fallback
+	}
+	return value
+}
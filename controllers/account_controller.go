@@ -0,0 +1,265 @@
+package controllers
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"os"
+	"strings"
+	"time"
+
+	"mend/database"
+	"mend/middleware"
+	"mend/models"
+	"mend/utils"
+
+	"github.com/gofiber/fiber/v2"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/mongo"
+)
+
+const (
+	passwordResetTTL  = time.Hour
+	emailVerifyTTL    = 48 * time.Hour
+	minPasswordLength = 8
+)
+
+var errInvalidUserToken = errors.New("invalid, used or expired token")
+
+// ForgotPassword godoc
+// @Summary      Request a password reset email
+// @Description  Always answers with the same message so registered emails can't be discovered
+// @Tags         Account
+// @Accept       json
+// @Produce      json
+// @Param        body body map[string]string true "email"
+// @Success      200 {object} map[string]string
+// @Failure      400 {object} map[string]string
+// @Router       /api/password/forgot [post]
+func ForgotPassword(c *fiber.Ctx) error {
+	var body struct {
+		Email string `json:"email"`
+	}
+	if err := c.BodyParser(&body); err != nil || body.Email == "" {
+		return c.Status(400).JSON(fiber.Map{"error": "Missing email"})
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+
+	var user models.User
+	if err := database.GetCollection("users").FindOne(ctx, bson.M{"email": body.Email}).Decode(&user); err == nil {
+		token, err := createUserToken(ctx, user.ID, models.TokenPurposePasswordReset, passwordResetTTL)
+		if err != nil {
+			return c.Status(500).JSON(fiber.Map{"error": "Failed to create reset token"})
+		}
+
+		subject := "Reset your Mend password 🔑"
+		bodyHTML := fmt.Sprintf(`
+			<h2>Hi %s,</h2>
+			<p>We received a request to reset your Mend password.</p>
+			<p><a href="%s">Tap here to choose a new password</a>. This link expires in one hour and can only be used once.</p>
+			<p>If you didn't ask for this, you can safely ignore this email.</p>
+			<br/>
+			<p>With care,<br/>The Mend Team</p>
+		`, user.Name, appLink("reset-password", token))
+
+		go utils.SendEmail(user.Email, subject, bodyHTML)
+	}
+
+	return c.JSON(fiber.Map{"message": "If that email is registered, a reset link is on its way"})
+}
+
+// ResetPassword godoc
+// @Summary      Reset password with a token
+// @Description  Redeems a single-use reset token, sets the new password and logs out every device
+// @Tags         Account
+// @Accept       json
+// @Produce      json
+// @Param        body body map[string]string true "token, password"
+// @Success      200 {object} map[string]string
+// @Failure      400,500 {object} map[string]string
+// @Router       /api/password/reset [post]
+func ResetPassword(c *fiber.Ctx) error {
+	var body struct {
+		Token    string `json:"token"`
+		Password string `json:"password"`
+	}
+	if err := c.BodyParser(&body); err != nil || body.Token == "" {
+		return c.Status(400).JSON(fiber.Map{"error": "Missing reset token"})
+	}
+	if len(body.Password) < minPasswordLength {
+		return c.Status(400).JSON(fiber.Map{"error": fmt.Sprintf("Password must be at least %d characters", minPasswordLength)})
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+
+	token, err := consumeUserToken(ctx, body.Token, models.TokenPurposePasswordReset)
+	if errors.Is(err, errInvalidUserToken) {
+		return c.Status(400).JSON(fiber.Map{"error": "Reset link is invalid or has expired"})
+	}
+	if err != nil {
+		return c.Status(500).JSON(fiber.Map{"error": "Failed to reset password"})
+	}
+
+	_, err = database.GetCollection("users").UpdateOne(ctx,
+		bson.M{"id": token.UserID},
+		bson.M{"$set": bson.M{"password": utils.HashPassword(body.Password)}},
+	)
+	if err != nil {
+		return c.Status(500).JSON(fiber.Map{"error": "Failed to reset password"})
+	}
+
+	// 🔐 Whoever knew the old password may still hold a login
+	if _, err := revokeAllAuthSessions(ctx, token.UserID, models.RevokeReasonPasswordReset); err != nil {
+		return c.Status(500).JSON(fiber.Map{"error": "Password changed but failed to log out other devices"})
+	}
+
+	return c.JSON(fiber.Map{"message": "Password updated. Please log in again."})
+}
+
+// VerifyEmail godoc
+// @Summary      Verify email address
+// @Description  Redeems the single-use verification token sent at registration
+// @Tags         Account
+// @Accept       json
+// @Produce      json
+// @Param        body body map[string]string true "token"
+// @Success      200 {object} map[string]string
+// @Failure      400,500 {object} map[string]string
+// @Router       /api/email/verify [post]
+func VerifyEmail(c *fiber.Ctx) error {
+	var body struct {
+		Token string `json:"token"`
+	}
+	if err := c.BodyParser(&body); err != nil || body.Token == "" {
+		return c.Status(400).JSON(fiber.Map{"error": "Missing verification token"})
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+
+	token, err := consumeUserToken(ctx, body.Token, models.TokenPurposeEmailVerify)
+	if errors.Is(err, errInvalidUserToken) {
+		return c.Status(400).JSON(fiber.Map{"error": "Verification link is invalid or has expired"})
+	}
+	if err != nil {
+		return c.Status(500).JSON(fiber.Map{"error": "Failed to verify email"})
+	}
+
+	_, err = database.GetCollection("users").UpdateOne(ctx,
+		bson.M{"id": token.UserID},
+		bson.M{"$set": bson.M{"emailVerified": true}},
+	)
+	if err != nil {
+		return c.Status(500).JSON(fiber.Map{"error": "Failed to verify email"})
+	}
+
+	return c.JSON(fiber.Map{"message": "Email verified"})
+}
+
+// ResendVerification godoc
+// @Summary      Resend the verification email
+// @Description  Issues a fresh verification link to the caller; older links stop working
+// @Tags         Account
+// @Produce      json
+// @Success      200 {object} map[string]string
+// @Failure      400,404,500 {object} map[string]string
+// @Router       /api/email/verify/resend [post]
+func ResendVerification(c *fiber.Ctx) error {
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+
+	var user models.User
+	if err := database.GetCollection("users").FindOne(ctx, bson.M{"id": middleware.UserID(c)}).Decode(&user); err != nil {
+		return c.Status(404).JSON(fiber.Map{"error": "User not found"})
+	}
+	if user.EmailVerified {
+		return c.Status(400).JSON(fiber.Map{"error": "Email already verified"})
+	}
+
+	if err := sendVerificationEmail(ctx, user); err != nil {
+		return c.Status(500).JSON(fiber.Map{"error": "Failed to send verification email"})
+	}
+	return c.JSON(fiber.Map{"message": "Verification email sent"})
+}
+
+// sendVerificationEmail issues a verification token and mails it to the user
+func sendVerificationEmail(ctx context.Context, user models.User) error {
+	token, err := createUserToken(ctx, user.ID, models.TokenPurposeEmailVerify, emailVerifyTTL)
+	if err != nil {
+		return err
+	}
+
+	subject := "Confirm your email for Mend 💌"
+	bodyHTML := fmt.Sprintf(`
+		<h2>Welcome %s,</h2>
+		<p>Please confirm this is your email address so your partner can find you on Mend.</p>
+		<p><a href="%s">Tap here to verify your email</a>. This link expires in 48 hours.</p>
+		<br/>
+		<p>With love,<br/>The Mend Team</p>
+	`, user.Name, appLink("verify-email", token))
+
+	go utils.SendEmail(user.Email, subject, bodyHTML)
+	return nil
+}
+
+// createUserToken stores a hashed single-use token, replacing any unused one for the same purpose
+func createUserToken(ctx context.Context, userId, purpose string, ttl time.Duration) (string, error) {
+	raw, err := utils.GenerateOpaqueToken()
+	if err != nil {
+		return "", err
+	}
+
+	collection := database.GetCollection("userTokens")
+	if _, err := collection.DeleteMany(ctx, bson.M{
+		"userId":  userId,
+		"purpose": purpose,
+		"usedAt":  bson.M{"$exists": false},
+	}); err != nil {
+		return "", err
+	}
+
+	now := time.Now()
+	_, err = collection.InsertOne(ctx, models.UserToken{
+		ID:        utils.HashToken(raw),
+		UserID:    userId,
+		Purpose:   purpose,
+		CreatedAt: now.Unix(),
+		ExpiresAt: now.Add(ttl).Unix(),
+	})
+	if err != nil {
+		return "", err
+	}
+	return raw, nil
+}
+
+// consumeUserToken atomically marks a token as used and returns it
+func consumeUserToken(ctx context.Context, raw, purpose string) (models.UserToken, error) {
+	now := time.Now().Unix()
+
+	var token models.UserToken
+	err := database.GetCollection("userTokens").FindOneAndUpdate(ctx,
+		bson.M{
+			"_id":       utils.HashToken(raw),
+			"purpose":   purpose,
+			"usedAt":    bson.M{"$exists": false},
+			"expiresAt": bson.M{"$gt": now},
+		},
+		bson.M{"$set": bson.M{"usedAt": now}},
+	).Decode(&token)
+	if errors.Is(err, mongo.ErrNoDocuments) {
+		return models.UserToken{}, errInvalidUserToken
+	}
+	return token, err
+}
+
+// appLink builds a link into the app for emailed tokens (APP_BASE_URL, e.g. https://app.mend.com)
+func appLink(path, token string) string {
+	base := strings.TrimRight(os.Getenv("APP_BASE_URL"), "/")
+	if base == "" {
+		base = "mend:/"
+	}
+	return fmt.Sprintf("%s/%s?token=%s", base, path, token)
+}
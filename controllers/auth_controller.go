@@ -0,0 +1,217 @@
+package controllers
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"time"
+
+	"mend/database"
+	"mend/middleware"
+	"mend/models"
+	"mend/utils"
+
+	"github.com/gofiber/fiber/v2"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/mongo"
+)
+
+var (
+	errInvalidRefreshToken = errors.New("invalid or expired refresh token")
+	errRefreshTokenReused  = errors.New("refresh token reuse detected")
+)
+
+// RefreshToken godoc
+// @Summary      Refresh an access token
+// @Description  Rotates a refresh token: the old one is consumed and a new access/refresh pair is returned.
+// @Description  Presenting an already-used refresh token revokes the whole device session.
+// @Tags         Auth
+// @Accept       json
+// @Produce      json
+// @Param        body body map[string]string true "refreshToken"
+// @Success      200 {object} map[string]interface{}
+// @Failure      400,401,500 {object} map[string]string
+// @Router       /api/token/refresh [post]
+func RefreshToken(c *fiber.Ctx) error {
+	var body struct {
+		RefreshToken string `json:"refreshToken"`
+	}
+	if err := c.BodyParser(&body); err != nil || body.RefreshToken == "" {
+		return c.Status(400).JSON(fiber.Map{"error": "Missing refresh token"})
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+
+	tokens, err := rotateRefreshToken(ctx, body.RefreshToken)
+	if errors.Is(err, errRefreshTokenReused) {
+		return c.Status(401).JSON(fiber.Map{"error": "Refresh token already used; this device has been logged out"})
+	}
+	if errors.Is(err, errInvalidRefreshToken) {
+		return c.Status(401).JSON(fiber.Map{"error": "Invalid or expired refresh token"})
+	}
+	if err != nil {
+		return c.Status(500).JSON(fiber.Map{"error": "Failed to issue tokens"})
+	}
+	return c.JSON(tokens)
+}
+
+// Logout godoc
+// @Summary      Log out this device
+// @Description  Revokes the caller's current device session and all of its refresh tokens
+// @Tags         Auth
+// @Produce      json
+// @Success      200 {object} map[string]string
+// @Failure      401,500 {object} map[string]string
+// @Router       /api/logout [post]
+func Logout(c *fiber.Ctx) error {
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+
+	if err := revokeAuthSession(ctx, middleware.SessionID(c), models.RevokeReasonLogout); err != nil {
+		return c.Status(500).JSON(fiber.Map{"error": "Failed to log out"})
+	}
+	return c.JSON(fiber.Map{"message": "Logged out"})
+}
+
+// LogoutAll godoc
+// @Summary      Log out all devices
+// @Description  Revokes every device session of the caller, including the current one
+// @Tags         Auth
+// @Produce      json
+// @Success      200 {object} map[string]interface{}
+// @Failure      401,500 {object} map[string]string
+// @Router       /api/logout/all [post]
+func LogoutAll(c *fiber.Ctx) error {
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+
+	revoked, err := revokeAllAuthSessions(ctx, middleware.UserID(c), models.RevokeReasonLogoutAll)
+	if err != nil {
+		return c.Status(500).JSON(fiber.Map{"error": "Failed to log out devices"})
+	}
+	return c.JSON(fiber.Map{"message": "Logged out on all devices", "devices": revoked})
+}
+
+// startAuthSession records a new device login and issues its first token pair
+func startAuthSession(ctx context.Context, c *fiber.Ctx, userId string) (fiber.Map, error) {
+	now := time.Now().Unix()
+	session := models.AuthSession{
+		ID:         utils.GeneratePartnerID(),
+		UserID:     userId,
+		UserAgent:  c.Get(fiber.HeaderUserAgent),
+		IP:         c.IP(),
+		CreatedAt:  now,
+		LastUsedAt: now,
+	}
+	if _, err := database.GetCollection("authSessions").InsertOne(ctx, session); err != nil {
+		return nil, err
+	}
+	return issueTokens(ctx, userId, session.ID)
+}
+
+// rotateRefreshToken consumes a refresh token and issues the next pair in its family.
+// A token that was already consumed means it leaked, so the whole family is revoked.
+func rotateRefreshToken(ctx context.Context, raw string) (fiber.Map, error) {
+	tokens := database.GetCollection("refreshTokens")
+	hash := utils.HashToken(raw)
+	now := time.Now().Unix()
+
+	// Atomically claim the token so two concurrent refreshes can't both succeed
+	var token models.RefreshToken
+	err := tokens.FindOneAndUpdate(ctx,
+		bson.M{"_id": hash, "usedAt": bson.M{"$exists": false}},
+		bson.M{"$set": bson.M{"usedAt": now}},
+	).Decode(&token)
+	if errors.Is(err, mongo.ErrNoDocuments) {
+		var used models.RefreshToken
+		if tokens.FindOne(ctx, bson.M{"_id": hash}).Decode(&used) == nil {
+			if err := revokeAuthSession(ctx, used.FamilyID, models.RevokeReasonRefreshTokenReuse); err != nil {
+				return nil, err
+			}
+			return nil, errRefreshTokenReused
+		}
+		return nil, errInvalidRefreshToken
+	}
+	if err != nil {
+		return nil, err
+	}
+	if token.ExpiresAt <= now {
+		return nil, errInvalidRefreshToken
+	}
+
+	res, err := database.GetCollection("authSessions").UpdateOne(ctx,
+		bson.M{"_id": token.FamilyID, "revokedAt": bson.M{"$exists": false}},
+		bson.M{"$set": bson.M{"lastUsedAt": now}},
+	)
+	if err != nil {
+		return nil, err
+	}
+	if res.MatchedCount == 0 {
+		return nil, errInvalidRefreshToken
+	}
+
+	return issueTokens(ctx, token.UserID, token.FamilyID)
+}
+
+// issueTokens creates an access token plus a stored refresh token for an auth session
+func issueTokens(ctx context.Context, userId, sessionId string) (fiber.Map, error) {
+	accessToken, err := utils.GenerateToken(userId, sessionId, utils.TokenTypeAccess, utils.AccessTokenTTL)
+	if err != nil {
+		return nil, err
+	}
+
+	refreshToken, err := utils.GenerateOpaqueToken()
+	if err != nil {
+		return nil, err
+	}
+	now := time.Now()
+	_, err = database.GetCollection("refreshTokens").InsertOne(ctx, models.RefreshToken{
+		ID:        utils.HashToken(refreshToken),
+		FamilyID:  sessionId,
+		UserID:    userId,
+		CreatedAt: now.Unix(),
+		ExpiresAt: now.Add(utils.RefreshTokenTTL).Unix(),
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	return fiber.Map{
+		"accessToken":  accessToken,
+		"refreshToken": refreshToken,
+		"tokenType":    "Bearer",
+		"expiresIn":    int(utils.AccessTokenTTL.Seconds()),
+	}, nil
+}
+
+// revokeAuthSession logs out a single device and kills its refresh token family
+func revokeAuthSession(ctx context.Context, sessionId, reason string) error {
+	if sessionId == "" {
+		return fmt.Errorf("missing auth session id")
+	}
+	_, err := database.GetCollection("authSessions").UpdateOne(ctx,
+		bson.M{"_id": sessionId, "revokedAt": bson.M{"$exists": false}},
+		bson.M{"$set": bson.M{"revokedAt": time.Now().Unix(), "revokeReason": reason}},
+	)
+	if err != nil {
+		return err
+	}
+	_, err = database.GetCollection("refreshTokens").DeleteMany(ctx, bson.M{"familyId": sessionId})
+	return err
+}
+
+// revokeAllAuthSessions logs a user out everywhere and returns how many devices were cut off
+func revokeAllAuthSessions(ctx context.Context, userId, reason string) (int64, error) {
+	res, err := database.GetCollection("authSessions").UpdateMany(ctx,
+		bson.M{"userId": userId, "revokedAt": bson.M{"$exists": false}},
+		bson.M{"$set": bson.M{"revokedAt": time.Now().Unix(), "revokeReason": reason}},
+	)
+	if err != nil {
+		return 0, err
+	}
+	if _, err := database.GetCollection("refreshTokens").DeleteMany(ctx, bson.M{"userId": userId}); err != nil {
+		return 0, err
+	}
+	return res.ModifiedCount, nil
+}
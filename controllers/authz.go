@@ -0,0 +1,77 @@
+package controllers
+
+import (
+	"context"
+	"time"
+
+	"mend/database"
+	"mend/middleware"
+	"mend/models"
+
+	"github.com/gofiber/fiber/v2"
+	"github.com/gofiber/websocket/v2"
+	"go.mongodb.org/mongo-driver/bson"
+)
+
+// forbidden is the uniform response for requests on someone else's data
+func forbidden(c *fiber.Ctx) error {
+	return c.Status(fiber.StatusForbidden).JSON(fiber.Map{"error": "You don't have access to this resource"})
+}
+
+// isParticipant reports whether the user is one of the two partners in the session
+func isParticipant(session models.Session, userId string) bool {
+	return userId != "" && (session.PartnerA == userId || session.PartnerB == userId)
+}
+
+// isOpenSession reports whether the session can still be joined and talked in
+func isOpenSession(session models.Session) bool {
+	for _, status := range models.SessionOpenStatuses {
+		if session.Status == status {
+			return true
+		}
+	}
+	return false
+}
+
+// findSessionForUser loads a session only if the user takes part in it and, for a
+// relationship that has ended, the former partner still shares its transcript.
+// Everyone else gets the same error as a missing session so IDs can't be probed.
+func findSessionForUser(ctx context.Context, sessionId, userId string) (models.Session, bool) {
+	var session models.Session
+	err := database.GetCollection("sessions").FindOne(ctx, bson.M{"_id": sessionId}).Decode(&session)
+	if err != nil || !isParticipant(session, userId) {
+		return models.Session{}, false
+	}
+	if !transcriptsVisibleTo(ctx, session.RelationshipID, userId) {
+		return models.Session{}, false
+	}
+	return session, true
+}
+
+// AuthorizeSessionSocket guards WebSocket upgrades: the caller must be the user in the
+// path and a participant of the session they are connecting to, and the session must
+// still be open.
+func AuthorizeSessionSocket(c *fiber.Ctx) error {
+	if !websocket.IsWebSocketUpgrade(c) {
+		return fiber.ErrUpgradeRequired
+	}
+
+	callerId := middleware.UserID(c)
+	if c.Params("userId") != callerId {
+		return forbidden(c)
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+
+	session, ok := findSessionForUser(ctx, c.Params("sessionId"), callerId)
+	if !ok {
+		return c.Status(fiber.StatusNotFound).JSON(fiber.Map{"error": "Session not found"})
+	}
+	if !isOpenSession(session) {
+		return c.Status(fiber.StatusConflict).JSON(fiber.Map{"error": "Session has ended"})
+	}
+	// Socket handlers read it back with websocket.Conn.Locals("session")
+	c.Locals("session", session)
+	return c.Next()
+}
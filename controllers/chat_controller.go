@@ -1,88 +1,269 @@
 package controllers
 
 import (
-	"bytes"
 	"context"
-	"encoding/json"
+	"errors"
 	"fmt"
-	"io"
-	"net/http"
-	"os"
+	"log"
+	"sort"
+	"strconv"
 	"strings"
 	"time"
 
 	"mend/database"
+	"mend/hub"
+	"mend/llm"
+	"mend/middleware"
 	"mend/models"
+	"mend/prompts"
 	"mend/utils"
 
 	"github.com/gofiber/fiber/v2"
 	"github.com/gofiber/websocket/v2"
 	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
 )
 
-// WebSocket clients: key = userId:sessionId
-var clients = make(map[string]*websocket.Conn)
-
-// HandleWebSocket handles real-time chat messages
+// HandleWebSocket handles real-time chat messages. Clients send `chat` (and `typing`)
+// envelopes; typing becomes a `presence` event for the partner, and everyone in the session, sender included, receives each stored chat message
+// stamped with its sequence number, and the sender also gets an `ack` with that seq.
+// A message the moderator flags is marked in the transcript and brings an `ai_warning`.
+// Clients ack the highest seq they have seen; on reconnect (`?lastSeq=`, else the last
+// ack) the messages they missed are replayed from Mongo before live traffic resumes.
 func HandleWebSocket(c *websocket.Conn) {
 	userId := c.Params("userId")
 	sessionId := c.Params("sessionId")
-	clientKey := userId + ":" + sessionId
 
-	clients[clientKey] = c
-	defer func() {
-		c.Close()
-		delete(clients, clientKey)
-	}()
+	client := sessionHub.RegisterHeld(c, sessionId, userId)
+	client.Release(replayMissedMessages(client, c.Query("lastSeq")))
+	leave := presenceConnected(client)
+	defer leave()
+	restoreCooldown(sessionId)
 
-	for {
-		_, msg, err := c.ReadMessage()
+	client.Run(func(client *hub.Client, msg []byte) {
+		env, payload, err := hub.Decode(msg, hub.KindChat, hub.KindTyping, hub.KindAck)
 		if err != nil {
-			break
+			rejectFrame(client, err)
+			return
 		}
 
-		var message models.Message
-		if err := json.Unmarshal(msg, &message); err != nil {
-			continue
+		var chat *hub.ChatPayload
+		switch p := payload.(type) {
+		case *hub.AckPayload:
+			go recordAck(sessionId, userId, p.Seq)
+			return
+		case *hub.TypingPayload:
+			setActivity(client, hub.PresenceTyping, p.Typing)
+			return
+		case *hub.ChatPayload:
+			chat = p
 		}
-		message.Timestamp = time.Now().Unix()
 
-		// Simple interruption moderation
-		if strings.Contains(strings.ToLower(message.Text), "interrupt") {
-			c.WriteMessage(websocket.TextMessage, []byte("INTERRUPT: Please wait your turn."))
-			continue
+		if getLiveSession(sessionId).isPaused() {
+			sendSocketError(client, "session_paused", "The session is paused for a cooling-off period", env.ID)
+			return
 		}
 
-		// Save message
-		go appendMessageToSessionByID(message.SessionId, message)
+		// The connection was authorized for this user and session; never trust the frame's IDs
+		message := models.Message{
+			SpeakerId:   userId,
+			SessionId:   sessionId,
+			Text:        chat.Text,
+			Timestamp:   time.Now().Unix(),
+			ClientMsgID: env.ID,
+		}
 
-		// Broadcast to all clients in session
-		for id, conn := range clients {
-			if idHasSession(id, sessionId) {
-				conn.WriteMessage(websocket.TextMessage, msg)
-			}
+		// 💾 Store first: only messages with a seq are delivered, so a reconnect can't miss them
+		message, err = appendMessageToSessionByID(sessionId, message)
+		if errors.Is(err, errDuplicateMessage) {
+			// A resend of something already stored: confirm it again, don't deliver it twice
+			sendAck(client, env.ID, message.Seq, true)
+			return
 		}
+		if errors.Is(err, errSessionClosed) {
+			sendSocketError(client, "session_closed", "This session has ended", env.ID)
+			return
+		}
+		if err != nil {
+			log.Println("❌ Failed to store chat message:", err)
+			sendSocketError(client, "internal", "Failed to store message", env.ID)
+			return
+		}
+		sendAck(client, env.ID, message.Seq, false)
+
+		// Broadcast to all clients in session
+		env.SessionID = sessionId
+		env.From = userId
+		env.SentAt = time.Now().UnixMilli()
+		env.Seq = message.Seq
+		sessionHub.Broadcast(sessionId, env, nil)
 
 		// AI moderation & response if needed
+		go moderateChatMessage(message, sessionId)
 		go maybeTriggerTherapistAI(message, sessionId)
-	}
+	})
 }
 
-// Save message to MongoDB session
-func appendMessageToSessionByID(sessionId string, msg models.Message) {
+var (
+	errDuplicateMessage = errors.New("message already stored")
+	errSessionClosed    = errors.New("session is no longer open")
+)
+
+// appendMessageToSessionByID stores a message under the session's next sequence number.
+// A message whose ClientMsgID is already stored is not added again: errDuplicateMessage
+// is returned along with the stored copy. Ended and abandoned sessions take no more
+// messages: errSessionClosed.
+func appendMessageToSessionByID(sessionId string, msg models.Message) (models.Message, error) {
 	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
 	defer cancel()
 
-	filter := bson.M{"_id": sessionId}
-	update := bson.M{"$push": bson.M{"messages": msg}}
 	sessions := database.GetCollection("sessions")
-	_, _ = sessions.UpdateOne(ctx, filter, update)
+	err := database.WithTransaction(ctx, func(sc mongo.SessionContext) error {
+		filter := bson.M{"_id": sessionId, "status": bson.M{"$in": models.SessionOpenStatuses}}
+		update := bson.M{"$inc": bson.M{"lastSeq": 1}}
+		if msg.ClientMsgID != "" {
+			filter["clientMsgIds"] = bson.M{"$ne": msg.ClientMsgID}
+			update["$push"] = bson.M{"clientMsgIds": msg.ClientMsgID}
+		}
+
+		var counter struct {
+			LastSeq int64 `bson:"lastSeq"`
+		}
+		err := sessions.FindOneAndUpdate(sc, filter, update,
+			options.FindOneAndUpdate().
+				SetReturnDocument(options.After).
+				SetProjection(bson.M{"lastSeq": 1}),
+		).Decode(&counter)
+		if err != nil {
+			return err
+		}
+
+		msg.Seq = counter.LastSeq
+		_, err = sessions.UpdateOne(sc, bson.M{"_id": sessionId}, bson.M{"$push": bson.M{"messages": msg}})
+		return err
+	})
+	if !errors.Is(err, mongo.ErrNoDocuments) {
+		return msg, err
+	}
+	// 🔍 Nothing matched: either a resend of a stored message or a closed (or missing) session
+	if msg.ClientMsgID != "" {
+		if stored, err := storedMessage(ctx, sessionId, msg.ClientMsgID); !errors.Is(err, mongo.ErrNoDocuments) {
+			return stored, err
+		}
+	}
+	return msg, errSessionClosed
+}
+
+// storedMessage looks up an already stored message by its client ID
+func storedMessage(ctx context.Context, sessionId, clientMsgId string) (models.Message, error) {
+	var session models.Session
+	err := database.GetCollection("sessions").FindOne(ctx,
+		bson.M{"_id": sessionId, "messages.clientMsgId": clientMsgId},
+		options.FindOne().SetProjection(bson.M{"messages.$": 1}),
+	).Decode(&session)
+	if err != nil {
+		return models.Message{}, err
+	}
+	if len(session.Messages) == 0 {
+		return models.Message{}, mongo.ErrNoDocuments
+	}
+	return session.Messages[0], errDuplicateMessage
+}
+
+// sendAck confirms to the sender that a chat frame is stored, and under which seq
+func sendAck(c *hub.Client, id string, seq int64, duplicate bool) {
+	env, err := hub.NewEnvelope(hub.KindAck, c.SessionID, "", hub.AckPayload{ID: id, Seq: seq, Duplicate: duplicate})
+	if err != nil {
+		return
+	}
+	env.Seq = seq
+	c.Send(env)
+}
+
+// recordAck remembers the highest seq a user has confirmed, used when they reconnect without ?lastSeq
+func recordAck(sessionId, userId string, seq int64) {
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+
+	_, err := database.GetCollection("sessions").UpdateOne(ctx,
+		bson.M{"_id": sessionId},
+		bson.M{"$max": bson.M{"acked." + userId: seq}},
+	)
+	if err != nil {
+		log.Println("❌ Failed to record ack:", err)
+	}
+}
+
+// replayMissedMessages sends a (re)connecting client every stored message after the seq it
+// last saw, oldest first, and returns the highest seq it now has. lastSeq comes from the
+// query string; without it the user's last ack is used, and with neither nothing is replayed.
+func replayMissedMessages(c *hub.Client, lastSeq string) int64 {
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+
+	var session models.Session
+	err := database.GetCollection("sessions").FindOne(ctx,
+		bson.M{"_id": c.SessionID},
+		options.FindOne().SetProjection(bson.M{"messages": 1, "acked": 1, "lastSeq": 1}),
+	).Decode(&session)
+	if err != nil {
+		return 0
+	}
+
+	after, ok := session.Acked[c.UserID]
+	if lastSeq != "" {
+		if n, err := strconv.ParseInt(lastSeq, 10, 64); err == nil && n >= 0 {
+			after, ok = n, true
+		}
+	}
+	if !ok {
+		// A client that never tracked seqs only gets live traffic, as before
+		return session.LastSeq
+	}
+
+	missed := make([]models.Message, 0)
+	for _, m := range session.Messages {
+		if m.Seq > after {
+			missed = append(missed, m)
+		}
+	}
+	sort.Slice(missed, func(i, j int) bool { return missed[i].Seq < missed[j].Seq })
+
+	for _, m := range missed {
+		env, err := replayEnvelope(m)
+		if err != nil {
+			continue
+		}
+		if !c.Send(env) {
+			break
+		}
+		after = m.Seq
+	}
+	return max(after, session.LastSeq)
 }
 
-// Check if key belongs to session
-func idHasSession(clientKey, sessionId string) bool {
-	parts := strings.Split(clientKey, ":")
-	return len(parts) == 2 && parts[1] == sessionId
+// replayEnvelope rebuilds the frame a stored message was originally delivered as
+func replayEnvelope(m models.Message) (hub.Envelope, error) {
+	var env hub.Envelope
+	var err error
+	switch {
+	case m.SpeakerId == "AI":
+		env, err = hub.NewEnvelope(hub.KindAIReply, m.SessionId, "AI", hub.AIReplyPayload{Text: m.Text})
+	case m.Source == models.MessageSourceVoice:
+		env, err = hub.NewEnvelope(hub.KindTranscript, m.SessionId, m.SpeakerId, hub.TranscriptPayload{Text: m.Text})
+	default:
+		env, err = hub.NewEnvelope(hub.KindChat, m.SessionId, m.SpeakerId, hub.ChatPayload{Text: m.Text})
+	}
+	if err != nil {
+		return env, err
+	}
+	if m.ClientMsgID != "" {
+		env.ID = m.ClientMsgID
+	}
+	env.SentAt = m.Timestamp * 1000
+	env.Seq = m.Seq
+	return env, nil
 }
 
 // AI moderation & reply trigger
@@ -93,41 +274,43 @@ func maybeTriggerTherapistAI(message models.Message, sessionId string) {
 	for _, w := range triggerWords {
 		if strings.Contains(lower, w) {
 			go func() {
-				payload := map[string]string{
-					"transcript": message.Text,
-					"speaker":    message.SpeakerId,
-				}
-				payloadBytes, _ := json.Marshal(payload)
+				ctx, cancel := context.WithTimeout(context.Background(), 15*time.Second)
+				defer cancel()
 
-				resp, err := http.Post("http://localhost:3000/api/moderate", "application/json", bytes.NewBuffer(payloadBytes))
-				if err != nil {
+				var session models.Session
+				if err := database.GetCollection("sessions").FindOne(ctx, bson.M{"_id": sessionId}).Decode(&session); err != nil {
 					fmt.Println("AI moderation failed:", err)
 					return
 				}
-				defer resp.Body.Close()
-				body, _ := io.ReadAll(resp.Body)
-				var parsed map[string]interface{}
-				_ = json.Unmarshal(body, &parsed)
+				// 🤫 The couple can turn the therapist's interjections off
+				if !aiInterjectionsAllowed(ctx, session.RelationshipID) {
+					return
+				}
 
-				reply, _ := parsed["aiReply"].(string)
-				if reply == "" {
+				reply, promptVersion, err := therapistReply(ctx, loadTherapistInput(session, message))
+				if err != nil {
+					fmt.Println("AI moderation failed:", err)
 					return
 				}
 
 				aiMessage := models.Message{
-					Text:      reply,
-					SessionId: sessionId,
-					SpeakerId: "AI",
-					Timestamp: time.Now().Unix(),
+					Text:          reply,
+					SessionId:     sessionId,
+					SpeakerId:     "AI",
+					Timestamp:     time.Now().Unix(),
+					PromptVersion: promptVersion,
 				}
 
-				appendMessageToSessionByID(sessionId, aiMessage)
+				aiMessage, err = appendMessageToSessionByID(sessionId, aiMessage)
+				if err != nil {
+					fmt.Println("Failed to store AI reply:", err)
+					return
+				}
 
-				aiJson, _ := json.Marshal(aiMessage)
-				for id, conn := range clients {
-					if idHasSession(id, sessionId) {
-						conn.WriteMessage(websocket.TextMessage, aiJson)
-					}
+				out, err := hub.NewEnvelope(hub.KindAIReply, sessionId, "AI", hub.AIReplyPayload{Text: reply})
+				if err == nil {
+					out.Seq = aiMessage.Seq
+					sessionHub.Broadcast(sessionId, out, nil)
 				}
 			}()
 			break
@@ -135,12 +318,69 @@ func maybeTriggerTherapistAI(message models.Message, sessionId string) {
 	}
 }
 
-// ModerateChat (API)
+// moderateChatMessage has the model check a stored chat message. A flagged message is marked
+// in the transcript and the session is warned, counting towards a time-out.
+func moderateChatMessage(message models.Message, sessionId string) {
+	// 🤫 The couple can turn the moderator's interjections off
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+	if !moderatorAllowed(ctx, sessionId) {
+		return
+	}
+
+	result := utils.ModerateText(message.Text, message.SpeakerId)
+	if !result.IsFlagged {
+		return
+	}
+	if err := moderatorFlag(sessionId, message.Seq); err != nil {
+		log.Println("❌ Failed to flag chat message:", err)
+	}
+
+	warning := result.Warning
+	if warning == "" {
+		warning = respectWarning
+	}
+	warnAndStrike(sessionId, warning, message.SpeakerId)
+}
+
+// flagMessage marks a stored message as flagged by the moderator
+func flagMessage(sessionId string, seq int64) error {
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+
+	_, err := database.GetCollection("sessions").UpdateOne(ctx,
+		bson.M{"_id": sessionId, "messages.seq": seq},
+		bson.M{"$set": bson.M{"messages.$.flagged": true}},
+	)
+	return err
+}
+
+// therapistReply asks the model for the therapist's response to something a partner said,
+// returning the reply and the prompt version it came from
+func therapistReply(ctx context.Context, in therapistInput) (string, string, error) {
+	prompt := prompts.Get(prompts.TherapistReply)
+	req, err := prompt.Render(prompts.Vars{
+		"speaker":          in.Speaker,
+		"transcript":       in.Message,
+		"conversation":     in.Conversation,
+		"partners":         in.Partners,
+		"previous_summary": in.PreviousSummary,
+	})
+	if err != nil {
+		return "", "", err
+	}
+	reply, err := llm.Default().Chat(ctx, req)
+	return reply, prompt.ID(), err
+}
+
+// ModerateChat (API). With a sessionId the therapist AI reads that session's conversation
+// and the couple's background; otherwise `context` stands in for the conversation so far.
 func ModerateChat(c *fiber.Ctx) error {
 	type ChatRequest struct {
 		Transcript string `json:"transcript"`
 		Context    string `json:"context"`
 		Speaker    string `json:"speaker"`
+		SessionID  string `json:"sessionId"`
 	}
 
 	var body ChatRequest
@@ -152,74 +392,41 @@ func ModerateChat(c *fiber.Ctx) error {
 		return c.Status(400).JSON(fiber.Map{"error": "Missing required fields"})
 	}
 
-	apiKey := os.Getenv("OPENAI_API_KEY")
-	endpoint := os.Getenv("OPENAI_ENDPOINT")
-	deployment := os.Getenv("OPENAI_DEPLOYMENT")
-	if apiKey == "" || endpoint == "" || deployment == "" {
-		return c.Status(500).JSON(fiber.Map{"error": "OpenAI config missing"})
-	}
+	ctx, cancel := context.WithTimeout(context.Background(), 15*time.Second)
+	defer cancel()
 
-	prompt := utils.GeneratePrompt(body.Transcript)
-	payload := map[string]interface{}{
-		"messages": []map[string]string{
-			{"role": "system", "content": "You are a kind, empathetic therapist AI guiding respectful conversation between partners."},
-			{"role": "user", "content": prompt},
-		},
-		"temperature": 0.7,
+	in := therapistInput{
+		Speaker:         body.Speaker,
+		Message:         body.Transcript,
+		Conversation:    body.Context,
+		Partners:        "Not available.",
+		PreviousSummary: "Not available.",
 	}
-
-	jsonData, _ := json.Marshal(payload)
-	url := fmt.Sprintf("%s/openai/deployments/%s/chat/completions?api-version=2024-02-15-preview", endpoint, deployment)
-
-	req, err := http.NewRequest("POST", url, bytes.NewBuffer(jsonData))
-	if err != nil {
-		return c.Status(500).JSON(fiber.Map{"error": "Failed to create OpenAI request"})
+	if body.SessionID != "" {
+		session, ok := findSessionForUser(ctx, body.SessionID, middleware.UserID(c))
+		if !ok {
+			return c.Status(404).JSON(fiber.Map{"error": "Session not found"})
+		}
+		in = loadTherapistInput(session, models.Message{SpeakerId: middleware.UserID(c), Text: body.Transcript})
 	}
-	req.Header.Set("Content-Type", "application/json")
-	req.Header.Set("api-key", apiKey)
-
-	client := &http.Client{Timeout: 15 * time.Second}
-	resp, err := client.Do(req)
-	if err != nil {
-		return c.Status(500).JSON(fiber.Map{"error": "OpenAI request failed"})
+	if strings.TrimSpace(in.Conversation) == "" {
+		in.Conversation = "(no earlier messages)"
 	}
-	defer resp.Body.Close()
 
-	bodyBytes, _ := io.ReadAll(resp.Body)
-	if resp.StatusCode != http.StatusOK {
-		return c.Status(resp.StatusCode).JSON(fiber.Map{"error": "OpenAI API error", "details": string(bodyBytes)})
+	reply, promptVersion, err := therapistReply(ctx, in)
+	if errors.Is(err, llm.ErrNotConfigured) {
+		return c.Status(500).JSON(fiber.Map{"error": "AI provider not configured"})
 	}
-
-	var openaiResp map[string]interface{}
-	_ = json.Unmarshal(bodyBytes, &openaiResp)
-	reply := extractOpenAIReply(openaiResp)
-	if reply == "" {
+	if errors.Is(err, llm.ErrEmptyResponse) {
 		return c.Status(500).JSON(fiber.Map{"error": "Empty response from AI"})
 	}
+	if err != nil {
+		return c.Status(502).JSON(fiber.Map{"error": "AI request failed", "details": err.Error()})
+	}
 
 	return c.Status(200).JSON(fiber.Map{
-		"aiReply":   reply,
-		"interrupt": utils.InterruptWarning(body.Speaker),
+		"aiReply":       reply,
+		"promptVersion": promptVersion,
+		"interrupt":     utils.InterruptWarning(body.Speaker),
 	})
 }
-
-// Extract reply from OpenAI response
-func extractOpenAIReply(resp map[string]interface{}) string {
-	choices, ok := resp["choices"].([]interface{})
-	if !ok || len(choices) == 0 {
-		return ""
-	}
-	first, ok := choices[0].(map[string]interface{})
-	if !ok {
-		return ""
-	}
-	message, ok := first["message"].(map[string]interface{})
-	if !ok {
-		return ""
-	}
-	content, ok := message["content"].(string)
-	if !ok {
-		return ""
-	}
-	return content
-}
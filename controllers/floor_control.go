@@ -0,0 +1,197 @@
+package controllers
+
+import (
+	"context"
+	"time"
+
+	"mend/config"
+	"mend/database"
+	"mend/hub"
+	"mend/models"
+	"mend/utils"
+
+	"go.mongodb.org/mongo-driver/bson"
+)
+
+// Floor control ("talking stick") for the voice socket: one partner speaks while the
+// other listens. Only the holder's transcript frames are relayed; the listener can ask
+// for the floor and gets it when the speaker releases it or runs out of time.
+
+const (
+	minSpeakingTime = 15 * time.Second
+	maxSpeakingTime = 10 * time.Minute
+)
+
+// floorState is part of the session's live state, the same on every replica
+type floorState struct {
+	Holder    string    `json:"holder,omitempty"`
+	ExpiresAt time.Time `json:"expiresAt"`
+	Queued    string    `json:"queued,omitempty"` // Partner waiting for the floor
+	Gen       int       `json:"gen"`              // Bumped on every change so stale timers do nothing
+}
+
+// floorResult is the outcome of a liveOpFloorRequest
+type floorResult struct {
+	Granted bool   // The floor was free and is now the requester's
+	Queued  bool   // Someone else holds it and the requester is next
+	Holder  string // Who holds the floor now
+	Gen     int
+}
+
+// applyFloorRequest grants a free floor until change.Until, or queues the requester behind
+// the speaker when change.Active is set
+func (l *liveSession) applyFloorRequest(change liveChange) floorResult {
+	switch l.Floor.Holder {
+	case "":
+		l.Floor = floorState{Holder: change.User, ExpiresAt: change.Until, Gen: l.Floor.Gen + 1}
+		return floorResult{Granted: true, Holder: change.User, Gen: l.Floor.Gen}
+	case change.User:
+		return floorResult{Holder: change.User, Gen: l.Floor.Gen}
+	}
+	if change.Active {
+		l.Floor.Queued = change.User
+	}
+	return floorResult{Queued: change.Active, Holder: l.Floor.Holder, Gen: l.Floor.Gen}
+}
+
+// releaseResult is the outcome of a liveOpFloorRelease
+type releaseResult struct {
+	Released bool
+	Next     string // Who was queued for the floor
+}
+
+// applyFloorRelease frees the floor if change.User holds it (for change.Gen, when set)
+func (l *liveSession) applyFloorRelease(change liveChange) releaseResult {
+	if l.Floor.Holder == "" || l.Floor.Holder != change.User || (change.Gen != 0 && change.Gen != l.Floor.Gen) {
+		return releaseResult{}
+	}
+	next := l.Floor.Queued
+	l.Floor = floorState{Gen: l.Floor.Gen + 1}
+	return releaseResult{Released: true, Next: next}
+}
+
+// floorHolder is who holds the floor now, on any instance
+func floorHolder(sessionId string) string {
+	holder := ""
+	getLiveSession(sessionId).read(func(l *liveSession) { holder = l.Floor.Holder })
+	return holder
+}
+
+// requestFloor grants the floor if it is free, otherwise queues the caller behind the speaker
+func requestFloor(sessionId, userId string) {
+	if floorHolder(sessionId) == userId {
+		return
+	}
+	res := takeFloor(sessionId, userId, true)
+	if res.Queued {
+		broadcastControl(sessionId, hub.ControlPayload{Action: hub.ActionFloorRequested, UserID: userId})
+	}
+}
+
+// releaseFloor gives up the floor (reason: released, time_up, paused) and hands it to
+// whoever is queued. Releasing a floor you don't hold is a no-op.
+func releaseFloor(sessionId, userId, reason string) {
+	if floorHolder(sessionId) != userId {
+		return
+	}
+	releaseFloorGen(sessionId, userId, 0, reason)
+}
+
+// releaseFloorGen is releaseFloor for one grant of the floor; gen 0 is whichever is current
+func releaseFloorGen(sessionId, userId string, gen int, reason string) {
+	res, _ := getLiveSession(sessionId).update(liveChange{Op: liveOpFloorRelease, User: userId, Gen: gen}).(releaseResult)
+	if !res.Released {
+		return
+	}
+
+	broadcastControl(sessionId, hub.ControlPayload{Action: hub.ActionFloorReleased, UserID: userId, Reason: reason})
+	if res.Next != "" && res.Next != userId && reason != "paused" {
+		takeFloor(sessionId, res.Next, false)
+	}
+}
+
+// clearFloor drops the floor without handing it on (used when the session is paused)
+func clearFloor(sessionId string) {
+	if holder := floorHolder(sessionId); holder != "" {
+		releaseFloor(sessionId, holder, "paused")
+	}
+}
+
+// mayTranscribe reports whether userId may speak now. A free floor is picked up by whoever
+// speaks first; otherwise it returns the partner currently holding it.
+func mayTranscribe(sessionId, userId string) (bool, string) {
+	switch holder := floorHolder(sessionId); holder {
+	case userId:
+		return true, ""
+	case "":
+		res := takeFloor(sessionId, userId, false)
+		if res.Holder == userId {
+			return true, ""
+		}
+		return false, res.Holder // Someone else got there first
+	default:
+		return false, holder
+	}
+}
+
+// takeFloor asks for the floor for the couple's maximum speaking time, queueing behind the
+// speaker if `queue` is set. When granted, everyone is told and the floor is released once
+// the time is up; the timer runs on this instance.
+func takeFloor(sessionId, userId string, queue bool) floorResult {
+	d := speakingTime(sessionId)
+	expiresAt := time.Now().Add(d)
+
+	res, _ := getLiveSession(sessionId).update(liveChange{
+		Op:     liveOpFloorRequest,
+		User:   userId,
+		Until:  expiresAt,
+		Active: queue,
+	}).(floorResult)
+	if !res.Granted {
+		return res
+	}
+
+	time.AfterFunc(d, func() {
+		releaseFloorGen(sessionId, userId, res.Gen, "time_up")
+	})
+	broadcastControl(sessionId, hub.ControlPayload{
+		Action:     hub.ActionFloorGranted,
+		UserID:     userId,
+		MaxSeconds: int(d.Seconds()),
+		ExpiresAt:  expiresAt.Unix(),
+	})
+	return res
+}
+
+// interruptWarningFor builds the speaker-specific warning sent to a partner talking out of turn
+func interruptWarningFor(holderId string) string {
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	name := "your partner"
+	var holder models.User
+	if err := database.GetCollection("users").FindOne(ctx, bson.M{"id": holderId}).Decode(&holder); err == nil && holder.Name != "" {
+		name = holder.Name
+	}
+	return utils.InterruptWarning(name)
+}
+
+// speakingTime is the couple's maximum speaking time, else MAX_SPEAKING_SECONDS (default 90)
+func speakingTime(sessionId string) time.Duration {
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	clamp := func(seconds int) time.Duration {
+		return min(max(time.Duration(seconds)*time.Second, minSpeakingTime), maxSpeakingTime)
+	}
+
+	var session models.Session
+	if err := database.GetCollection("sessions").FindOne(ctx, bson.M{"_id": sessionId}).Decode(&session); err == nil {
+		var relationship models.Relationship
+		err := database.GetCollection("relationships").FindOne(ctx, bson.M{"_id": session.RelationshipID}).Decode(&relationship)
+		if err == nil && relationship.Settings.MaxSpeakingSeconds > 0 {
+			return clamp(relationship.Settings.MaxSpeakingSeconds)
+		}
+	}
+	return clamp(config.GetInt("MAX_SPEAKING_SECONDS", 90))
+}
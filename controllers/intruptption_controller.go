@@ -2,16 +2,136 @@ package controllers
 
 import (
 	"context"
+	"encoding/json"
 	"log"
-	"os"
 	"strings"
+	"time"
+
+	"mend/database"
+	"mend/hub"
+	"mend/llm"
+	"mend/middleware"
+	"mend/models"
+	"mend/prompts"
 
 	"github.com/gofiber/fiber/v2"
-	openai "github.com/sashabaranov/go-openai"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/mongo/options"
 )
 
+// utterance is the server-time span of one transcript frame
+type utterance struct {
+	Start time.Time `json:"start"`
+	End   time.Time `json:"end"`
+}
+
+// transcriptSpan maps a transcript's startMs/endMs (milliseconds since the sender's socket
+// connected) onto the server clock, so partners' clocks never need to agree.
+func transcriptSpan(startMs, endMs int64, connectedAt time.Time) utterance {
+	return utterance{
+		Start: connectedAt.Add(time.Duration(startMs) * time.Millisecond),
+		End:   connectedAt.Add(time.Duration(endMs) * time.Millisecond),
+	}
+}
+
+// detectInterruption records a speaker's utterance and compares it with the partner's latest
+// one, wherever the partner is connected. Whoever started talking while the other was still
+// speaking interrupted them. Returns the interrupter and the interrupted partner, or empty
+// strings for no overlap.
+func detectInterruption(sessionId, speakerId string, u utterance) (interrupter, interrupted string) {
+	res, _ := getLiveSession(sessionId).update(liveChange{Op: liveOpUtterance, User: speakerId, Span: &u}).([2]string)
+	return res[0], res[1]
+}
+
+func (l *liveSession) applyUtterance(change liveChange) [2]string {
+	if change.Span == nil {
+		return [2]string{}
+	}
+	u := *change.Span
+	if l.Utterances == nil {
+		l.Utterances = make(map[string]utterance)
+	}
+	l.Utterances[change.User] = u
+
+	for partnerId, other := range l.Utterances {
+		if partnerId == change.User {
+			continue
+		}
+		switch {
+		case u.Start.After(other.Start) && u.Start.Before(other.End):
+			return [2]string{change.User, partnerId}
+		case other.Start.After(u.Start) && other.Start.Before(u.End):
+			return [2]string{partnerId, change.User}
+		}
+	}
+	return [2]string{}
+}
+
+// recordInterruption counts the interruption on the session and warns the interrupter
+func recordInterruption(sessionId, interrupter, interrupted string) {
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+
+	var session models.Session
+	err := database.GetCollection("sessions").FindOneAndUpdate(ctx,
+		bson.M{"_id": sessionId},
+		bson.M{"$inc": bson.M{"interruptions." + interrupter: 1}},
+		options.FindOneAndUpdate().SetReturnDocument(options.After),
+	).Decode(&session)
+	if err != nil {
+		log.Println("❌ Failed to record interruption:", err)
+		return
+	}
+
+	sendToUser(sessionId, interrupter, hub.KindControl, hub.ControlPayload{
+		Action:  hub.ActionInterrupt,
+		UserID:  interrupted,
+		Count:   session.Interruptions[interrupter],
+		Message: interruptWarningFor(interrupted),
+	})
+}
+
+// voiceModerationSchema is what ModerateVoiceInput asks the model for
+var voiceModerationSchema = llm.Schema{
+	Name: "voice moderation",
+	Fields: []llm.Field{
+		{Name: "tone", Type: llm.TypeString, Description: "one of respectful, hostile, passive, supportive, neutral", Required: true},
+		{Name: "empathy", Type: llm.TypeInteger, Description: "empathy score out of 10", Required: true, Min: 0, Max: 10},
+		{Name: "clarity", Type: llm.TypeInteger, Description: "clarity score out of 10", Required: true, Min: 0, Max: 10},
+		{Name: "respect", Type: llm.TypeInteger, Description: "respect score out of 10", Required: true, Min: 0, Max: 10},
+		{Name: "warning", Type: llm.TypeBoolean, Description: "true if this should trigger a warning to the speaker", Required: true},
+	},
+}
+
+// voiceModeration is a reply matching voiceModerationSchema
+type voiceModeration struct {
+	Tone    string `json:"tone"`
+	Empathy int    `json:"empathy"`
+	Clarity int    `json:"clarity"`
+	Respect int    `json:"respect"`
+	Warning bool   `json:"warning"`
+}
+
+// moderateUtterance asks the model to rate one spoken utterance
+func moderateUtterance(ctx context.Context, speaker, transcript, recent string) (voiceModeration, error) {
+	var result voiceModeration
+	req, err := prompts.Get(prompts.VoiceModeration).Render(prompts.Vars{
+		"speaker":    speaker,
+		"transcript": transcript,
+		"context":    recent,
+		"schema":     voiceModerationSchema.Instructions(),
+	})
+	if err != nil {
+		return result, err
+	}
+	err = llm.ChatStructured(ctx, llm.Default(), req, voiceModerationSchema, 1, &result)
+	return result, err
+}
+
+// ModerateVoiceInput (API) rates one utterance from an open session the caller takes part in
 func ModerateVoiceInput(c *fiber.Ctx) error {
 	var input struct {
+		SessionID  string `json:"sessionId"`
 		Transcript string `json:"transcript"`
 		Speaker    string `json:"speaker"`
 		Context    string `json:"context"`
@@ -24,45 +144,31 @@ func ModerateVoiceInput(c *fiber.Ctx) error {
 	if strings.TrimSpace(input.Transcript) == "" {
 		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{"error": "Transcript is required"})
 	}
+	if input.SessionID == "" {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{"error": "sessionId is required"})
+	}
 
-	// Load from env
-	apiKey := os.Getenv("OPENAI_API_KEY")
-	endpoint := os.Getenv("OPENAI_ENDPOINT")     // e.g. https://your-resource-name.openai.azure.com/
-	deployment := os.Getenv("OPENAI_DEPLOYMENT") // e.g. gpt-35-turbo
-	apiVersion := "2023-05-15"                   // required by Azure
-
-	config := openai.DefaultAzureConfig(apiKey, endpoint)
-	config.APIVersion = apiVersion
-
-	client := openai.NewClientWithConfig(config)
-
-	// Build moderation prompt
-	prompt := `
-You are a conversation moderator helping couples communicate better.
-Speaker: ` + input.Speaker + `
-Transcript: "` + input.Transcript + `"
-Context: "` + input.Context + `"
-
-Evaluate this input. Respond in JSON with:
-- tone: ["respectful", "hostile", "passive", "supportive", "neutral"]
-- empathy: score out of 10
-- clarity: score out of 10
-- respect: score out of 10
-- warning: true/false if this should trigger a warning to the speaker
-`
-
-	resp, err := client.CreateChatCompletion(context.Background(), openai.ChatCompletionRequest{
-		Model: deployment,
-		Messages: []openai.ChatCompletionMessage{
-			{Role: "system", Content: "You are a conversation moderator helping partners speak respectfully."},
-			{Role: "user", Content: prompt},
-		},
-	})
+	// 🔒 The model is only for couples mid-session, not a free endpoint for any account
+	lookupCtx, lookupCancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer lookupCancel()
+	session, ok := findSessionForUser(lookupCtx, input.SessionID, middleware.UserID(c))
+	if !ok {
+		return c.Status(fiber.StatusNotFound).JSON(fiber.Map{"error": "Session not found"})
+	}
+	if !isOpenSession(session) {
+		return c.Status(fiber.StatusConflict).JSON(fiber.Map{"error": "Session has ended"})
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 15*time.Second)
+	defer cancel()
+
+	result, err := moderateUtterance(ctx, input.Speaker, input.Transcript, input.Context)
 	if err != nil {
-		log.Println("OpenAI API error:", err)
+		log.Println("❌ AI moderation failed:", err)
 		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{"error": "AI moderation failed"})
 	}
 
-	result := resp.Choices[0].Message.Content
-	return c.JSON(fiber.Map{"moderation": result})
+	// 📦 Still a JSON string, as clients have always parsed it, now always well-formed
+	moderation, _ := json.Marshal(result)
+	return c.JSON(fiber.Map{"moderation": string(moderation)})
 }
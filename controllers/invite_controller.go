@@ -0,0 +1,342 @@
+package controllers
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"strings"
+	"time"
+
+	"mend/database"
+	"mend/middleware"
+	"mend/models"
+	"mend/utils"
+
+	"github.com/gofiber/fiber/v2"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/mongo"
+)
+
+const inviteTTL = 72 * time.Hour
+
+// InvitePartner godoc
+// @Summary      Create a partner invite code
+// @Description  Creates a one-time code the partner redeems to link accounts. Nothing is linked until they accept.
+// @Description  Any earlier pending invite from the caller is revoked. If an email is given, the code is mailed to it.
+// @Tags         Users
+// @Accept       json
+// @Produce      json
+// @Param        invite body map[string]string false "Optional partner email"
+// @Success      201 {object} models.PartnerInvite
+// @Failure      400,403,404,409,500 {object} map[string]string
+// @Router       /api/invite [post]
+func InvitePartner(c *fiber.Ctx) error {
+	var body struct {
+		Email string `json:"email"`
+	}
+	if len(c.Body()) > 0 {
+		if err := c.BodyParser(&body); err != nil {
+			return c.Status(400).JSON(fiber.Map{"error": "Invalid invite payload"})
+		}
+	}
+	body.Email = strings.TrimSpace(body.Email)
+
+	users := database.GetCollection("users")
+	invites := database.GetCollection("partnerInvites")
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+
+	var inviter models.User
+	if err := users.FindOne(ctx, bson.M{"id": middleware.UserID(c)}).Decode(&inviter); err != nil {
+		return c.Status(404).JSON(fiber.Map{"error": "Inviter not found"})
+	}
+
+	// 🔒 Unverified addresses may be typos; don't let them send invites
+	if !inviter.EmailVerified {
+		return c.Status(403).JSON(fiber.Map{"error": "Please verify your email before inviting a partner"})
+	}
+	if _, partnered := currentRelationship(ctx, inviter.ID); partnered {
+		return c.Status(409).JSON(fiber.Map{"error": "You are already linked with a partner"})
+	}
+
+	var invitee models.User
+	if body.Email != "" {
+		if strings.EqualFold(body.Email, inviter.Email) {
+			return c.Status(400).JSON(fiber.Map{"error": "You can't invite yourself"})
+		}
+		if err := users.FindOne(ctx, bson.M{"email": body.Email}).Decode(&invitee); err == nil {
+			if _, partnered := currentRelationship(ctx, invitee.ID); partnered {
+				return c.Status(409).JSON(fiber.Map{"error": "This person is already linked with a partner"})
+			}
+		}
+	}
+
+	// Only one live invite per inviter
+	now := time.Now()
+	_, err := invites.UpdateMany(ctx,
+		bson.M{"inviterId": inviter.ID, "status": models.InviteStatusPending},
+		bson.M{"$set": bson.M{"status": models.InviteStatusRevoked, "respondedAt": now.Unix()}},
+	)
+	if err != nil {
+		return c.Status(500).JSON(fiber.Map{"error": "Failed to create invite"})
+	}
+
+	code, err := utils.GenerateInviteCode()
+	if err != nil {
+		return c.Status(500).JSON(fiber.Map{"error": "Failed to create invite"})
+	}
+	invite := models.PartnerInvite{
+		ID:           utils.GeneratePartnerID(),
+		Code:         code,
+		InviterID:    inviter.ID,
+		InviteeEmail: body.Email,
+		Status:       models.InviteStatusPending,
+		CreatedAt:    now.Unix(),
+		ExpiresAt:    now.Add(inviteTTL).Unix(),
+	}
+	if _, err := invites.InsertOne(ctx, invite); err != nil {
+		return c.Status(500).JSON(fiber.Map{"error": "Failed to create invite"})
+	}
+
+	// ✅ Send Email to invitee
+	if invite.InviteeEmail != "" {
+		name := invitee.Name
+		if name == "" {
+			name = "there"
+		}
+		subject := "You’ve Been Invited to Mend 💜"
+		bodyHTML := fmt.Sprintf(`
+			<h2>Hello %s,</h2>
+			<p><strong>%s</strong> has invited you to join them on Mend, a space to improve communication and connection.</p>
+			<p>Your invite code: <strong>%s</strong></p>
+			<p>Open the app and enter this code to link accounts. It expires in 3 days and can only be used once.</p>
+			<br/>
+			<p>With love,<br/>The Mend Team</p>
+		`, name, inviter.Name, invite.Code)
+
+		go utils.SendEmail(invite.InviteeEmail, subject, bodyHTML)
+	}
+
+	return c.Status(201).JSON(invite)
+}
+
+// AcceptInvite godoc
+// @Summary Accept an invitation
+// @Description Redeems a partner invite code and links both accounts
+// @Tags Users
+// @Accept json
+// @Produce json
+// @Param accept body map[string]string true "code"
+// @Success 200 {object} map[string]string
+// @Failure 400,403,404,409,410,429,500 {object} map[string]string
+// @Router /api/accept-invite [post]
+func AcceptInvite(c *fiber.Ctx) error {
+	var body struct {
+		Code string `json:"code"`
+	}
+	if err := c.BodyParser(&body); err != nil || body.Code == "" {
+		return c.Status(400).JSON(fiber.Map{"error": "Missing invite code"})
+	}
+
+	limitKey := inviteCodeKey(middleware.UserID(c))
+	if wait := inviteCodeLimiter.Check(limitKey); wait > 0 {
+		return tooManyAttempts(c, wait, "Too many invite codes tried. Please try again later.")
+	}
+
+	users := database.GetCollection("users")
+	invites := database.GetCollection("partnerInvites")
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+
+	invite, err := findPendingInvite(ctx, body.Code)
+	if err != nil {
+		return inviteLookupError(c, limitKey, err)
+	}
+
+	var you, partner models.User
+	if err := users.FindOne(ctx, bson.M{"id": middleware.UserID(c)}).Decode(&you); err != nil {
+		return c.Status(404).JSON(fiber.Map{"error": "User not found"})
+	}
+	if invite.InviterID == you.ID {
+		return c.Status(400).JSON(fiber.Map{"error": "You can't accept your own invite"})
+	}
+	if !invitedUser(invite, you) {
+		inviteCodeLimiter.Fail(limitKey)
+		return c.Status(403).JSON(fiber.Map{"error": "This invite was sent to someone else"})
+	}
+	if err := users.FindOne(ctx, bson.M{"id": invite.InviterID}).Decode(&partner); err != nil {
+		return c.Status(404).JSON(fiber.Map{"error": "Inviter not found"})
+	}
+
+	// Claim the code and start the relationship together: either both happen or neither.
+	// The unique index on active members rejects the insert if either of you is already partnered.
+	var relationship models.Relationship
+	err = database.WithTransaction(ctx, func(sc mongo.SessionContext) error {
+		res, err := invites.UpdateOne(sc,
+			bson.M{"_id": invite.ID, "status": models.InviteStatusPending},
+			bson.M{"$set": bson.M{
+				"status":      models.InviteStatusAccepted,
+				"inviteeId":   you.ID,
+				"respondedAt": time.Now().Unix(),
+			}},
+		)
+		if err != nil {
+			return err
+		}
+		if res.ModifiedCount == 0 {
+			return errInviteNotFound
+		}
+
+		// 💞 Every link starts a fresh relationship history, even for couples re-linking
+		relationship, err = createRelationship(sc, partner.ID, you.ID)
+		return err
+	})
+	switch {
+	case errors.Is(err, errInviteNotFound):
+		return c.Status(409).JSON(fiber.Map{"error": "Invite is no longer valid"})
+	case mongo.IsDuplicateKeyError(err):
+		return c.Status(409).JSON(fiber.Map{"error": "One of you is already linked with a partner"})
+	case err != nil:
+		return c.Status(500).JSON(fiber.Map{"error": "Failed to accept invite"})
+	}
+
+	return c.JSON(fiber.Map{
+		"message":        "Invite accepted. You're now linked!",
+		"partnerId":      partner.ID,
+		"relationshipId": relationship.ID,
+	})
+}
+
+// DeclineInvite godoc
+// @Summary Decline an invitation
+// @Description Marks a pending invite code as declined so it can't be used
+// @Tags Users
+// @Accept json
+// @Produce json
+// @Param decline body map[string]string true "code"
+// @Success 200 {object} map[string]string
+// @Failure 400,403,404,410,429,500 {object} map[string]string
+// @Router /api/invite/decline [post]
+func DeclineInvite(c *fiber.Ctx) error {
+	var body struct {
+		Code string `json:"code"`
+	}
+	if err := c.BodyParser(&body); err != nil || body.Code == "" {
+		return c.Status(400).JSON(fiber.Map{"error": "Missing invite code"})
+	}
+
+	callerId := middleware.UserID(c)
+	limitKey := inviteCodeKey(callerId)
+	if wait := inviteCodeLimiter.Check(limitKey); wait > 0 {
+		return tooManyAttempts(c, wait, "Too many invite codes tried. Please try again later.")
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+
+	invite, err := findPendingInvite(ctx, body.Code)
+	if err != nil {
+		return inviteLookupError(c, limitKey, err)
+	}
+	if invite.InviterID == callerId {
+		return c.Status(400).JSON(fiber.Map{"error": "Use revoke for your own invite"})
+	}
+
+	// 🔒 Only the person it was sent to may turn it down
+	var you models.User
+	if err := database.GetCollection("users").FindOne(ctx, bson.M{"id": callerId}).Decode(&you); err != nil {
+		return c.Status(404).JSON(fiber.Map{"error": "User not found"})
+	}
+	if !invitedUser(invite, you) {
+		inviteCodeLimiter.Fail(limitKey)
+		return c.Status(403).JSON(fiber.Map{"error": "This invite was sent to someone else"})
+	}
+
+	_, err = database.GetCollection("partnerInvites").UpdateOne(ctx,
+		bson.M{"_id": invite.ID, "status": models.InviteStatusPending},
+		bson.M{"$set": bson.M{
+			"status":      models.InviteStatusDeclined,
+			"inviteeId":   callerId,
+			"respondedAt": time.Now().Unix(),
+		}},
+	)
+	if err != nil {
+		return c.Status(500).JSON(fiber.Map{"error": "Failed to decline invite"})
+	}
+	return c.JSON(fiber.Map{"message": "Invite declined"})
+}
+
+// RevokeInvite godoc
+// @Summary Revoke your invitation
+// @Description Cancels a pending invite created by the caller
+// @Tags Users
+// @Produce json
+// @Param id path string true "Invite ID"
+// @Success 200 {object} map[string]string
+// @Failure 404,500 {object} map[string]string
+// @Router /api/invite/{id} [delete]
+func RevokeInvite(c *fiber.Ctx) error {
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+
+	res, err := database.GetCollection("partnerInvites").UpdateOne(ctx,
+		bson.M{"_id": c.Params("id"), "inviterId": middleware.UserID(c), "status": models.InviteStatusPending},
+		bson.M{"$set": bson.M{"status": models.InviteStatusRevoked, "respondedAt": time.Now().Unix()}},
+	)
+	if err != nil {
+		return c.Status(500).JSON(fiber.Map{"error": "Failed to revoke invite"})
+	}
+	if res.MatchedCount == 0 {
+		return c.Status(404).JSON(fiber.Map{"error": "No pending invite found"})
+	}
+	return c.JSON(fiber.Map{"message": "Invite revoked"})
+}
+
+var (
+	errInviteNotFound = errors.New("invite not found")
+	errInviteExpired  = errors.New("invite expired")
+)
+
+// invitedUser reports whether user may answer the invite: anyone for a code-only invite,
+// otherwise only the owner of the address it was sent to
+func invitedUser(invite models.PartnerInvite, user models.User) bool {
+	return invite.InviteeEmail == "" || strings.EqualFold(invite.InviteeEmail, user.Email)
+}
+
+// findPendingInvite looks up a pending invite by code, expiring it on the way if it's too old
+func findPendingInvite(ctx context.Context, code string) (models.PartnerInvite, error) {
+	invites := database.GetCollection("partnerInvites")
+
+	var invite models.PartnerInvite
+	err := invites.FindOne(ctx, bson.M{
+		"code":   utils.NormalizeInviteCode(code),
+		"status": models.InviteStatusPending,
+	}).Decode(&invite)
+	if errors.Is(err, mongo.ErrNoDocuments) {
+		return invite, errInviteNotFound
+	}
+	if err != nil {
+		return invite, err
+	}
+
+	if invite.ExpiresAt <= time.Now().Unix() {
+		_, _ = invites.UpdateOne(ctx,
+			bson.M{"_id": invite.ID, "status": models.InviteStatusPending},
+			bson.M{"$set": bson.M{"status": models.InviteStatusExpired}},
+		)
+		return invite, errInviteExpired
+	}
+	return invite, nil
+}
+
+func inviteLookupError(c *fiber.Ctx, limitKey string, err error) error {
+	switch {
+	case errors.Is(err, errInviteNotFound):
+		inviteCodeLimiter.Fail(limitKey) // A wrong guess
+		return c.Status(404).JSON(fiber.Map{"error": "Invite code not found or already used"})
+	case errors.Is(err, errInviteExpired):
+		return c.Status(410).JSON(fiber.Map{"error": "Invite code has expired"})
+	default:
+		return c.Status(500).JSON(fiber.Map{"error": "Failed to look up invite"})
+	}
+}
@@ -0,0 +1,109 @@
+package controllers
+
+import (
+	"context"
+	"net/http/httptest"
+	"reflect"
+	"strings"
+	"testing"
+
+	"mend/hub"
+	"mend/llm"
+	"mend/llm/fakellm"
+	"mend/models"
+	"mend/prompts"
+
+	"github.com/google/uuid"
+)
+
+// useFakeLLM points every AI call at a fresh fake server for the rest of the test
+func useFakeLLM(t *testing.T) *fakellm.Server {
+	t.Helper()
+	fake := fakellm.New()
+	srv := httptest.NewServer(fake)
+	previous := llm.Default()
+	llm.SetDefault(llm.NewLocal(srv.URL+"/v1", "fake", ""))
+	t.Cleanup(func() {
+		llm.SetDefault(previous)
+		srv.Close()
+	})
+	return fake
+}
+
+// TestSessionAIWithFakeLLM runs the AI side of a session, in the order a session calls it,
+// against the offline fake: the chat moderator and the therapist's interjection while the couple
+// talks, then the auto-score and the reflection once the session has ended
+func TestSessionAIWithFakeLLM(t *testing.T) {
+	fake := useFakeLLM(t)
+	ctx := context.Background()
+
+	conversation := []models.Message{
+		{SpeakerId: "alice", Text: "I feel like you never listen when I talk about work"},
+		{SpeakerId: "bob", Text: "I'm sorry, I want to understand"},
+	}
+
+	// 💬 Chat: each stored message is moderated; the first is flagged and earns an interjection
+	var flagged []int64
+	moderatorAllowed = func(context.Context, string) bool { return true }
+	moderatorFlag = func(_ string, seq int64) error { flagged = append(flagged, seq); return nil }
+	defer func() { moderatorAllowed, moderatorFlag = sessionAllowsAI, flagMessage }()
+	sessionId := uuid.NewString()
+	conn := newTestConn()
+	client := sessionHub.Register(conn, sessionId, "bob")
+	defer client.Close()
+
+	for i := range conversation {
+		conversation[i].SessionId, conversation[i].Seq = sessionId, int64(i+1)
+		moderateChatMessage(conversation[i], sessionId)
+	}
+	waitForCondition(t, "the moderator's warning", func() bool { return conn.count(hub.KindAIWarning) == 1 })
+	if !reflect.DeepEqual(flagged, []int64{1}) {
+		t.Errorf("flagged seqs %v, want only the absolute language at seq 1", flagged)
+	}
+	reply, promptVersion, err := therapistReply(ctx, therapistInput{
+		Speaker:         "alice",
+		Message:         conversation[0].Text,
+		Conversation:    "(no earlier messages)",
+		Partners:        "Not available.",
+		PreviousSummary: "Not available.",
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !strings.Contains(reply, `"I feel"`) || promptVersion != prompts.Get(prompts.TherapistReply).ID() {
+		t.Errorf("interjection = (%q, %s)", reply, promptVersion)
+	}
+	conversation = append(conversation, models.Message{SpeakerId: "AI", Text: reply})
+
+	// 🧠 End: both partners are scored, interruptions capping Listening
+	score, err := generateAIScore(conversation, map[string]int{"bob": 3})
+	if err != nil {
+		t.Fatal(err)
+	}
+	applyInterruptions(&score, 3)
+	if score.Empathy != 4 || score.Clarity != 5 || score.Listening != 2 || score.Interruptions != 3 || score.Summary == "" {
+		t.Errorf("score = %+v, want 4s and 5 clarity, Listening capped at 2 by 3 interruptions", score)
+	}
+	if score.PromptVersion != prompts.Get(prompts.Score).ID() {
+		t.Errorf("score prompt version = %q", score.PromptVersion)
+	}
+
+	// 🪞 Reflection on the whole session
+	reflection, promptVersion, err := generateAIReflection(conversation)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !strings.HasPrefix(reflection, "You both showed up") || promptVersion != prompts.Get(prompts.Reflection).ID() {
+		t.Errorf("reflection = (%q, %s)", reflection, promptVersion)
+	}
+
+	// The reflection saw the therapist's interjection as part of the transcript
+	requests := fake.Requests()
+	if len(requests) != 5 {
+		t.Fatalf("fake got %d requests, want 5", len(requests))
+	}
+	last := requests[len(requests)-1].Messages
+	if !strings.Contains(last[len(last)-1].Content, "Therapist AI: "+reply) {
+		t.Error("the reflection prompt is missing the therapist's interjection")
+	}
+}
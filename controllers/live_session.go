@@ -0,0 +1,461 @@
+package controllers
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"log"
+	"math"
+	"sync"
+	"time"
+
+	"mend/config"
+	"mend/database"
+	"mend/hub"
+	"mend/models"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+const (
+	minCoolingOff = 30 * time.Second
+	maxCoolingOff = 30 * time.Minute
+
+	// The moderator calls a time-out after this many warnings within the window
+	escalationStrikes = 3
+	escalationWindow  = 2 * time.Minute
+)
+
+// liveSession is the shared live side of a running session: the cooling-off deadline, who
+// holds the floor, who spoke when, who is connected, the moderator's recent warnings and the
+// pending WebRTC offer. Every instance with clients in the session keeps a replica of it (see
+// hub.Replica) and only changes it through a liveChange, so partners connected to different
+// replicas see the same state. The sessions collection stays the source of truth for status.
+type liveSession struct {
+	PausedUntil time.Time                 `json:"pausedUntil"` // Zero when not paused
+	Strikes     []time.Time               `json:"strikes,omitempty"`
+	Floor       floorState                `json:"floor"`
+	Utterances  map[string]utterance      `json:"utterances,omitempty"` // Latest transcript span per speaker
+	Presence    map[string]*presenceState `json:"presence,omitempty"`   // Per user
+	Offer       pendingOffer              `json:"offer"`                // WebRTC offer awaiting an answer
+}
+
+// newLiveSession is the hub's replica factory
+func newLiveSession(string) hub.Replica {
+	return &liveSession{}
+}
+
+// Live state changes
+const (
+	liveOpPause        = "pause"
+	liveOpResume       = "resume"
+	liveOpStrike       = "strike"
+	liveOpEnd          = "end"
+	liveOpConnect      = "connect"
+	liveOpDisconnect   = "disconnect"
+	liveOpExpire       = "expire"
+	liveOpActivity     = "activity"
+	liveOpFloorRequest = "floor_request"
+	liveOpFloorRelease = "floor_release"
+	liveOpUtterance    = "utterance"
+	liveOpSignal       = "signal"
+)
+
+// liveChange is one change to a liveSession. Whatever depends on the clock or the database is
+// worked out by the instance making the change and carried in it, so every replica applying
+// it gets the same result.
+type liveChange struct {
+	Op     string     `json:"op"`
+	At     time.Time  `json:"at"`               // When the change was made
+	User   string     `json:"user,omitempty"`   // The partner it concerns
+	Conn   string     `json:"conn,omitempty"`   // Socket ID for connect and disconnect
+	Until  time.Time  `json:"until"`            // End of a time-out or of a floor grant
+	Gen    int        `json:"gen,omitempty"`    // Generation a timer was started for; 0 for any
+	Status string     `json:"status,omitempty"` // Activity: typing or speaking
+	Active bool       `json:"active,omitempty"` // Activity on/off; floor request queues if taken
+	Span   *utterance `json:"span,omitempty"`
+	Signal string     `json:"signal,omitempty"` // Signal type
+	ID     string     `json:"id,omitempty"`     // Signal frame ID
+	Wins   string     `json:"wins,omitempty"`   // Partner whose offer wins glare (partner A)
+	Other  string     `json:"other,omitempty"`  // The other partner
+}
+
+// Apply makes one change and returns its outcome (see each op's apply method)
+func (l *liveSession) Apply(raw []byte) interface{} {
+	var change liveChange
+	if err := json.Unmarshal(raw, &change); err != nil {
+		log.Println("❌ Failed to decode live session change:", err)
+		return nil
+	}
+	switch change.Op {
+	case liveOpPause:
+		l.PausedUntil = change.Until
+	case liveOpResume:
+		l.PausedUntil = time.Time{}
+	case liveOpStrike:
+		return l.applyStrike(change.At)
+	case liveOpEnd:
+		*l = liveSession{}
+	case liveOpConnect:
+		return l.applyConnect(change)
+	case liveOpDisconnect:
+		return l.applyDisconnect(change)
+	case liveOpExpire:
+		return l.applyExpire(change)
+	case liveOpActivity:
+		return l.applyActivity(change)
+	case liveOpFloorRequest:
+		return l.applyFloorRequest(change)
+	case liveOpFloorRelease:
+		return l.applyFloorRelease(change)
+	case liveOpUtterance:
+		return l.applyUtterance(change)
+	case liveOpSignal:
+		return l.applySignal(change)
+	}
+	return nil
+}
+
+// Snapshot encodes the whole state for an instance joining the session
+func (l *liveSession) Snapshot() []byte {
+	snapshot, err := json.Marshal(l)
+	if err != nil {
+		log.Println("❌ Failed to encode live session snapshot:", err)
+	}
+	return snapshot
+}
+
+// Restore replaces the state with another instance's snapshot
+func (l *liveSession) Restore(snapshot []byte) error {
+	var restored liveSession
+	if err := json.Unmarshal(snapshot, &restored); err != nil {
+		return err
+	}
+	*l = restored
+	return nil
+}
+
+// liveRef is a session's live state as seen through one hub
+type liveRef struct {
+	hub       *hub.Hub
+	sessionId string
+}
+
+func getLiveSession(sessionId string) liveRef {
+	return liveRef{hub: sessionHub, sessionId: sessionId}
+}
+
+// update applies a change on every replica and returns this replica's outcome, nil if the
+// change couldn't be made
+func (r liveRef) update(change liveChange) interface{} {
+	if change.At.IsZero() {
+		change.At = time.Now()
+	}
+	raw, err := json.Marshal(change)
+	if err != nil {
+		log.Println("❌ Failed to encode live session change:", err)
+		return nil
+	}
+	result, err := r.hub.Update(r.sessionId, raw)
+	if err != nil {
+		log.Printf("❌ Failed to update live state of session %s: %v\n", r.sessionId, err)
+		return nil
+	}
+	return result
+}
+
+// read calls fn with the up-to-date live state; fn must not change it
+func (r liveRef) read(fn func(l *liveSession)) {
+	err := r.hub.Read(r.sessionId, func(state hub.Replica) {
+		fn(state.(*liveSession))
+	})
+	if err != nil {
+		log.Printf("❌ Failed to read live state of session %s: %v\n", r.sessionId, err)
+		fn(&liveSession{})
+	}
+}
+
+// hold keeps this instance's replica of the session alive until release is called
+func (r liveRef) hold() (release func()) {
+	return r.hub.Hold(r.sessionId)
+}
+
+// countdowns stops the cooling-off countdowns this instance runs, by session
+var (
+	countdowns     = make(map[string]chan struct{})
+	countdownsLock sync.Mutex
+)
+
+// dropLiveSession stops this instance's countdown and clears the live state of a session that is over
+func dropLiveSession(sessionId string) {
+	stopLocalCountdown(sessionId)
+	getLiveSession(sessionId).update(liveChange{Op: liveOpEnd})
+}
+
+// coolingOffRemaining is how much of the current time-out is left (0 when not paused)
+func (r liveRef) coolingOffRemaining() time.Duration {
+	var until time.Time
+	r.read(func(l *liveSession) { until = l.PausedUntil })
+	if until.IsZero() {
+		return 0
+	}
+	return max(time.Until(until), 0)
+}
+
+// isPaused reports whether transcript messages should currently be rejected
+func (r liveRef) isPaused() bool {
+	paused := false
+	r.read(func(l *liveSession) { paused = !l.PausedUntil.IsZero() })
+	return paused
+}
+
+// pausedUntil is when the current time-out ends, zero when not paused
+func (r liveRef) pausedUntil() time.Time {
+	var until time.Time
+	r.read(func(l *liveSession) { until = l.PausedUntil })
+	return until
+}
+
+// stopCooldown ends the time-out on every replica
+func (r liveRef) stopCooldown() {
+	stopLocalCountdown(r.sessionId)
+	r.update(liveChange{Op: liveOpResume})
+}
+
+// strike records a moderator warning and reports whether the session has escalated
+func (r liveRef) strike(now time.Time) bool {
+	escalated, _ := r.update(liveChange{Op: liveOpStrike, At: now}).(bool)
+	return escalated
+}
+
+func (l *liveSession) applyStrike(now time.Time) bool {
+	recent := l.Strikes[:0]
+	for _, t := range l.Strikes {
+		if now.Sub(t) < escalationWindow {
+			recent = append(recent, t)
+		}
+	}
+	l.Strikes = append(recent, now)
+	if len(l.Strikes) >= escalationStrikes {
+		l.Strikes = nil
+		return true
+	}
+	return false
+}
+
+// stopLocalCountdown stops the countdown this instance runs for a session, if any
+func stopLocalCountdown(sessionId string) {
+	countdownsLock.Lock()
+	defer countdownsLock.Unlock()
+	if stop := countdowns[sessionId]; stop != nil {
+		close(stop)
+		delete(countdowns, sessionId)
+	}
+}
+
+// startCooldown pauses the live state until `until` and (re)starts the countdown broadcast.
+// The countdown runs on this instance and keeps the session's replica alive while it does.
+func startCooldown(sessionId string, until time.Time) {
+	live := getLiveSession(sessionId)
+	live.update(liveChange{Op: liveOpPause, Until: until})
+
+	countdownsLock.Lock()
+	if stop := countdowns[sessionId]; stop != nil {
+		close(stop)
+	}
+	stop := make(chan struct{})
+	countdowns[sessionId] = stop
+	countdownsLock.Unlock()
+
+	release := live.hold()
+	go func() {
+		defer release()
+		runCountdown(sessionId, until, stop)
+	}()
+}
+
+// runCountdown ticks the remaining cooling-off time to both partners, then resumes the session.
+// It stops early when the session is resumed or paused again, on any instance.
+func runCountdown(sessionId string, until time.Time, stop chan struct{}) {
+	ticker := time.NewTicker(time.Second)
+	defer ticker.Stop()
+
+	live := getLiveSession(sessionId)
+	for {
+		if !live.pausedUntil().Equal(until) {
+			return
+		}
+		remaining := time.Until(until)
+		if remaining <= 0 {
+			break
+		}
+		broadcastControl(sessionId, hub.ControlPayload{
+			Action:    hub.ActionCoolingOff,
+			Remaining: int(math.Ceil(remaining.Seconds())),
+		})
+		select {
+		case <-stop:
+			return
+		case <-ticker.C:
+		}
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+
+	// Only resume the time-out this countdown belongs to; a newer pause has its own
+	var session models.Session
+	err := database.GetCollection("sessions").FindOne(ctx, bson.M{
+		"_id":         sessionId,
+		"status":      models.SessionStatusPaused,
+		"pausedUntil": until.Unix(),
+	}).Decode(&session)
+	if err != nil {
+		return
+	}
+	if _, err := resumeSession(ctx, session, ""); err != nil {
+		log.Println("❌ Failed to resume session after cooling-off:", err)
+	}
+}
+
+// restoreCooldown picks a paused session's countdown back up, e.g. after a restart
+func restoreCooldown(sessionId string) {
+	if getLiveSession(sessionId).isPaused() {
+		return
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+
+	var session models.Session
+	err := database.GetCollection("sessions").FindOne(ctx, bson.M{"_id": sessionId}).Decode(&session)
+	if err == nil && session.Status == models.SessionStatusPaused {
+		startCooldown(sessionId, time.Unix(session.PausedUntil, 0))
+	}
+}
+
+// coolingOffDuration picks the time-out length: the requested one if it is in range,
+// otherwise the couple's setting, otherwise COOLING_OFF_SECONDS (default 2 minutes)
+func coolingOffDuration(ctx context.Context, relationshipId string, requestedSeconds int) time.Duration {
+	clamp := func(d time.Duration) time.Duration {
+		return min(max(d, minCoolingOff), maxCoolingOff)
+	}
+	if requestedSeconds > 0 {
+		return clamp(time.Duration(requestedSeconds) * time.Second)
+	}
+	var relationship models.Relationship
+	err := database.GetCollection("relationships").FindOne(ctx, bson.M{"_id": relationshipId}).Decode(&relationship)
+	if err == nil && relationship.Settings.CoolingOffSeconds > 0 {
+		return clamp(time.Duration(relationship.Settings.CoolingOffSeconds) * time.Second)
+	}
+	return clamp(time.Duration(config.GetInt("COOLING_OFF_SECONDS", 120)) * time.Second)
+}
+
+// coolingOffError rejects resuming before the time-out is over
+type coolingOffError struct {
+	Remaining time.Duration
+}
+
+func (e coolingOffError) Error() string {
+	return fmt.Sprintf("Cooling-off still running, %d seconds left", int(math.Ceil(e.Remaining.Seconds())))
+}
+
+// pauseSession calls a time-out: the session is paused for the cooling-off period and
+// both partners get a countdown. `by` is the partner who asked, or "AI" for the moderator.
+func pauseSession(ctx context.Context, session models.Session, by string, requestedSeconds int) (models.Session, error) {
+	d := coolingOffDuration(ctx, session.RelationshipID, requestedSeconds)
+	until := time.Now().Add(d)
+
+	session, err := transitionSession(ctx, session, models.SessionStatusPaused, by, bson.M{
+		"$set": bson.M{"pausedUntil": until.Unix()},
+	})
+	if err != nil {
+		return session, err
+	}
+
+	clearFloor(session.ID)
+	startCooldown(session.ID, until)
+	broadcastControl(session.ID, hub.ControlPayload{
+		Action:      hub.ActionSessionPaused,
+		By:          by,
+		Seconds:     int(d.Seconds()),
+		PausedUntil: until.Unix(),
+	})
+	return session, nil
+}
+
+// resumeSession ends a time-out. Partners can only resume once the cooling-off is over;
+// the server (by == "") resumes when the countdown runs out.
+func resumeSession(ctx context.Context, session models.Session, by string) (models.Session, error) {
+	if session.Status == models.SessionStatusPaused && by != "" {
+		if remaining := time.Until(time.Unix(session.PausedUntil, 0)); remaining > 0 {
+			return session, coolingOffError{Remaining: remaining}
+		}
+	}
+
+	session, err := transitionSession(ctx, session, models.SessionStatusActive, by, bson.M{
+		"$unset": bson.M{"pausedUntil": ""},
+	})
+	if err != nil {
+		return session, err
+	}
+
+	getLiveSession(session.ID).stopCooldown()
+	broadcastControl(session.ID, hub.ControlPayload{Action: hub.ActionSessionResumed, By: by})
+	return session, nil
+}
+
+// aiInterjectionsAllowed reports whether the couple lets the therapist AI step into their
+// live sessions. A relationship that can't be loaded counts as a no.
+func aiInterjectionsAllowed(ctx context.Context, relationshipId string) bool {
+	var relationship models.Relationship
+	err := database.GetCollection("relationships").FindOne(ctx,
+		bson.M{"_id": relationshipId},
+		options.FindOne().SetProjection(bson.M{"settings.aiInterjections": 1}),
+	).Decode(&relationship)
+	return err == nil && relationship.Settings.AIInterjections
+}
+
+// sessionAllowsAI is aiInterjectionsAllowed for the couple a session belongs to
+func sessionAllowsAI(ctx context.Context, sessionId string) bool {
+	var session models.Session
+	err := database.GetCollection("sessions").FindOne(ctx,
+		bson.M{"_id": sessionId},
+		options.FindOne().SetProjection(bson.M{"relationshipId": 1}),
+	).Decode(&session)
+	return err == nil && aiInterjectionsAllowed(ctx, session.RelationshipID)
+}
+
+// Seams for the moderator's database work, swapped out in tests
+var (
+	moderatorAllowed = sessionAllowsAI
+	moderatorPause   = pauseForEscalation
+	moderatorFlag    = flagMessage
+)
+
+// warnAndStrike broadcasts a moderator warning about something a partner said. Repeated
+// warnings in a short time mean the argument is escalating, so the third calls a time-out.
+func warnAndStrike(sessionId, warning, speaker string) {
+	broadcastToSession(sessionId, hub.KindAIWarning, hub.AIWarningPayload{Message: warning, Speaker: speaker})
+	if getLiveSession(sessionId).strike(time.Now()) {
+		go moderatorPause(sessionId)
+	}
+}
+
+// pauseForEscalation lets the moderator call a time-out, unless the couple turned AI interjections off
+func pauseForEscalation(sessionId string) {
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+
+	var session models.Session
+	if err := database.GetCollection("sessions").FindOne(ctx, bson.M{"_id": sessionId}).Decode(&session); err != nil {
+		return
+	}
+	if session.Status != models.SessionStatusActive || !aiInterjectionsAllowed(ctx, session.RelationshipID) {
+		return
+	}
+
+	if _, err := pauseSession(ctx, session, "AI", 0); err != nil {
+		log.Println("❌ Moderator failed to pause session:", err)
+	}
+}
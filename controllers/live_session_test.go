@@ -0,0 +1,238 @@
+package controllers
+
+import (
+	"context"
+	"encoding/json"
+	"reflect"
+	"testing"
+	"time"
+
+	"mend/hub"
+)
+
+// newTestReplicas returns one session's live state as seen by two instances sharing an
+// in-memory broker. Each instance holds its replica for the whole test, like a connected socket.
+func newTestReplicas(t *testing.T) (liveRef, liveRef) {
+	t.Helper()
+	broker := hub.NewMemoryBroker()
+	h1 := hub.New(hub.Config{Broker: broker, NewReplica: newLiveSession})
+	h2 := hub.New(hub.Config{Broker: broker, NewReplica: newLiveSession})
+	r1, r2 := liveRef{hub: h1, sessionId: "s1"}, liveRef{hub: h2, sessionId: "s1"}
+	release1, release2 := r1.hold(), r2.hold()
+	t.Cleanup(func() {
+		release1()
+		release2()
+		h1.Shutdown(context.Background())
+		h2.Shutdown(context.Background())
+	})
+	return r1, r2
+}
+
+// snapshot is the replica's state as JSON, for comparing replicas
+func snapshot(r liveRef) string {
+	var s string
+	r.read(func(l *liveSession) { s = string(l.Snapshot()) })
+	return s
+}
+
+func presenceOf(r liveRef, userId string) string {
+	status := presenceOffline
+	r.read(func(l *liveSession) {
+		if st, ok := l.Presence[userId]; ok {
+			status = st.Status
+		}
+	})
+	return status
+}
+
+func assertSameState(t *testing.T, r1, r2 liveRef) {
+	t.Helper()
+	if s1, s2 := snapshot(r1), snapshot(r2); s1 != s2 {
+		t.Fatalf("replicas differ:\n%s\n%s", s1, s2)
+	}
+}
+
+func TestLivePauseMatchesAcrossReplicas(t *testing.T) {
+	r1, r2 := newTestReplicas(t)
+	until := time.Now().Add(time.Minute)
+
+	r1.update(liveChange{Op: liveOpPause, Until: until})
+	if !r2.isPaused() {
+		t.Fatal("a time-out called on one instance doesn't pause the other")
+	}
+	if remaining := r2.coolingOffRemaining(); remaining <= 50*time.Second {
+		t.Errorf("other instance has %v of the time-out left, want about a minute", remaining)
+	}
+	assertSameState(t, r1, r2)
+
+	r2.stopCooldown()
+	if r1.isPaused() {
+		t.Fatal("resuming on one instance leaves the other paused")
+	}
+	assertSameState(t, r1, r2)
+}
+
+func TestLivePresenceMatchesAcrossReplicas(t *testing.T) {
+	r1, r2 := newTestReplicas(t)
+
+	r1.update(liveChange{Op: liveOpConnect, User: "alice", Conn: "a1"})
+	res, _ := r2.update(liveChange{Op: liveOpConnect, User: "bob", Conn: "b1"}).(connectResult)
+	if !res.Changed || len(res.Others) != 1 || res.Others[0].UserID != "alice" {
+		t.Fatalf("bob joining on the other instance = %+v, want to come online and see alice", res)
+	}
+	assertSameState(t, r1, r2)
+
+	// Alice drops on instance 1 and reconnects to instance 2 before her grace period ends
+	gone, _ := r1.update(liveChange{Op: liveOpDisconnect, User: "alice", Conn: "a1"}).(disconnectResult)
+	if !gone.Reconnecting {
+		t.Fatal("alice's last socket closing doesn't make her reconnecting")
+	}
+	if presenceOf(r2, "alice") != presenceReconnecting {
+		t.Fatal("the other instance doesn't see alice reconnecting")
+	}
+	r2.update(liveChange{Op: liveOpConnect, User: "alice", Conn: "a2"})
+
+	// Instance 1's grace timer fires anyway and must not mark her gone
+	if left, _ := r1.update(liveChange{Op: liveOpExpire, User: "alice", Gen: gone.Gen}).(bool); left {
+		t.Fatal("grace timer marked alice gone after she reconnected to another instance")
+	}
+	if presenceOf(r1, "alice") != presenceOnline || presenceOf(r2, "alice") != presenceOnline {
+		t.Fatal("alice isn't online on both instances after reconnecting")
+	}
+	assertSameState(t, r1, r2)
+
+	// A second socket on the other instance keeps her online when one closes
+	r1.update(liveChange{Op: liveOpConnect, User: "alice", Conn: "a3"})
+	if still, _ := r2.update(liveChange{Op: liveOpDisconnect, User: "alice", Conn: "a2"}).(disconnectResult); still.Reconnecting {
+		t.Fatal("alice counts as reconnecting while a socket on another instance is open")
+	}
+
+	// Once every socket is gone and the grace period runs out, she has left everywhere
+	gone, _ = r1.update(liveChange{Op: liveOpDisconnect, User: "alice", Conn: "a3"}).(disconnectResult)
+	if left, _ := r2.update(liveChange{Op: liveOpExpire, User: "alice", Gen: gone.Gen}).(bool); !left {
+		t.Fatal("alice didn't leave when her grace period ran out")
+	}
+	if presenceOf(r1, "alice") != presenceOffline {
+		t.Fatal("the first instance doesn't see alice gone")
+	}
+	assertSameState(t, r1, r2)
+}
+
+func TestLiveFloorMatchesAcrossReplicas(t *testing.T) {
+	r1, r2 := newTestReplicas(t)
+	expires := time.Now().Add(time.Minute)
+
+	first, _ := r1.update(liveChange{Op: liveOpFloorRequest, User: "alice", Until: expires}).(floorResult)
+	r1.update(liveChange{Op: liveOpFloorRelease, User: "alice"})
+	granted, _ := r1.update(liveChange{Op: liveOpFloorRequest, User: "alice", Until: expires}).(floorResult)
+	if !first.Granted || !granted.Granted {
+		t.Fatal("a free floor wasn't granted")
+	}
+	taken, _ := r2.update(liveChange{Op: liveOpFloorRequest, User: "bob", Until: expires, Active: true}).(floorResult)
+	if taken.Granted || !taken.Queued || taken.Holder != "alice" {
+		t.Fatalf("bob asking on the other instance = %+v, want queued behind alice", taken)
+	}
+	assertSameState(t, r1, r2)
+
+	// The time_up timer of alice's first grant fires late and does nothing
+	if stale, _ := r2.update(liveChange{Op: liveOpFloorRelease, User: "alice", Gen: first.Gen}).(releaseResult); stale.Released {
+		t.Fatal("a stale timer released the floor")
+	}
+	released, _ := r2.update(liveChange{Op: liveOpFloorRelease, User: "alice", Gen: granted.Gen}).(releaseResult)
+	if !released.Released || released.Next != "bob" {
+		t.Fatalf("release = %+v, want released with bob next", released)
+	}
+	assertSameState(t, r1, r2)
+}
+
+func TestLiveSessionApply(t *testing.T) {
+	at := time.Date(2025, 1, 1, 12, 0, 0, 0, time.UTC)
+	span := func(fromMs, toMs int) *utterance {
+		u := transcriptSpan(int64(fromMs), int64(toMs), at)
+		return &u
+	}
+
+	tests := []struct {
+		name    string
+		changes []liveChange
+		want    interface{} // Outcome of the last change
+	}{
+		{"first strike", []liveChange{{Op: liveOpStrike, At: at}}, false},
+		{"third strike escalates", []liveChange{
+			{Op: liveOpStrike, At: at},
+			{Op: liveOpStrike, At: at.Add(time.Minute)},
+			{Op: liveOpStrike, At: at.Add(90 * time.Second)},
+		}, true},
+		{"old strikes fall out of the window", []liveChange{
+			{Op: liveOpStrike, At: at},
+			{Op: liveOpStrike, At: at.Add(time.Minute)},
+			{Op: liveOpStrike, At: at.Add(3 * time.Minute)},
+		}, false},
+		{"typing starts", []liveChange{{Op: liveOpActivity, User: "alice", Status: hub.PresenceTyping, Active: true}}, true},
+		{"typing repeat", []liveChange{
+			{Op: liveOpActivity, User: "alice", Status: hub.PresenceTyping, Active: true},
+			{Op: liveOpActivity, User: "alice", Status: hub.PresenceTyping, Active: true},
+		}, false},
+		{"speaking is separate from typing", []liveChange{
+			{Op: liveOpActivity, User: "alice", Status: hub.PresenceTyping, Active: true},
+			{Op: liveOpActivity, User: "alice", Status: hub.PresenceSpeaking, Active: true},
+		}, true},
+		{"floor request while holding", []liveChange{
+			{Op: liveOpFloorRequest, User: "alice"},
+			{Op: liveOpFloorRequest, User: "alice"},
+		}, floorResult{Holder: "alice", Gen: 1}},
+		{"floor take without queueing", []liveChange{
+			{Op: liveOpFloorRequest, User: "alice"},
+			{Op: liveOpFloorRequest, User: "bob"},
+		}, floorResult{Holder: "alice", Gen: 1}},
+		{"releasing a floor you don't hold", []liveChange{
+			{Op: liveOpFloorRequest, User: "alice"},
+			{Op: liveOpFloorRelease, User: "bob"},
+		}, releaseResult{}},
+		{"talking over the partner", []liveChange{
+			{Op: liveOpUtterance, User: "alice", Span: span(0, 5000)},
+			{Op: liveOpUtterance, User: "bob", Span: span(2000, 4000)},
+		}, [2]string{"bob", "alice"}},
+		{"partner's earlier frame started inside ours", []liveChange{
+			{Op: liveOpUtterance, User: "alice", Span: span(3000, 6000)},
+			{Op: liveOpUtterance, User: "bob", Span: span(1000, 4000)},
+		}, [2]string{"alice", "bob"}},
+		{"taking turns", []liveChange{
+			{Op: liveOpUtterance, User: "alice", Span: span(0, 2000)},
+			{Op: liveOpUtterance, User: "bob", Span: span(2500, 4000)},
+		}, [2]string{}},
+		{"expire without a disconnect", []liveChange{
+			{Op: liveOpConnect, User: "alice", Conn: "a1"},
+			{Op: liveOpExpire, User: "alice"},
+		}, false},
+		{"disconnect of an unknown socket", []liveChange{
+			{Op: liveOpConnect, User: "alice", Conn: "a1"},
+			{Op: liveOpDisconnect, User: "alice", Conn: "zz"},
+		}, disconnectResult{}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			l := &liveSession{}
+			var got interface{}
+			for _, change := range tt.changes {
+				if change.At.IsZero() {
+					change.At = at
+				}
+				raw, _ := json.Marshal(change)
+				got = l.Apply(raw)
+			}
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("outcome = %#v, want %#v", got, tt.want)
+			}
+
+			// A replica restored from this one's snapshot is identical
+			restored := &liveSession{}
+			if err := restored.Restore(l.Snapshot()); err != nil {
+				t.Fatal(err)
+			}
+			if string(restored.Snapshot()) != string(l.Snapshot()) {
+				t.Errorf("snapshot didn't round-trip:\n%s\n%s", restored.Snapshot(), l.Snapshot())
+			}
+		})
+	}
+}
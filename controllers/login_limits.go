@@ -0,0 +1,81 @@
+package controllers
+
+import (
+	"math"
+	"strconv"
+	"strings"
+	"time"
+
+	"mend/utils"
+
+	"github.com/gofiber/fiber/v2"
+)
+
+// Login attempts are limited per IP and per account. IPs get a looser policy
+// because couples often log in from the same home network.
+var (
+	ipLoginLimiter utils.AttemptLimiter = utils.NewMemoryLimiter(utils.LimiterConfig{
+		FreeAttempts: 10,
+		BaseDelay:    time.Second,
+		MaxDelay:     5 * time.Minute,
+		LockoutAfter: 50,
+		LockoutFor:   time.Hour,
+		Window:       time.Hour,
+	})
+	accountLoginLimiter utils.AttemptLimiter = utils.NewMemoryLimiter(utils.LimiterConfig{
+		FreeAttempts: 3,
+		BaseDelay:    2 * time.Second,
+		MaxDelay:     5 * time.Minute,
+		LockoutAfter: 10,
+		LockoutFor:   15 * time.Minute,
+		Window:       time.Hour,
+	})
+)
+
+// Invite codes are short enough to enumerate, so wrong codes are limited per user
+var inviteCodeLimiter utils.AttemptLimiter = utils.NewMemoryLimiter(utils.LimiterConfig{
+	FreeAttempts: 5,
+	BaseDelay:    2 * time.Second,
+	MaxDelay:     5 * time.Minute,
+	LockoutAfter: 20,
+	LockoutFor:   time.Hour,
+	Window:       time.Hour,
+})
+
+// inviteCodeKey is the inviteCodeLimiter key for a user redeeming or declining codes
+func inviteCodeKey(userId string) string {
+	return "invite:" + userId
+}
+
+func loginKeys(c *fiber.Ctx, email string) (ipKey, accountKey string) {
+	return "ip:" + c.IP(), "email:" + strings.ToLower(strings.TrimSpace(email))
+}
+
+// loginBackoff returns how long the caller must wait before trying to log in again
+func loginBackoff(ipKey, accountKey string) time.Duration {
+	wait := ipLoginLimiter.Check(ipKey)
+	if w := accountLoginLimiter.Check(accountKey); w > wait {
+		wait = w
+	}
+	return wait
+}
+
+func recordLoginFailure(ipKey, accountKey string) {
+	ipLoginLimiter.Fail(ipKey)
+	accountLoginLimiter.Fail(accountKey)
+}
+
+// tooManyLoginAttempts answers a throttled login with 429 and Retry-After
+func tooManyLoginAttempts(c *fiber.Ctx, wait time.Duration) error {
+	return tooManyAttempts(c, wait, "Too many login attempts. Please try again later.")
+}
+
+// tooManyAttempts answers any throttled request with 429 and Retry-After
+func tooManyAttempts(c *fiber.Ctx, wait time.Duration, message string) error {
+	seconds := int(math.Ceil(wait.Seconds()))
+	c.Set(fiber.HeaderRetryAfter, strconv.Itoa(seconds))
+	return c.Status(fiber.StatusTooManyRequests).JSON(fiber.Map{
+		"error":      message,
+		"retryAfter": seconds,
+	})
+}
@@ -5,6 +5,7 @@ import (
 	"time"
 
 	"mend/database"
+	"mend/middleware"
 	"mend/models"
 	"mend/utils"
 
@@ -29,21 +30,32 @@ func SavePostResolution(c *fiber.Ctx) error {
 		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{"error": "Invalid JSON payload"})
 	}
 
+	if data.UserID == "" {
+		data.UserID = middleware.UserID(c)
+	}
+
 	// 🔍 Validate required fields
 	if data.UserID == "" || data.SessionID == "" || data.Gratitude == "" {
 		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
 			"error": "Missing required fields: userId, sessionId, or gratitude",
 		})
 	}
-
-	// 🆔 Generate unique ID
-	data.ID = utils.GeneratePartnerID()
-	data.Timestamp = time.Now().Unix()
+	if data.UserID != middleware.UserID(c) {
+		return forbidden(c)
+	}
 
 	collection := database.GetCollection("postResolution")
 	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
 	defer cancel()
 
+	if _, ok := findSessionForUser(ctx, data.SessionID, data.UserID); !ok {
+		return c.Status(fiber.StatusNotFound).JSON(fiber.Map{"error": "Session not found"})
+	}
+
+	// 🆔 Generate unique ID
+	data.ID = utils.GeneratePartnerID()
+	data.Timestamp = time.Now().Unix()
+
 	_, err := collection.InsertOne(ctx, data)
 	if err != nil {
 		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{
@@ -0,0 +1,285 @@
+package controllers
+
+import (
+	"context"
+	"log"
+	"time"
+
+	"mend/config"
+	"mend/hub"
+	"mend/middleware"
+	"mend/models"
+
+	"github.com/gofiber/fiber/v2"
+	"go.mongodb.org/mongo-driver/bson"
+)
+
+// Presence states reported by GET /api/session/:id/presence
+const (
+	presenceOnline       = "online"
+	presenceReconnecting = "reconnecting"
+	presenceOffline      = "offline"
+)
+
+// presenceState is what the session's live state knows about one partner's connection
+type presenceState struct {
+	Status   string          `json:"status"`
+	Since    time.Time       `json:"since"`
+	Typing   bool            `json:"typing,omitempty"`
+	Speaking bool            `json:"speaking,omitempty"`
+	Conns    map[string]bool `json:"conns,omitempty"` // Open sockets, on any instance
+	Gen      int             `json:"gen"`             // Bumped on every disconnect so a stale grace timer does nothing
+}
+
+// userPresence is one partner's entry in the presence snapshot
+type userPresence struct {
+	UserID   string `json:"userId"`
+	Status   string `json:"status"`
+	Typing   bool   `json:"typing"`
+	Speaking bool   `json:"speaking"`
+	Since    int64  `json:"since,omitempty"`
+}
+
+// GetSessionPresence godoc
+// @Summary      See who is connected to a session
+// @Description  Returns each partner's connection status (online, reconnecting or offline) and whether they are typing or speaking
+// @Tags         Session
+// @Produce      json
+// @Param        sessionId path string true "Session ID"
+// @Success      200 {array} map[string]interface{}
+// @Failure      404 {object} map[string]string
+// @Router       /api/session/{sessionId}/presence [get]
+func GetSessionPresence(c *fiber.Ctx) error {
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+
+	session, ok := findSessionForUser(ctx, c.Params("sessionId"), middleware.UserID(c))
+	if !ok {
+		return c.Status(404).JSON(fiber.Map{"error": "Session not found"})
+	}
+
+	snapshot := make([]userPresence, 0, 2)
+	getLiveSession(session.ID).read(func(l *liveSession) {
+		for _, userId := range []string{session.PartnerA, session.PartnerB} {
+			entry := userPresence{UserID: userId, Status: presenceOffline}
+			if st, ok := l.Presence[userId]; ok {
+				entry.Status = st.Status
+				entry.Typing = st.Typing
+				entry.Speaking = st.Speaking
+				entry.Since = st.Since.Unix()
+			}
+			snapshot = append(snapshot, entry)
+		}
+	})
+	return c.JSON(snapshot)
+}
+
+// presenceGrace is how long a dropped partner has to reconnect before they count as gone
+func presenceGrace() time.Duration {
+	return time.Duration(config.GetInt("PRESENCE_GRACE_SECONDS", 30)) * time.Second
+}
+
+// presenceFor returns the user's presence entry
+func (l *liveSession) presenceFor(userId string) *presenceState {
+	if l.Presence == nil {
+		l.Presence = make(map[string]*presenceState)
+	}
+	st, ok := l.Presence[userId]
+	if !ok {
+		st = &presenceState{Status: presenceOffline}
+		l.Presence[userId] = st
+	}
+	if st.Conns == nil {
+		st.Conns = make(map[string]bool)
+	}
+	return st
+}
+
+// connectResult is the outcome of a liveOpConnect
+type connectResult struct {
+	Changed bool                  // The user just came online
+	Others  []hub.PresencePayload // Everyone else who is connected or reconnecting
+}
+
+func (l *liveSession) applyConnect(change liveChange) connectResult {
+	st := l.presenceFor(change.User)
+	st.Conns[change.Conn] = true
+	res := connectResult{Changed: st.Status != presenceOnline}
+	if res.Changed {
+		st.Status = presenceOnline
+		st.Since = change.At
+	}
+	for id, other := range l.Presence {
+		if id == change.User || other.Status == presenceOffline {
+			continue
+		}
+		status := hub.PresenceJoined
+		if other.Status == presenceReconnecting {
+			status = hub.PresenceReconnecting
+		}
+		res.Others = append(res.Others, hub.PresencePayload{UserID: id, Status: status, Since: other.Since.Unix()})
+	}
+	return res
+}
+
+// disconnectResult is the outcome of a liveOpDisconnect
+type disconnectResult struct {
+	Reconnecting bool // The user's last socket, on any instance, is gone
+	Gen          int  // Generation the grace timer belongs to
+}
+
+func (l *liveSession) applyDisconnect(change liveChange) disconnectResult {
+	st := l.presenceFor(change.User)
+	delete(st.Conns, change.Conn)
+	if len(st.Conns) > 0 || st.Status != presenceOnline {
+		return disconnectResult{}
+	}
+	st.Status = presenceReconnecting
+	st.Since = change.At
+	st.Typing = false
+	st.Speaking = false
+	st.Gen++
+	return disconnectResult{Reconnecting: true, Gen: st.Gen}
+}
+
+// applyExpire reports whether the user has now left: still reconnecting, with no socket on
+// any instance, since the disconnect the grace timer was started for
+func (l *liveSession) applyExpire(change liveChange) bool {
+	st := l.presenceFor(change.User)
+	if st.Gen != change.Gen || st.Status != presenceReconnecting || len(st.Conns) > 0 {
+		return false
+	}
+	st.Status = presenceOffline
+	st.Since = change.At
+	return true
+}
+
+// applyActivity reports whether the user's typing or speaking state changed
+func (l *liveSession) applyActivity(change liveChange) bool {
+	st := l.presenceFor(change.User)
+	current := &st.Typing
+	if change.Status == hub.PresenceSpeaking {
+		current = &st.Speaking
+	}
+	changed := *current != change.Active
+	*current = change.Active
+	return changed
+}
+
+// presenceConnected marks a newly registered socket's user as online, tells the partner and
+// tells the new socket who else is already there. Call the returned leave once the socket
+// has closed; until then this instance keeps the session's live state.
+func presenceConnected(client *hub.Client) (leave func()) {
+	sessionId, userId := client.SessionID, client.UserID
+	live := getLiveSession(sessionId)
+	release := live.hold()
+	now := time.Now()
+
+	res, _ := live.update(liveChange{Op: liveOpConnect, At: now, User: userId, Conn: client.ID()}).(connectResult)
+	if res.Changed {
+		broadcastPresence(sessionId, hub.PresencePayload{UserID: userId, Status: hub.PresenceJoined, Since: now.Unix()}, client)
+		go rejoinIfWaiting(sessionId, userId)
+	}
+	for _, p := range res.Others {
+		if env, err := hub.NewEnvelope(hub.KindPresence, sessionId, "", p); err == nil {
+			client.Send(env)
+		}
+	}
+
+	return func() {
+		defer release()
+		presenceDisconnected(client)
+	}
+}
+
+// presenceDisconnected runs after a socket has left the room. Once the user's last socket,
+// on any instance, is gone they are reconnecting; if they don't come back within the grace
+// period they have left.
+func presenceDisconnected(client *hub.Client) {
+	sessionId, userId := client.SessionID, client.UserID
+	live := getLiveSession(sessionId)
+	now := time.Now()
+
+	res, _ := live.update(liveChange{Op: liveOpDisconnect, At: now, User: userId, Conn: client.ID()}).(disconnectResult)
+	if !res.Reconnecting {
+		return
+	}
+
+	// ⏳ The grace timer keeps the live state here until it fires, even with nobody connected
+	release := live.hold()
+	time.AfterFunc(presenceGrace(), func() {
+		defer release()
+		presenceExpired(sessionId, userId, res.Gen)
+	})
+	broadcastPresence(sessionId, hub.PresencePayload{UserID: userId, Status: hub.PresenceReconnecting, Since: now.Unix()}, nil)
+}
+
+// presenceExpired fires when the grace period runs out. Unless the user reconnected in the
+// meantime, to any instance, they have left.
+func presenceExpired(sessionId, userId string, gen int) {
+	now := time.Now()
+	left, _ := getLiveSession(sessionId).update(liveChange{Op: liveOpExpire, At: now, User: userId, Gen: gen}).(bool)
+	if !left {
+		return
+	}
+
+	broadcastPresence(sessionId, hub.PresencePayload{UserID: userId, Status: hub.PresenceLeft, Since: now.Unix()}, nil)
+	markPartnerAway(sessionId, userId)
+}
+
+// setActivity records a partner starting or stopping typing (chat) or speaking (voice)
+// and tells the others in the session. Repeats of the current state are not re-sent.
+func setActivity(client *hub.Client, status string, active bool) {
+	sessionId, userId := client.SessionID, client.UserID
+	changed, _ := getLiveSession(sessionId).update(liveChange{Op: liveOpActivity, User: userId, Status: status, Active: active}).(bool)
+	if changed {
+		broadcastPresence(sessionId, hub.PresencePayload{UserID: userId, Status: status, Active: &active, Since: time.Now().Unix()}, client)
+	}
+}
+
+// broadcastPresence sends a presence event to everyone in the session except `except` (may be nil)
+func broadcastPresence(sessionId string, payload hub.PresencePayload, except *hub.Client) {
+	env, err := hub.NewEnvelope(hub.KindPresence, sessionId, "", payload)
+	if err != nil {
+		log.Println("❌ Failed to build presence event:", err)
+		return
+	}
+	sessionHub.Broadcast(sessionId, env, except)
+}
+
+// markPartnerAway moves an active session back to waiting_for_partner when a partner is gone
+func markPartnerAway(sessionId, userId string) {
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+
+	session, ok := findSessionForUser(ctx, sessionId, userId)
+	if !ok || session.Status != models.SessionStatusActive {
+		return
+	}
+	if _, err := transitionSession(ctx, session, models.SessionStatusWaitingForPartner, "", bson.M{
+		"$pull": bson.M{"joined": userId},
+	}); err != nil {
+		log.Printf("❌ Failed to move session %s to waiting_for_partner: %v\n", sessionId, err)
+	}
+}
+
+// rejoinIfWaiting restarts a session that was waiting for this partner as soon as they connect
+func rejoinIfWaiting(sessionId, userId string) {
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+
+	session, ok := findSessionForUser(ctx, sessionId, userId)
+	if !ok || session.Status != models.SessionStatusWaitingForPartner || len(session.Joined) == 0 {
+		return
+	}
+	for _, id := range session.Joined {
+		if id == userId {
+			return // Still waiting for the other partner
+		}
+	}
+	if _, err := transitionSession(ctx, session, models.SessionStatusActive, userId, bson.M{
+		"$addToSet": bson.M{"joined": userId},
+	}); err != nil {
+		log.Printf("❌ Failed to restart session %s: %v\n", sessionId, err)
+	}
+}
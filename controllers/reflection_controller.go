@@ -1,17 +1,15 @@
 package controllers
 
 import (
-	"bytes"
 	"context"
-	"encoding/json"
 	"fmt"
-	"io"
-	"net/http"
-	"os"
 	"time"
 
 	"mend/database"
+	"mend/llm"
+	"mend/middleware"
 	"mend/models"
+	"mend/prompts"
 
 	"github.com/gofiber/fiber/v2"
 	"go.mongodb.org/mongo-driver/bson"
@@ -23,10 +21,24 @@ func SaveReflection(c *fiber.Ctx) error {
 	if err := c.BodyParser(&reflection); err != nil {
 		return c.Status(400).JSON(fiber.Map{"error": "Invalid reflection data"})
 	}
+	reflection.PromptVersion = "" // Only set on reflections the AI wrote
 
+	if reflection.UserID == "" {
+		reflection.UserID = middleware.UserID(c)
+	}
 	if reflection.UserID == "" || reflection.SessionID == "" {
 		return c.Status(400).JSON(fiber.Map{"error": "Missing userId or sessionId"})
 	}
+	if reflection.UserID != middleware.UserID(c) {
+		return forbidden(c)
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+
+	if _, ok := findSessionForUser(ctx, reflection.SessionID, reflection.UserID); !ok {
+		return c.Status(404).JSON(fiber.Map{"error": "Session not found"})
+	}
 
 	// 🧠 If no reflection text, generate with AI
 	if reflection.Text == "" {
@@ -35,12 +47,13 @@ func SaveReflection(c *fiber.Ctx) error {
 			return c.Status(500).JSON(fiber.Map{"error": "Failed to fetch session messages"})
 		}
 
-		aiText, err := generateAIReflection(transcript)
+		aiText, promptVersion, err := generateAIReflection(transcript)
 		if err != nil {
 			return c.Status(500).JSON(fiber.Map{"error": "AI generation failed", "details": err.Error()})
 		}
 
 		reflection.Text = aiText
+		reflection.PromptVersion = promptVersion
 	}
 
 	// Generate ID and save
@@ -48,9 +61,6 @@ func SaveReflection(c *fiber.Ctx) error {
 	reflection.Timestamp = time.Now().Unix()
 
 	collection := database.GetCollection("reflections")
-	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
-	defer cancel()
-
 	_, err := collection.InsertOne(ctx, reflection)
 	if err != nil {
 		return c.Status(500).JSON(fiber.Map{"error": "Failed to save reflection"})
@@ -74,8 +84,9 @@ func fetchSessionTranscript(sessionId string) ([]models.Message, error) {
 	return session.Messages, nil
 }
 
-// generateAIReflection calls OpenAI to summarize session
-func generateAIReflection(messages []models.Message) (string, error) {
+// generateAIReflection calls OpenAI to summarize session, returning the reflection and the
+// prompt version it came from
+func generateAIReflection(messages []models.Message) (string, string, error) {
 	// Format messages for prompt
 	var transcript string
 	for _, m := range messages {
@@ -86,64 +97,17 @@ func generateAIReflection(messages []models.Message) (string, error) {
 		transcript += fmt.Sprintf("%s: %s\n", speaker, m.Text)
 	}
 
-	prompt := fmt.Sprintf(`
-You are a relationship therapist AI. Given the following chat transcript between two partners, write a gentle, insightful reflection summarizing what was discussed, areas of emotional concern, and any progress made.
-
-Transcript:
-%s
-
-Please return a 3-5 sentence therapist-style reflection.
-`, transcript)
-
-	payload := map[string]interface{}{
-		"messages": []map[string]string{
-			{"role": "system", "content": "You are a compassionate therapist AI that helps couples reflect on their communication."},
-			{"role": "user", "content": prompt},
-		},
-		"temperature": 0.7,
-	}
-
-	jsonData, err := json.Marshal(payload)
+	prompt := prompts.Get(prompts.Reflection)
+	req, err := prompt.Render(prompts.Vars{"transcript": transcript})
 	if err != nil {
-		return "", err
+		return "", "", err
 	}
 
-	apiKey := os.Getenv("OPENAI_API_KEY")
-	endpoint := os.Getenv("OPENAI_ENDPOINT")
-	deployment := os.Getenv("OPENAI_DEPLOYMENT")
-
-	url := fmt.Sprintf("%s/openai/deployments/%s/chat/completions?api-version=2024-02-15-preview", endpoint, deployment)
-
-	req, err := http.NewRequest("POST", url, bytes.NewBuffer(jsonData))
-	if err != nil {
-		return "", err
-	}
-	req.Header.Set("Content-Type", "application/json")
-	req.Header.Set("api-key", apiKey)
-
-	client := &http.Client{Timeout: 15 * time.Second}
-	resp, err := client.Do(req)
-	if err != nil {
-		return "", err
-	}
-	defer resp.Body.Close()
-
-	bodyBytes, err := io.ReadAll(resp.Body)
-	if err != nil {
-		return "", err
-	}
-
-	var result map[string]interface{}
-	if err := json.Unmarshal(bodyBytes, &result); err != nil {
-		return "", err
-	}
-
-	// Extract AI reply
-	choices := result["choices"].([]interface{})
-	message := choices[0].(map[string]interface{})["message"].(map[string]interface{})
-	content := message["content"].(string)
+	ctx, cancel := context.WithTimeout(context.Background(), 15*time.Second)
+	defer cancel()
 
-	return content, nil
+	reply, err := llm.Default().Chat(ctx, req)
+	return reply, prompt.ID(), err
 }
 
 // GetInsights godoc
@@ -152,8 +116,10 @@ Please return a 3-5 sentence therapist-style reflection.
 // @Tags         Insights
 // @Produce      json
 // @Param        userId path string true "User ID"
+// @Param        relationshipId query string false "Relationship period (defaults to the current one)"
 // @Success      200 {object} map[string]interface{}
 // @Failure      400 {object} map[string]string
+// @Failure      403 {object} map[string]string
 // @Failure      500 {object} map[string]string
 // @Router       /api/insights/{userId} [get]
 func GetInsights(c *fiber.Ctx) error {
@@ -161,6 +127,9 @@ func GetInsights(c *fiber.Ctx) error {
 	if userId == "" {
 		return c.Status(400).JSON(fiber.Map{"error": "Missing userId"})
 	}
+	if userId != middleware.UserID(c) {
+		return forbidden(c)
+	}
 
 	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
 	defer cancel()
@@ -169,8 +138,25 @@ func GetInsights(c *fiber.Ctx) error {
 	reflectionCollection := database.GetCollection("reflections")
 	postResCollection := database.GetCollection("postResolution")
 
-	// 🧾 Get all sessions where the user is involved
+	// 💞 Insights cover one relationship period: the current one unless ?relationshipId= picks another
+	relationshipId := c.Query("relationshipId")
+	if relationshipId != "" {
+		if !transcriptsVisibleTo(ctx, relationshipId, userId) {
+			return forbidden(c)
+		}
+	} else if current, ok := currentRelationship(ctx, userId); ok {
+		relationshipId = current.ID
+	} else {
+		return c.Status(200).JSON(fiber.Map{
+			"sessions":     []models.Session{},
+			"reflections":  []models.Reflection{},
+			"postFeedback": []models.PostResolution{},
+		})
+	}
+
+	// 🧾 Get the sessions where the user is involved
 	sessionCursor, err := sessionsCollection.Find(ctx, bson.M{
+		"relationshipId": relationshipId,
 		"$or": []bson.M{
 			{"partnerA": userId},
 			{"partnerB": userId},
@@ -184,9 +170,13 @@ func GetInsights(c *fiber.Ctx) error {
 	if err := sessionCursor.All(ctx, &sessions); err != nil {
 		return c.Status(500).JSON(fiber.Map{"error": "Failed to decode sessions"})
 	}
+	sessionIds := make([]string, 0, len(sessions))
+	for _, session := range sessions {
+		sessionIds = append(sessionIds, session.ID)
+	}
 
 	// 💬 Get reflections written by the user
-	reflectionCursor, err := reflectionCollection.Find(ctx, bson.M{"userId": userId})
+	reflectionCursor, err := reflectionCollection.Find(ctx, bson.M{"userId": userId, "sessionId": bson.M{"$in": sessionIds}})
 	if err != nil {
 		return c.Status(500).JSON(fiber.Map{"error": "Error fetching reflections"})
 	}
@@ -197,7 +187,7 @@ func GetInsights(c *fiber.Ctx) error {
 	}
 
 	// ❤️ Get post-resolution feedback (emotional bonding data)
-	postResCursor, err := postResCollection.Find(ctx, bson.M{"userId": userId})
+	postResCursor, err := postResCollection.Find(ctx, bson.M{"userId": userId, "sessionId": bson.M{"$in": sessionIds}})
 	if err != nil {
 		return c.Status(500).JSON(fiber.Map{"error": "Error fetching post-resolution entries"})
 	}
@@ -209,8 +199,9 @@ func GetInsights(c *fiber.Ctx) error {
 
 	// 🔍 Assemble insights (more features like AI scoring summary or timeline trend can be added here)
 	return c.Status(200).JSON(fiber.Map{
-		"sessions":     sessions,
-		"reflections":  reflections,
-		"postFeedback": postRes,
+		"relationshipId": relationshipId,
+		"sessions":       sessions,
+		"reflections":    reflections,
+		"postFeedback":   postRes,
 	})
 }
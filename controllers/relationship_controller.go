@@ -0,0 +1,298 @@
+package controllers
+
+import (
+	"context"
+	"errors"
+	"time"
+
+	"mend/database"
+	"mend/middleware"
+	"mend/models"
+	"mend/utils"
+
+	"github.com/gofiber/fiber/v2"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+// GetRelationship godoc
+// @Summary      Get your current relationship
+// @Description  Returns the couple the caller belongs to, with its anniversary and shared settings
+// @Tags         Relationship
+// @Produce      json
+// @Success      200 {object} models.Relationship
+// @Failure      404 {object} map[string]string
+// @Router       /api/relationship [get]
+func GetRelationship(c *fiber.Ctx) error {
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+
+	relationship, ok := currentRelationship(ctx, middleware.UserID(c))
+	if !ok {
+		return c.Status(404).JSON(fiber.Map{"error": "You are not linked with a partner"})
+	}
+	return c.JSON(relationship)
+}
+
+// UpdateRelationship godoc
+// @Summary      Update your relationship
+// @Description  Sets the anniversary and/or shared settings of the caller's current relationship.
+// @Description  Omitted fields are left unchanged.
+// @Tags         Relationship
+// @Accept       json
+// @Produce      json
+// @Param        body body map[string]interface{} true "anniversary (YYYY-MM-DD), settings"
+// @Success      200 {object} models.Relationship
+// @Failure      400,404,500 {object} map[string]string
+// @Router       /api/relationship [patch]
+func UpdateRelationship(c *fiber.Ctx) error {
+	var body struct {
+		Anniversary *string `json:"anniversary"`
+		Settings    *struct {
+			AIInterjections    *bool `json:"aiInterjections"`
+			CoolingOffSeconds  *int  `json:"coolingOffSeconds"`
+			MaxSpeakingSeconds *int  `json:"maxSpeakingSeconds"`
+		} `json:"settings"`
+	}
+	if err := c.BodyParser(&body); err != nil {
+		return c.Status(400).JSON(fiber.Map{"error": "Invalid payload"})
+	}
+
+	update := bson.M{}
+	if body.Anniversary != nil {
+		if *body.Anniversary != "" {
+			if _, err := time.Parse("2006-01-02", *body.Anniversary); err != nil {
+				return c.Status(400).JSON(fiber.Map{"error": "anniversary must be YYYY-MM-DD"})
+			}
+		}
+		update["anniversary"] = *body.Anniversary
+	}
+	if body.Settings != nil {
+		if body.Settings.AIInterjections != nil {
+			update["settings.aiInterjections"] = *body.Settings.AIInterjections
+		}
+		if body.Settings.CoolingOffSeconds != nil {
+			if *body.Settings.CoolingOffSeconds < 0 {
+				return c.Status(400).JSON(fiber.Map{"error": "coolingOffSeconds can't be negative"})
+			}
+			update["settings.coolingOffSeconds"] = *body.Settings.CoolingOffSeconds
+		}
+		if body.Settings.MaxSpeakingSeconds != nil {
+			if *body.Settings.MaxSpeakingSeconds < 0 {
+				return c.Status(400).JSON(fiber.Map{"error": "maxSpeakingSeconds can't be negative"})
+			}
+			update["settings.maxSpeakingSeconds"] = *body.Settings.MaxSpeakingSeconds
+		}
+	}
+	if len(update) == 0 {
+		return c.Status(400).JSON(fiber.Map{"error": "Nothing to update"})
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+
+	var relationship models.Relationship
+	err := database.GetCollection("relationships").FindOneAndUpdate(ctx,
+		bson.M{"members": middleware.UserID(c), "status": models.RelationshipStatusActive},
+		bson.M{"$set": update},
+		options.FindOneAndUpdate().SetReturnDocument(options.After),
+	).Decode(&relationship)
+	if errors.Is(err, mongo.ErrNoDocuments) {
+		return c.Status(404).JSON(fiber.Map{"error": "You are not linked with a partner"})
+	}
+	if err != nil {
+		return c.Status(500).JSON(fiber.Map{"error": "Failed to update relationship"})
+	}
+	return c.JSON(relationship)
+}
+
+// UnlinkPartner godoc
+// @Summary      Unlink from your partner
+// @Description  Ends the current relationship: abandons any open session, records who ended it and when,
+// @Description  and stores whether the caller's past transcripts stay visible to the former partner.
+// @Tags         Relationship
+// @Accept       json
+// @Produce      json
+// @Param        body body map[string]bool true "keepTranscriptsVisible"
+// @Success      200 {object} models.Relationship
+// @Failure      400,409,500 {object} map[string]string
+// @Router       /api/partner/unlink [post]
+func UnlinkPartner(c *fiber.Ctx) error {
+	var body struct {
+		KeepTranscriptsVisible *bool `json:"keepTranscriptsVisible"`
+	}
+	if err := c.BodyParser(&body); err != nil || body.KeepTranscriptsVisible == nil {
+		return c.Status(400).JSON(fiber.Map{"error": "Please choose whether your past transcripts stay visible (keepTranscriptsVisible)"})
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+
+	userId := middleware.UserID(c)
+	relationship, ok := currentRelationship(ctx, userId)
+	if !ok {
+		return c.Status(400).JSON(fiber.Map{"error": "You are not linked with a partner"})
+	}
+
+	// 🛑 End the relationship and close anything still open between the two of them, all or nothing
+	now := time.Now().Unix()
+	err := database.WithTransaction(ctx, func(sc mongo.SessionContext) error {
+		res, err := database.GetCollection("relationships").UpdateOne(sc,
+			bson.M{"_id": relationship.ID, "status": models.RelationshipStatusActive},
+			bson.M{"$set": bson.M{
+				"status":                         models.RelationshipStatusEnded,
+				"endedAt":                        now,
+				"endedBy":                        userId,
+				"transcriptVisibility." + userId: *body.KeepTranscriptsVisible,
+			}},
+		)
+		if err != nil {
+			return err
+		}
+		if res.ModifiedCount == 0 {
+			return errRelationshipEnded
+		}
+
+		open, err := findOpenSession(sc, relationship.ID)
+		if errors.Is(err, mongo.ErrNoDocuments) {
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+		_, err = transitionSession(sc, open, models.SessionStatusAbandoned, userId, nil)
+		return err
+	})
+	if errors.Is(err, errRelationshipEnded) {
+		return c.Status(409).JSON(fiber.Map{"error": "This relationship has already ended"})
+	}
+	if err != nil {
+		return c.Status(500).JSON(fiber.Map{"error": "Failed to unlink partner"})
+	}
+
+	relationship.Status = models.RelationshipStatusEnded
+	relationship.EndedAt = now
+	relationship.EndedBy = userId
+	if relationship.TranscriptVisibility == nil {
+		relationship.TranscriptVisibility = map[string]bool{}
+	}
+	relationship.TranscriptVisibility[userId] = *body.KeepTranscriptsVisible
+	return c.JSON(relationship)
+}
+
+// SetTranscriptVisibility godoc
+// @Summary      Choose whether a former partner can still see your transcripts
+// @Description  Either member of a relationship can change their own choice at any time
+// @Tags         Relationship
+// @Accept       json
+// @Produce      json
+// @Param        id path string true "Relationship ID"
+// @Param        body body map[string]bool true "visible"
+// @Success      200 {object} map[string]string
+// @Failure      400,404,500 {object} map[string]string
+// @Router       /api/relationship/{id}/visibility [patch]
+func SetTranscriptVisibility(c *fiber.Ctx) error {
+	var body struct {
+		Visible *bool `json:"visible"`
+	}
+	if err := c.BodyParser(&body); err != nil || body.Visible == nil {
+		return c.Status(400).JSON(fiber.Map{"error": "Missing visible"})
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+
+	userId := middleware.UserID(c)
+	res, err := database.GetCollection("relationships").UpdateOne(ctx,
+		bson.M{"_id": c.Params("id"), "members": userId},
+		bson.M{"$set": bson.M{"transcriptVisibility." + userId: *body.Visible}},
+	)
+	if err != nil {
+		return c.Status(500).JSON(fiber.Map{"error": "Failed to update visibility"})
+	}
+	if res.MatchedCount == 0 {
+		return c.Status(404).JSON(fiber.Map{"error": "Relationship not found"})
+	}
+	return c.JSON(fiber.Map{"message": "Transcript visibility updated"})
+}
+
+// GetRelationships godoc
+// @Summary      List your relationship history
+// @Description  Returns every relationship period the caller has been part of, newest first
+// @Tags         Relationship
+// @Produce      json
+// @Success      200 {array} models.Relationship
+// @Failure      500 {object} map[string]string
+// @Router       /api/relationships [get]
+func GetRelationships(c *fiber.Ctx) error {
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+
+	cursor, err := database.GetCollection("relationships").Find(ctx,
+		bson.M{"members": middleware.UserID(c)},
+		options.Find().SetSort(bson.M{"startedAt": -1}),
+	)
+	if err != nil {
+		return c.Status(500).JSON(fiber.Map{"error": "Error fetching relationships"})
+	}
+
+	relationships := []models.Relationship{}
+	if err := cursor.All(ctx, &relationships); err != nil {
+		return c.Status(500).JSON(fiber.Map{"error": "Failed to decode relationships"})
+	}
+	return c.JSON(relationships)
+}
+
+var errRelationshipEnded = errors.New("relationship already ended")
+
+// createRelationship records the start of a new relationship period. The unique index on
+// active members makes the insert fail if either user is already in a relationship.
+func createRelationship(ctx context.Context, inviterId, inviteeId string) (models.Relationship, error) {
+	relationship := models.Relationship{
+		ID:                   utils.GeneratePartnerID(),
+		Members:              []string{inviterId, inviteeId},
+		InitiatedBy:          inviterId,
+		Status:               models.RelationshipStatusActive,
+		StartedAt:            time.Now().Unix(),
+		Settings:             models.RelationshipSettings{AIInterjections: true},
+		TranscriptVisibility: map[string]bool{inviterId: true, inviteeId: true},
+	}
+	_, err := database.GetCollection("relationships").InsertOne(ctx, relationship)
+	return relationship, err
+}
+
+// currentRelationship returns the user's live relationship, if they are linked
+func currentRelationship(ctx context.Context, userId string) (models.Relationship, bool) {
+	var relationship models.Relationship
+	err := database.GetCollection("relationships").FindOne(ctx, bson.M{
+		"members": userId,
+		"status":  models.RelationshipStatusActive,
+	}).Decode(&relationship)
+	return relationship, err == nil
+}
+
+// withPartnerID fills the user's derived PartnerID from their current relationship
+func withPartnerID(ctx context.Context, user *models.User) {
+	if relationship, ok := currentRelationship(ctx, user.ID); ok {
+		user.PartnerID = relationship.OtherMember(user.ID)
+	}
+}
+
+// transcriptsVisibleTo reports whether userId may still read sessions of a relationship.
+// Once it has ended, that is up to the former partner's visibility choice.
+func transcriptsVisibleTo(ctx context.Context, relationshipId, userId string) bool {
+	if relationshipId == "" {
+		return true
+	}
+	var relationship models.Relationship
+	err := database.GetCollection("relationships").FindOne(ctx, bson.M{"_id": relationshipId}).Decode(&relationship)
+	if err != nil {
+		return false
+	}
+	if relationship.Status == models.RelationshipStatusActive {
+		return true
+	}
+	visible, ok := relationship.TranscriptVisibility[relationship.OtherMember(userId)]
+	return !ok || visible
+}
@@ -0,0 +1,67 @@
+package controllers
+
+import (
+	"os"
+	"strings"
+	"time"
+
+	"mend/config"
+	"mend/middleware"
+	"mend/utils"
+
+	"github.com/gofiber/fiber/v2"
+)
+
+const defaultSTUNURLs = "stun:stun.l.google.com:19302"
+
+// iceServer mirrors RTCIceServer, so clients can pass the list straight to RTCPeerConnection
+type iceServer struct {
+	URLs       []string `json:"urls"`
+	Username   string   `json:"username,omitempty"`
+	Credential string   `json:"credential,omitempty"`
+}
+
+// GetRTCConfig godoc
+// @Summary      Get the ICE servers for a voice call
+// @Description  Returns the STUN/TURN servers to use for the peer-to-peer call between partners.
+// @Description  TURN entries carry credentials that are only valid until expiresAt.
+// @Tags         Voice
+// @Produce      json
+// @Success      200 {object} map[string]interface{}
+// @Router       /api/rtc/config [get]
+func GetRTCConfig(c *fiber.Ctx) error {
+	servers := []iceServer{}
+	if stun := splitURLs(os.Getenv("STUN_URLS"), defaultSTUNURLs); len(stun) > 0 {
+		servers = append(servers, iceServer{URLs: stun})
+	}
+
+	var expiresAt int64
+	turn := splitURLs(os.Getenv("TURN_URLS"), "")
+	if secret := os.Getenv("TURN_SECRET"); len(turn) > 0 && secret != "" {
+		// 🔑 Short-lived credentials, so a leaked config stops working on its own
+		ttl := time.Duration(config.GetInt("TURN_TTL_SECONDS", 3600)) * time.Second
+		var username, credential string
+		username, credential, expiresAt = utils.TURNCredentials(secret, middleware.UserID(c), ttl)
+		servers = append(servers, iceServer{URLs: turn, Username: username, Credential: credential})
+	}
+
+	resp := fiber.Map{"iceServers": servers}
+	if expiresAt != 0 {
+		resp["expiresAt"] = expiresAt
+	}
+	return c.JSON(resp)
+}
+
+// splitURLs parses a comma-separated list of ICE URLs
+func splitURLs(value, fallback string) []string {
+	if value == "" {
+		value = fallback
+	}
+	var urls []string
+	for _, u := range strings.Split(value, ",") {
+		if u = strings.TrimSpace(u); u != "" {
+			urls = append(urls, u)
+		}
+	}
+	return urls
+}
@@ -1,17 +1,16 @@
 package controllers
 
 import (
-	"bytes"
 	"context"
-	"encoding/json"
+	"errors"
 	"fmt"
-	"io"
-	"net/http"
-	"os"
 	"time"
 
 	"mend/database"
+	"mend/llm"
+	"mend/middleware"
 	"mend/models"
+	"mend/prompts"
 
 	"github.com/gofiber/fiber/v2"
 	"go.mongodb.org/mongo-driver/bson"
@@ -27,7 +26,20 @@ func SubmitScore(c *fiber.Ctx) error {
 		return c.Status(400).JSON(fiber.Map{"error": "Missing sessionId or partnerId"})
 	}
 
+	// 🔒 Only participants can score a session, and only its participants can be scored
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+
+	session, ok := findSessionForUser(ctx, score.SessionID, middleware.UserID(c))
+	if !ok {
+		return c.Status(404).JSON(fiber.Map{"error": "Session not found"})
+	}
+	if !isParticipant(session, score.PartnerID) {
+		return c.Status(400).JSON(fiber.Map{"error": "partnerId is not part of this session"})
+	}
+
 	score.CreatedAt = time.Now().Unix()
+	score.PromptVersion = "" // Only set on scores the AI wrote
 
 	// 🧠 Auto-generate score via AI if fields are zero
 	if score.Empathy == 0 && score.Respect == 0 && score.Listening == 0 && score.Clarity == 0 && score.ConflictResolution == 0 {
@@ -35,10 +47,17 @@ func SubmitScore(c *fiber.Ctx) error {
 		if err != nil {
 			return c.Status(500).JSON(fiber.Map{"error": "Failed to fetch session messages"})
 		}
-		aiScore, err := generateAIScore(messages)
-		if err != nil {
+		aiScore, err := generateAIScore(messages, session.Interruptions)
+		var outputErr *llm.StructuredOutputError
+		switch {
+		case errors.Is(err, llm.ErrNotConfigured):
+			return c.Status(503).JSON(fiber.Map{"error": "AI scoring is not available"})
+		case errors.As(err, &outputErr):
+			return c.Status(502).JSON(fiber.Map{"error": "AI scoring failed", "details": outputErr.Error()})
+		case err != nil:
 			return c.Status(500).JSON(fiber.Map{"error": "AI scoring failed", "details": err.Error()})
 		}
+		applyInterruptions(&aiScore, session.Interruptions[score.PartnerID])
 		aiScore.SessionID = score.SessionID
 		aiScore.PartnerID = score.PartnerID
 		aiScore.CreatedAt = time.Now().Unix()
@@ -47,16 +66,6 @@ func SubmitScore(c *fiber.Ctx) error {
 
 	// 💾 Save score to session document
 	sessions := database.GetCollection("sessions")
-	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
-	defer cancel()
-
-	var session struct {
-		PartnerA string `bson:"partnerA"`
-		PartnerB string `bson:"partnerB"`
-	}
-	if err := sessions.FindOne(ctx, bson.M{"_id": score.SessionID}).Decode(&session); err != nil {
-		return c.Status(500).JSON(fiber.Map{"error": "Session not found"})
-	}
 
 	updateField := "scoreA"
 	if score.PartnerID == session.PartnerB {
@@ -95,8 +104,46 @@ func fetchSessionMessages(sessionID string) ([]models.Message, error) {
 	return session.Messages, nil
 }
 
-// generateAIScore evaluates communication quality using OpenAI
-func generateAIScore(messages []models.Message) (models.CommunicationScore, error) {
+// fetchSessionInterruptions retrieves the measured interruption counts of a session
+func fetchSessionInterruptions(sessionID string) map[string]int {
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+
+	var session struct {
+		Interruptions map[string]int `bson:"interruptions"`
+	}
+	_ = database.GetCollection("sessions").FindOne(ctx, bson.M{"_id": sessionID}).Decode(&session)
+	return session.Interruptions
+}
+
+// applyInterruptions records a partner's measured interruptions on their score and caps
+// Listening by them: every interruption costs a point, down to 1.
+func applyInterruptions(score *models.CommunicationScore, count int) {
+	score.Interruptions = count
+	if limit := max(5-count, 1); score.Listening > limit {
+		score.Listening = limit
+	}
+}
+
+// Times a malformed AI score is sent back to the model to be fixed before giving up
+const scoreRepairAttempts = 2
+
+// scoreSchema is the shape of an AI score: every area on the 1–5 rubric, plus a summary
+var scoreSchema = llm.Schema{
+	Name: "communication_score",
+	Fields: []llm.Field{
+		{Name: "empathy", Type: llm.TypeInteger, Required: true, Min: 1, Max: 5},
+		{Name: "listening", Type: llm.TypeInteger, Required: true, Min: 1, Max: 5},
+		{Name: "respect", Type: llm.TypeInteger, Required: true, Min: 1, Max: 5},
+		{Name: "clarity", Type: llm.TypeInteger, Required: true, Min: 1, Max: 5},
+		{Name: "conflictResolution", Type: llm.TypeInteger, Required: true, Min: 1, Max: 5},
+		{Name: "summary", Type: llm.TypeString, Required: true, Description: "The emotional tone in 1-2 lines"},
+	},
+}
+
+// generateAIScore evaluates communication quality with the AI model. A reply that can't be
+// used comes back as an *llm.StructuredOutputError rather than a made-up score.
+func generateAIScore(messages []models.Message, interruptions map[string]int) (models.CommunicationScore, error) {
 	var transcript string
 	for _, msg := range messages {
 		speaker := msg.SpeakerId
@@ -106,85 +153,34 @@ func generateAIScore(messages []models.Message) (models.CommunicationScore, erro
 		transcript += fmt.Sprintf("%s: %s\n", speaker, msg.Text)
 	}
 
-	prompt := fmt.Sprintf(`
-You are a therapist AI evaluating a conversation between two people. Based on the transcript below, rate their communication on a scale of 1 to 5 in these areas:
-
-- Empathy
-- Listening
-- Respect
-- Clarity
-- Conflict Resolution
-
-Then summarize the emotional tone in 1-2 lines.
-
-Respond in this exact JSON format:
-
-{
-  "empathy": 4,
-  "listening": 5,
-  "respect": 4,
-  "clarity": 5,
-  "conflictResolution": 4,
-  "summary": "The tone was respectful and both parties were attentive to each other."
-}
-
-Transcript:
-%s`, transcript)
-
-	payload := map[string]interface{}{
-		"messages": []map[string]string{
-			{"role": "system", "content": "You are a therapist AI evaluating communication quality."},
-			{"role": "user", "content": prompt},
-		},
-		"temperature": 0.6,
+	measured := "none recorded"
+	if len(interruptions) > 0 {
+		measured = ""
+		for speaker, count := range interruptions {
+			measured += fmt.Sprintf("\n- %s interrupted their partner %d time(s)", speaker, count)
+		}
 	}
-	jsonData, _ := json.Marshal(payload)
 
-	url := fmt.Sprintf(
-		"%s/openai/deployments/%s/chat/completions?api-version=2024-02-15-preview",
-		os.Getenv("OPENAI_ENDPOINT"),
-		os.Getenv("OPENAI_DEPLOYMENT"),
-	)
-
-	req, _ := http.NewRequest("POST", url, bytes.NewBuffer(jsonData))
-	req.Header.Set("Content-Type", "application/json")
-	req.Header.Set("api-key", os.Getenv("OPENAI_API_KEY"))
-
-	client := &http.Client{Timeout: 20 * time.Second}
-	resp, err := client.Do(req)
+	prompt := prompts.Get(prompts.Score)
+	req, err := prompt.Render(prompts.Vars{
+		"schema":        scoreSchema.Instructions(),
+		"interruptions": measured,
+		"transcript":    transcript,
+	})
 	if err != nil {
 		return models.CommunicationScore{}, err
 	}
-	defer resp.Body.Close()
 
-	body, _ := io.ReadAll(resp.Body)
-
-	var openaiResp map[string]interface{}
-	if err := json.Unmarshal(body, &openaiResp); err != nil {
-		return models.CommunicationScore{}, err
-	}
+	// Room for the repair attempts
+	ctx, cancel := context.WithTimeout(context.Background(), 45*time.Second)
+	defer cancel()
 
-	var content = extractOpenAIText(openaiResp)
-	var parsed map[string]interface{}
-	if err := json.Unmarshal([]byte(content), &parsed); err != nil {
+	var score models.CommunicationScore
+	if err := llm.ChatStructured(ctx, llm.Default(), req, scoreSchema, scoreRepairAttempts, &score); err != nil {
 		return models.CommunicationScore{}, err
 	}
-
-	return models.CommunicationScore{
-		Empathy:            int(parsed["empathy"].(float64)),
-		Listening:          int(parsed["listening"].(float64)),
-		Respect:            int(parsed["respect"].(float64)),
-		Clarity:            int(parsed["clarity"].(float64)),
-		ConflictResolution: int(parsed["conflictResolution"].(float64)),
-		Summary:            parsed["summary"].(string),
-	}, nil
-}
-
-// extractOpenAIText parses message content from OpenAI response
-func extractOpenAIText(resp map[string]interface{}) string {
-	choices := resp["choices"].([]interface{})
-	message := choices[0].(map[string]interface{})["message"].(map[string]interface{})
-	return message["content"].(string)
+	score.PromptVersion = prompt.ID()
+	return score, nil
 }
 
 func GetSessionScore(c *fiber.Ctx) error {
@@ -193,6 +189,10 @@ func GetSessionScore(c *fiber.Ctx) error {
 	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
 	defer cancel()
 
+	if _, ok := findSessionForUser(ctx, sessionId, middleware.UserID(c)); !ok {
+		return c.Status(404).JSON(fiber.Map{"error": "Session not found"})
+	}
+
 	sessionsColl := database.GetCollection("sessions")
 	var result bson.M
 	err := sessionsColl.FindOne(ctx, bson.M{"_id": sessionId}).Decode(&result)
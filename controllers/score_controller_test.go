@@ -0,0 +1,57 @@
+package controllers
+
+import (
+	"errors"
+	"testing"
+
+	"mend/llm"
+	"mend/models"
+)
+
+func TestGenerateAIScore(t *testing.T) {
+	messages := []models.Message{
+		{SpeakerId: "alice", Text: "You never listen"},
+		{SpeakerId: "bob", Text: "I'm listening now"},
+	}
+
+	tests := []struct {
+		name    string
+		replies []string
+		want    models.CommunicationScore // Areas and summary only
+		wantErr bool
+	}{
+		{"fenced, with strings and fractions", []string{"Here you go:\n```json\n" +
+			`{"empathy":"4","listening":"3/5","respect":5,"clarity":4,"conflictResolution":2,"summary":"Tense."}` + "\n```"},
+			models.CommunicationScore{Empathy: 4, Listening: 3, Respect: 5, Clarity: 4, ConflictResolution: 2, Summary: "Tense."}, false},
+		{"out of range", []string{`{"empathy":7,"listening":0,"respect":5,"clarity":4,"conflict_resolution":2,"summary":"Tense."}`},
+			models.CommunicationScore{Empathy: 5, Listening: 1, Respect: 5, Clarity: 4, ConflictResolution: 2, Summary: "Tense."}, false},
+		{"repaired", []string{`{"empathy":4}`,
+			`{"empathy":4,"listening":3,"respect":5,"clarity":4,"conflictResolution":2,"summary":"Tense."}`},
+			models.CommunicationScore{Empathy: 4, Listening: 3, Respect: 5, Clarity: 4, ConflictResolution: 2, Summary: "Tense."}, false},
+		{"never usable", []string{"I'd rather not score this."}, models.CommunicationScore{}, true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			previous := llm.Default()
+			model := llm.NewScripted(tt.replies...)
+			llm.SetDefault(model)
+			defer llm.SetDefault(previous)
+
+			got, err := generateAIScore(messages, nil)
+			if tt.wantErr {
+				var outErr *llm.StructuredOutputError
+				if !errors.As(err, &outErr) || outErr.Attempts != scoreRepairAttempts+1 {
+					t.Fatalf("error = %v, want a StructuredOutputError after every repair", err)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			got.PromptVersion = ""
+			if got != tt.want {
+				t.Errorf("score = %+v, want %+v", got, tt.want)
+			}
+		})
+	}
+}
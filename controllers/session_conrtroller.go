@@ -6,54 +6,91 @@ import (
 	"time"
 
 	"mend/database"
+	"mend/middleware"
 	"mend/models"
 	"mend/utils"
 
 	"github.com/gofiber/fiber/v2"
 	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/mongo"
 )
 
 // StartSession godoc
 // @Summary      Start a new session between partners
-// @Description  Creates a session document in MongoDB and notifies partner via email
+// @Description  Creates a session for the caller's couple and notifies the partner via email.
+// @Description  With a future scheduledFor (Unix time) it is scheduled; otherwise the caller joins right away
+// @Description  and it waits for the partner. A couple can only have one open session at a time.
 // @Tags         Session
 // @Accept       json
 // @Produce      json
-// @Param        session body models.Session true "Session Info"
+// @Param        session body map[string]interface{} false "partnerB (optional check), scheduledFor"
 // @Success      201 {object} models.Session
 // @Failure      400 {object} map[string]string
+// @Failure      403 {object} map[string]string
+// @Failure      409 {object} map[string]string
 // @Failure      500 {object} map[string]string
 // @Router       /api/session [post]
 func StartSession(c *fiber.Ctx) error {
-	var session models.Session
-	if err := c.BodyParser(&session); err != nil {
-		return c.Status(400).JSON(fiber.Map{"error": "Invalid session data"})
+	var body struct {
+		PartnerA     string `json:"partnerA"`
+		PartnerB     string `json:"partnerB"`
+		ScheduledFor int64  `json:"scheduledFor"`
 	}
-	if session.PartnerA == "" || session.PartnerB == "" {
-		return c.Status(400).JSON(fiber.Map{"error": "Both partner IDs required"})
+	if len(c.Body()) > 0 {
+		if err := c.BodyParser(&body); err != nil {
+			return c.Status(400).JSON(fiber.Map{"error": "Invalid session data"})
+		}
 	}
+	callerId := middleware.UserID(c)
 
-	// Session setup
-	session.ID = utils.GeneratePartnerID()
-	session.CreatedAt = time.Now().Unix()
-	session.Resolved = false
-	session.Messages = []models.Message{}
-	session.ScoreA = models.CommunicationScore{}
-	session.ScoreB = models.CommunicationScore{}
-
-	// Insert session
 	collection := database.GetCollection("sessions")
+	usersColl := database.GetCollection("users")
 	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
 	defer cancel()
 
+	// 💞 Sessions belong to the couple: the caller is partner A, their current partner is B
+	relationship, ok := currentRelationship(ctx, callerId)
+	if !ok {
+		return c.Status(400).JSON(fiber.Map{"error": "Link with your partner before starting a session"})
+	}
+	partnerId := relationship.OtherMember(callerId)
+	if (body.PartnerA != "" && body.PartnerA != callerId) || (body.PartnerB != "" && body.PartnerB != partnerId) {
+		return c.Status(403).JSON(fiber.Map{"error": "You can only start a session with your partner"})
+	}
+
+	if open, err := findOpenSession(ctx, relationship.ID); err == nil {
+		return c.Status(409).JSON(fiber.Map{"error": "You already have an open session", "sessionId": open.ID, "status": open.Status})
+	}
+
+	// Session setup: scheduled for later, or waiting for the partner to join now
+	now := time.Now().Unix()
+	session := models.Session{
+		ID:             utils.GeneratePartnerID(),
+		PartnerA:       callerId,
+		PartnerB:       partnerId,
+		RelationshipID: relationship.ID,
+		Status:         models.SessionStatusWaitingForPartner,
+		Joined:         []string{callerId},
+		Messages:       []models.Message{},
+		CreatedAt:      now,
+	}
+	if body.ScheduledFor > now {
+		session.Status = models.SessionStatusScheduled
+		session.ScheduledFor = body.ScheduledFor
+		session.Joined = []string{}
+	}
+	session.Transitions = []models.SessionTransition{{To: session.Status, By: callerId, At: now}}
+
+	// Insert session (the open-session index turns a race with the partner into a 409)
 	_, err := collection.InsertOne(ctx, session)
+	if mongo.IsDuplicateKeyError(err) {
+		return c.Status(409).JSON(fiber.Map{"error": "You already have an open session"})
+	}
 	if err != nil {
 		return c.Status(500).JSON(fiber.Map{"error": "Failed to create session"})
 	}
 
 	// Fetch partnerB's email and PartnerA's name
-	usersColl := database.GetCollection("users")
-
 	var partnerB, partnerA models.User
 	errA := usersColl.FindOne(ctx, bson.M{"id": session.PartnerA}).Decode(&partnerA)
 	errB := usersColl.FindOne(ctx, bson.M{"id": session.PartnerB}).Decode(&partnerB)
@@ -67,6 +104,17 @@ func StartSession(c *fiber.Ctx) error {
 			<br/>
 			<p>With love,<br/>The Mend Team</p>
 		`, partnerB.Name, partnerA.Name, session.ID)
+		if session.Status == models.SessionStatusScheduled {
+			subject = "New Mend Session Scheduled 📅"
+			body = fmt.Sprintf(`
+				<h2>Hi %s,</h2>
+				<p><strong>%s</strong> has scheduled a Mend session with you for %s.</p>
+				<p>Open the app at that time to join.</p>
+				<p><i>Session ID:</i> <strong>%s</strong></p>
+				<br/>
+				<p>With love,<br/>The Mend Team</p>
+			`, partnerB.Name, partnerA.Name, time.Unix(session.ScheduledFor, 0).UTC().Format("Mon Jan 2, 15:04 MST"), session.ID)
+		}
 
 		go utils.SendEmail(partnerB.Email, subject, body)
 	}
@@ -75,44 +123,84 @@ func StartSession(c *fiber.Ctx) error {
 }
 
 // GetActiveSession godoc
-// @Summary Get active (unresolved) session for a user
+// @Summary Get the open session for a user
+// @Description Returns the open session of the user's current relationship, including its status and who has joined
 // @Tags Session
 // @Accept json
 // @Produce json
 // @Param userId path string true "User ID"
 // @Success 200 {object} models.Session
-// @Failure 404 {object} map[string]string
+// @Failure 403,404 {object} map[string]string
 // @Router /api/session/active/{userId} [get]
 func GetActiveSession(c *fiber.Ctx) error {
 	userId := c.Params("userId")
+	if userId != middleware.UserID(c) {
+		return forbidden(c)
+	}
+
 	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
 	defer cancel()
 
-	filter := bson.M{
-		"$or": []bson.M{
-			{"partnerA": userId},
-			{"partnerB": userId},
-		},
-		"resolved": false,
+	relationship, ok := currentRelationship(ctx, userId)
+	if !ok {
+		return c.Status(404).JSON(fiber.Map{"error": "No active session"})
 	}
 
-	var session models.Session
-	err := database.GetCollection("sessions").FindOne(ctx, filter).Decode(&session)
+	session, err := findOpenSession(ctx, relationship.ID)
 	if err != nil {
 		return c.Status(404).JSON(fiber.Map{"error": "No active session"})
 	}
 	return c.JSON(session)
 }
 
+// JoinSession godoc
+// @Summary      Join a session
+// @Description  Marks the caller as joined. The session becomes active once both partners are in.
+// @Tags         Session
+// @Produce      json
+// @Param        sessionId path string true "Session ID"
+// @Success      200 {object} models.Session
+// @Failure      404,409,500 {object} map[string]string
+// @Router       /api/session/join/{sessionId} [patch]
+func JoinSession(c *fiber.Ctx) error {
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+
+	callerId := middleware.UserID(c)
+	session, ok := findSessionForUser(ctx, c.Params("sessionId"), callerId)
+	if !ok {
+		return c.Status(404).JSON(fiber.Map{"error": "Session not found"})
+	}
+	for _, id := range session.Joined {
+		if id == callerId {
+			return c.JSON(session) // Already in
+		}
+	}
+
+	// The first partner in moves a scheduled session to waiting; the second one starts it
+	next := models.SessionStatusWaitingForPartner
+	if len(session.Joined) > 0 {
+		next = models.SessionStatusActive
+	}
+	session, err := transitionSession(ctx, session, next, callerId, bson.M{
+		"$addToSet": bson.M{"joined": callerId},
+	})
+	if err != nil {
+		return sessionTransitionError(c, err)
+	}
+	return c.JSON(session)
+}
+
 // EndSession godoc
-// @Summary      Mark a session as resolved
-// @Description  Updates the session's resolved field to true, sends partner email, and generates AI scores
+// @Summary      End a session
+// @Description  Moves an active or paused session to ended, sends partner email, and generates AI scores
 // @Tags         Session
 // @Accept       json
 // @Produce      json
 // @Param        sessionId path string true "Session ID"
 // @Success      200 {object} map[string]string
 // @Failure      404 {object} map[string]string
+// @Failure      409 {object} map[string]string
 // @Failure      500 {object} map[string]string
 // @Router       /api/session/end/{sessionId} [patch]
 func EndSession(c *fiber.Ctx) error {
@@ -120,22 +208,19 @@ func EndSession(c *fiber.Ctx) error {
 	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
 	defer cancel()
 
-	sessionsColl := database.GetCollection("sessions")
 	usersColl := database.GetCollection("users")
 
-	// 🔍 Find session
-	var session models.Session
-	err := sessionsColl.FindOne(ctx, bson.M{"_id": sessionId}).Decode(&session)
-	if err != nil {
+	// 🔍 Find session (only participants may end it)
+	callerId := middleware.UserID(c)
+	session, ok := findSessionForUser(ctx, sessionId, callerId)
+	if !ok {
 		return c.Status(404).JSON(fiber.Map{"error": "Session not found"})
 	}
 
-	// ✅ Update resolved flag
-	_, err = sessionsColl.UpdateOne(ctx, bson.M{"_id": sessionId}, bson.M{
-		"$set": bson.M{"resolved": true},
-	})
+	// ✅ Only a session that actually ran can end; ending twice is a 409
+	session, err := transitionSession(ctx, session, models.SessionStatusEnded, callerId, nil)
 	if err != nil {
-		return c.Status(500).JSON(fiber.Map{"error": "Failed to mark session as resolved"})
+		return sessionTransitionError(c, err)
 	}
 
 	// 📧 Notify partner via email
@@ -162,6 +247,96 @@ func EndSession(c *fiber.Ctx) error {
 	return c.JSON(fiber.Map{"message": "Session ended successfully"})
 }
 
+// AbandonSession godoc
+// @Summary      Abandon a session
+// @Description  Gives up on an open session without ending it properly (no scores are generated)
+// @Tags         Session
+// @Produce      json
+// @Param        sessionId path string true "Session ID"
+// @Success      200 {object} models.Session
+// @Failure      404,409,500 {object} map[string]string
+// @Router       /api/session/abandon/{sessionId} [patch]
+func AbandonSession(c *fiber.Ctx) error {
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+
+	callerId := middleware.UserID(c)
+	session, ok := findSessionForUser(ctx, c.Params("sessionId"), callerId)
+	if !ok {
+		return c.Status(404).JSON(fiber.Map{"error": "Session not found"})
+	}
+
+	session, err := transitionSession(ctx, session, models.SessionStatusAbandoned, callerId, nil)
+	if err != nil {
+		return sessionTransitionError(c, err)
+	}
+	return c.JSON(session)
+}
+
+// PauseSession godoc
+// @Summary      Call a time-out
+// @Description  Pauses an active session for a cooling-off period and broadcasts a countdown to both partners.
+// @Description  Without seconds (or out of the 30s–30min range) the couple's setting or server default is used.
+// @Tags         Session
+// @Accept       json
+// @Produce      json
+// @Param        sessionId path string true "Session ID"
+// @Param        body body map[string]int false "seconds"
+// @Success      200 {object} models.Session
+// @Failure      400,404,409,500 {object} map[string]string
+// @Router       /api/session/pause/{sessionId} [patch]
+func PauseSession(c *fiber.Ctx) error {
+	var body struct {
+		Seconds int `json:"seconds"`
+	}
+	if len(c.Body()) > 0 {
+		if err := c.BodyParser(&body); err != nil {
+			return c.Status(400).JSON(fiber.Map{"error": "Invalid payload"})
+		}
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+
+	callerId := middleware.UserID(c)
+	session, ok := findSessionForUser(ctx, c.Params("sessionId"), callerId)
+	if !ok {
+		return c.Status(404).JSON(fiber.Map{"error": "Session not found"})
+	}
+
+	session, err := pauseSession(ctx, session, callerId, body.Seconds)
+	if err != nil {
+		return sessionTransitionError(c, err)
+	}
+	return c.JSON(session)
+}
+
+// ResumeSession godoc
+// @Summary      End a time-out
+// @Description  Resumes a paused session once its cooling-off period is over (it also resumes on its own then)
+// @Tags         Session
+// @Produce      json
+// @Param        sessionId path string true "Session ID"
+// @Success      200 {object} models.Session
+// @Failure      404,409,500 {object} map[string]string
+// @Router       /api/session/resume/{sessionId} [patch]
+func ResumeSession(c *fiber.Ctx) error {
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+
+	callerId := middleware.UserID(c)
+	session, ok := findSessionForUser(ctx, c.Params("sessionId"), callerId)
+	if !ok {
+		return c.Status(404).JSON(fiber.Map{"error": "Session not found"})
+	}
+
+	session, err := resumeSession(ctx, session, callerId)
+	if err != nil {
+		return sessionTransitionError(c, err)
+	}
+	return c.JSON(session)
+}
+
 func autoGenerateScoreIfMissing(sessionID string, userID string, scoreField string) {
 	fmt.Println("🧠 Starting score generation for", userID, scoreField)
 
@@ -198,11 +373,13 @@ func autoGenerateScoreIfMissing(sessionID string, userID string, scoreField stri
 		return
 	}
 
-	aiScore, err := generateAIScore(messages)
+	interruptions := fetchSessionInterruptions(sessionID)
+	aiScore, err := generateAIScore(messages, interruptions)
 	if err != nil {
 		fmt.Println("❌ AI Scoring failed:", err)
 		return
 	}
+	applyInterruptions(&aiScore, interruptions[userID])
 
 	aiScore.SessionID = sessionID
 	aiScore.PartnerID = userID
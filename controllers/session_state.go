@@ -0,0 +1,107 @@
+package controllers
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"math"
+	"time"
+
+	"mend/database"
+	"mend/hub"
+	"mend/models"
+
+	"github.com/gofiber/fiber/v2"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+var errSessionChanged = errors.New("session changed concurrently")
+
+// sessionStateError is returned for a transition the state machine doesn't allow
+type sessionStateError struct {
+	From, To string
+}
+
+func (e sessionStateError) Error() string {
+	return fmt.Sprintf("Session is %s and can't become %s", e.From, e.To)
+}
+
+// transitionSession moves a session to a new state and records the change. The update only
+// applies while the session is still in the state it was read in, so of two racing requests
+// exactly one wins. `extra` holds additional update operators to apply in the same write.
+func transitionSession(ctx context.Context, session models.Session, to, by string, extra bson.M) (models.Session, error) {
+	if !models.CanTransitionSession(session.Status, to) {
+		return session, sessionStateError{From: session.Status, To: to}
+	}
+
+	now := time.Now().Unix()
+	set := bson.M{"status": to}
+	switch to {
+	case models.SessionStatusActive:
+		if session.StartedAt == 0 {
+			set["startedAt"] = now
+		}
+	case models.SessionStatusEnded, models.SessionStatusAbandoned:
+		set["endedAt"] = now
+	}
+	update := bson.M{
+		"$set":  set,
+		"$push": bson.M{"transitions": models.SessionTransition{From: session.Status, To: to, By: by, At: now}},
+	}
+	for op, fields := range extra {
+		if existing, ok := update[op].(bson.M); ok {
+			for k, v := range fields.(bson.M) {
+				existing[k] = v
+			}
+		} else {
+			update[op] = fields
+		}
+	}
+
+	var updated models.Session
+	err := database.GetCollection("sessions").FindOneAndUpdate(ctx,
+		bson.M{"_id": session.ID, "status": session.Status},
+		update,
+		options.FindOneAndUpdate().SetReturnDocument(options.After),
+	).Decode(&updated)
+	if errors.Is(err, mongo.ErrNoDocuments) {
+		return session, errSessionChanged
+	}
+	if err != nil {
+		return session, err
+	}
+
+	if to == models.SessionStatusEnded || to == models.SessionStatusAbandoned {
+		dropLiveSession(session.ID)
+		sessionHub.CloseSession(session.ID, hub.CloseSessionEnded, "session "+to)
+	}
+	return updated, nil
+}
+
+// findOpenSession returns the couple's session that hasn't ended or been abandoned yet
+func findOpenSession(ctx context.Context, relationshipId string) (models.Session, error) {
+	var session models.Session
+	err := database.GetCollection("sessions").FindOne(ctx, bson.M{
+		"relationshipId": relationshipId,
+		"status":         bson.M{"$in": models.SessionOpenStatuses},
+	}).Decode(&session)
+	return session, err
+}
+
+// sessionTransitionError maps transitionSession failures to responses
+func sessionTransitionError(c *fiber.Ctx, err error) error {
+	var stateErr sessionStateError
+	var coolingErr coolingOffError
+	switch {
+	case errors.As(err, &stateErr):
+		return c.Status(409).JSON(fiber.Map{"error": stateErr.Error(), "status": stateErr.From})
+	case errors.As(err, &coolingErr):
+		return c.Status(409).JSON(fiber.Map{"error": coolingErr.Error(), "remainingSeconds": int(math.Ceil(coolingErr.Remaining.Seconds()))})
+	case errors.Is(err, errSessionChanged):
+		return c.Status(409).JSON(fiber.Map{"error": "Session was updated by someone else, please refresh"})
+	default:
+		return c.Status(500).JSON(fiber.Map{"error": "Failed to update session"})
+	}
+}
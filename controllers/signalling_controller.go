@@ -1,27 +1,19 @@
 package controllers
 
 import (
-	"encoding/json"
+	"context"
+	"errors"
 	"log"
-	"sync"
+	"strings"
+	"time"
 
-	"mend/ai"
+	"mend/hub"
+	"mend/models"
 
 	"github.com/gofiber/fiber/v2"
 	"github.com/gofiber/websocket/v2"
 )
 
-// SessionConn stores user connections within a session
-type SessionConn struct {
-	Conns map[string]*websocket.Conn
-	Lock  sync.RWMutex
-}
-
-var (
-	sessions     = make(map[string]*SessionConn)
-	sessionsLock sync.RWMutex
-)
-
 // HandleWebSocket2 upgrades to WebSocket
 func HandleWebSocket2(c *fiber.Ctx) error {
 	if websocket.IsWebSocketUpgrade(c) {
@@ -30,81 +22,304 @@ func HandleWebSocket2(c *fiber.Ctx) error {
 	return fiber.ErrUpgradeRequired
 }
 
-// WebSocketHandler2 handles real-time messages
+// WebSocketHandler2 handles the live voice session. Clients send `transcript`, `typing` and
+// `control` envelopes (pause with optional seconds, resume, request_floor, release_floor);
+// the server answers with `control` events, `ai_warning` and `error` frames. Transcript
+// payloads may carry startMs/endMs (since the socket connected) for interruption detection.
+// On this socket `typing` means speaking and is passed on to the partner as a `presence`
+// event. WebRTC `signal` envelopes (offer, answer, candidate, hangup) go to the other
+// partner only; the ICE servers to use come from GET /api/rtc/config.
+//
+// When server-side transcription is configured, clients may instead send their microphone
+// audio as binary frames (format from `?audioFormat=`, default webm). Audio is cut into
+// utterances when the speaker stops (`typing` false) or pauses, transcribed, and handled
+// like a `transcript` frame.
 func WebSocketHandler2(c *websocket.Conn) {
 	sessionId := c.Params("sessionId")
 	userId := c.Params("userId")
+	session, _ := c.Locals("session").(models.Session)
 
-	// Register user connection
-	sessionsLock.Lock()
-	if sessions[sessionId] == nil {
-		sessions[sessionId] = &SessionConn{
-			Conns: make(map[string]*websocket.Conn),
-		}
-	}
-	sessions[sessionId].Lock.Lock()
-	sessions[sessionId].Conns[userId] = c
-	sessions[sessionId].Lock.Unlock()
-	sessionsLock.Unlock()
-
+	client := sessionHub.Register(c, sessionId, userId)
+	leave := presenceConnected(client)
 	defer func() {
-		log.Println("Disconnected:", userId)
-		sessionsLock.Lock()
-		sessions[sessionId].Lock.Lock()
-		delete(sessions[sessionId].Conns, userId)
-		sessions[sessionId].Lock.Unlock()
-		sessionsLock.Unlock()
+		releaseFloor(sessionId, userId, "released")
+		leave()
 	}()
 
-	for {
-		_, msg, err := c.ReadMessage()
+	// A time-out may already be running (e.g. the server restarted mid-pause)
+	restoreCooldown(sessionId)
+
+	// Transcript frames time their speech relative to this moment (startMs/endMs)
+	connectedAt := time.Now()
+
+	// 🎙️ Raw audio is transcribed here when a transcriber is configured
+	var audio *audioBuffer
+	if speechToText != nil {
+		audio = newAudioBuffer(client, connectedAt, c.Query("audioFormat", "webm"))
+		defer audio.close()
+		client.SetReadLimit(maxAudioFrame)
+		client.HandleBinary(func(_ *hub.Client, data []byte) {
+			audio.write(data)
+		})
+	}
+
+	client.Run(func(client *hub.Client, msg []byte) {
+		env, payload, err := hub.Decode(msg, hub.KindTranscript, hub.KindTyping, hub.KindControl, hub.KindSignal)
 		if err != nil {
-			log.Println("WebSocket read error:", err)
-			break
+			rejectFrame(client, err)
+			return
 		}
 
-		// Broadcast to all other participants
-		sessions[sessionId].Lock.RLock()
-		for otherId, conn := range sessions[sessionId].Conns {
-			if otherId == userId {
-				continue
+		switch p := payload.(type) {
+		case *hub.ControlPayload:
+			// ⏸️ Time-out and floor control messages are handled here, not relayed
+			switch p.Action {
+			case hub.ActionPause:
+				handleSocketTimeout(client, env.ID, func(ctx context.Context, session models.Session) error {
+					_, err := pauseSession(ctx, session, userId, p.Seconds)
+					return err
+				})
+			case hub.ActionResume:
+				handleSocketTimeout(client, env.ID, func(ctx context.Context, session models.Session) error {
+					_, err := resumeSession(ctx, session, userId)
+					return err
+				})
+			case hub.ActionRequestFloor:
+				requestFloor(sessionId, userId)
+			case hub.ActionReleaseFloor:
+				releaseFloor(sessionId, userId, "released")
 			}
-			if err := conn.WriteMessage(websocket.TextMessage, msg); err != nil {
-				log.Println("Write error:", err)
+			return
+
+		case *hub.TypingPayload:
+			setActivity(client, hub.PresenceSpeaking, p.Typing)
+			if !p.Typing && audio != nil {
+				audio.flush()
 			}
+			return
+
+		case *hub.SignalPayload:
+			// 📞 Call setup is between the two partners; nobody else needs it
+			relaySignal(client, session, env, p)
+			return
+
+		case *hub.TranscriptPayload:
+			handleTranscript(client, env, p, connectedAt, false)
+			return
 		}
-		sessions[sessionId].Lock.RUnlock()
-
-		// AI moderation logic for transcript messages
-		var data map[string]interface{}
-		if err := json.Unmarshal(msg, &data); err == nil {
-			if msgType, ok := data["type"].(string); ok && msgType == "transcript" {
-				text, _ := data["text"].(string)
-				speaker, _ := data["speaker"].(string)
-				go handleAIModeration(sessionId, text, speaker)
-			}
+
+		// Relay to all other participants, stamped with who sent it
+		env.SessionID = sessionId
+		env.From = userId
+		env.SentAt = time.Now().UnixMilli()
+		sessionHub.Broadcast(sessionId, env, client)
+	})
+}
+
+// handleTranscript runs one utterance, typed by the client or transcribed on the server,
+// through the session rules: no speech during a time-out, interruption detection, floor
+// control, then it is stored as a voice message, moderated and sent to the session.
+// Server transcriptions also go back to the speaker, who has no text for them yet.
+func handleTranscript(client *hub.Client, env hub.Envelope, p *hub.TranscriptPayload, connectedAt time.Time, transcribed bool) {
+	sessionId, userId := client.SessionID, client.UserID
+	if getLiveSession(sessionId).isPaused() {
+		sendSocketError(client, "session_paused", "The session is paused for a cooling-off period", env.ID)
+		return
+	}
+
+	// ⏱️ Overlapping speech is an interruption, whoever's frame arrives first
+	interrupter := ""
+	message := models.Message{
+		SpeakerId: userId,
+		SessionId: sessionId,
+		Text:      p.Text,
+		Timestamp: time.Now().Unix(),
+		Source:    models.MessageSourceVoice,
+	}
+	if !transcribed {
+		message.ClientMsgID = env.ID
+	}
+	if p.StartMs != nil {
+		u := transcriptSpan(*p.StartMs, *p.EndMs, connectedAt)
+		message.StartMs, message.EndMs = u.Start.UnixMilli(), u.End.UnixMilli()
+		var interrupted string
+		if interrupter, interrupted = detectInterruption(sessionId, userId, u); interrupter != "" {
+			go recordInterruption(sessionId, interrupter, interrupted)
+		}
+	}
+
+	// 🎤 Only the partner holding the floor is heard; the listener is reminded to wait
+	if ok, holder := mayTranscribe(sessionId, userId); !ok {
+		if interrupter != userId {
+			sendToUser(sessionId, userId, hub.KindControl, hub.ControlPayload{
+				Action:  hub.ActionInterrupt,
+				UserID:  holder,
+				Message: interruptWarningFor(holder),
+			})
 		}
+		return
+	}
+
+	// 💾 Stored like chat, so it is in the transcript, gets a seq and is replayed on reconnect
+	message, err := appendMessageToSessionByID(sessionId, message)
+	if errors.Is(err, errDuplicateMessage) {
+		return
+	}
+	if errors.Is(err, errSessionClosed) {
+		sendSocketError(client, "session_closed", "This session has ended", env.ID)
+		return
+	}
+	if err != nil {
+		log.Println("❌ Failed to store transcript:", err)
+		sendSocketError(client, "internal", "Failed to store transcript", env.ID)
+		return
+	}
+
+	go handleAIModeration(sessionId, p.Text, userId)
+
+	env.SessionID = sessionId
+	env.From = userId
+	env.SentAt = time.Now().UnixMilli()
+	env.Seq = message.Seq
+	if transcribed {
+		sessionHub.Broadcast(sessionId, env, nil)
+	} else {
+		sessionHub.Broadcast(sessionId, env, client)
 	}
 }
 
+// handleSocketTimeout runs a pause or resume requested over the socket, reporting failures back to the sender
+func handleSocketTimeout(client *hub.Client, refId string, apply func(ctx context.Context, session models.Session) error) {
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+
+	session, ok := findSessionForUser(ctx, client.SessionID, client.UserID)
+	if !ok {
+		sendSocketError(client, "not_found", "Session not found", refId)
+		return
+	}
+	if err := apply(ctx, session); err != nil {
+		var stateErr sessionStateError
+		var coolingErr coolingOffError
+		switch {
+		case errors.As(err, &stateErr):
+			sendSocketError(client, "invalid_state", stateErr.Error(), refId)
+		case errors.As(err, &coolingErr):
+			sendSocketError(client, "cooling_off", coolingErr.Error(), refId)
+		default:
+			sendSocketError(client, "internal", "Failed to update session", refId)
+		}
+	}
+}
+
+// respectWarning is what the moderator tells a speaker it flagged
+const respectWarning = "Please use respectful language."
+
+// handleAIModeration has the model rate a transcribed utterance, warns the session when it is
+// hostile and calls a time-out when the warnings pile up
 func handleAIModeration(sessionId string, transcript, speaker string) {
-	warning := ai.ModerateTranscript(transcript, speaker)
-	if warning == "" {
+	if strings.TrimSpace(transcript) == "" {
 		return
 	}
 
-	resp := map[string]interface{}{
-		"type":    "ai_warning",
-		"message": warning,
+	// 🤫 The couple can turn the moderator's interjections off
+	ctx, cancel := context.WithTimeout(context.Background(), 15*time.Second)
+	defer cancel()
+	if !moderatorAllowed(ctx, sessionId) {
+		return
 	}
-	msg, _ := json.Marshal(resp)
 
-	sessions[sessionId].Lock.RLock()
-	defer sessions[sessionId].Lock.RUnlock()
+	result, err := moderateUtterance(ctx, speaker, transcript, "")
+	if err != nil {
+		log.Println("❌ AI moderation failed:", err)
+		return
+	}
+	if !result.Warning {
+		return
+	}
 
-	for _, conn := range sessions[sessionId].Conns {
-		if err := conn.WriteMessage(websocket.TextMessage, msg); err != nil {
-			log.Println("AI warning write error:", err)
+	warnAndStrike(sessionId, respectWarning, speaker)
+}
+
+// An unanswered offer older than this no longer counts for glare
+const offerTimeout = 30 * time.Second
+
+// pendingOffer is the WebRTC offer still waiting for the other partner's answer
+type pendingOffer struct {
+	From string    `json:"from,omitempty"`
+	ID   string    `json:"id,omitempty"`
+	At   time.Time `json:"at"`
+}
+
+// signalResult is the outcome of a liveOpSignal
+type signalResult struct {
+	Rejected bool   // The offer lost glare and must not be relayed
+	Replaced string // ID of the partner's pending offer this one won glare against
+}
+
+// applySignal tracks the pending offer. When both partners have an offer out (glare),
+// partner A's wins whichever arrived first.
+func (l *liveSession) applySignal(change liveChange) signalResult {
+	pending := l.Offer
+	if !pending.At.IsZero() && change.At.Sub(pending.At) > offerTimeout {
+		pending = pendingOffer{}
+	}
+	var res signalResult
+	switch change.Signal {
+	case hub.SignalOffer:
+		if pending.From == change.Other {
+			if change.User != change.Wins {
+				return signalResult{Rejected: true}
+			}
+			res.Replaced = pending.ID
+		}
+		// No offer in flight, this partner renegotiating, or partner A winning glare
+		l.Offer = pendingOffer{From: change.User, ID: change.ID, At: change.At}
+	case hub.SignalAnswer:
+		if pending.From == change.Other {
+			l.Offer = pendingOffer{}
+		}
+	case hub.SignalHangup:
+		l.Offer = pendingOffer{}
+	}
+	return res
+}
+
+// relaySignal passes WebRTC signalling to the other partner only. When both partners send
+// an offer at once (glare), partner A's offer wins, in either order: an offer from partner B
+// while A's is pending is rejected with a `glare` error, and an offer from A while B's is
+// pending replaces it, with B told by a `glare` error (refId: B's offer) before A's offer
+// arrives. Either way B should roll back and answer A's offer; A ignores B's.
+func relaySignal(client *hub.Client, session models.Session, env hub.Envelope, p *hub.SignalPayload) {
+	userId := client.UserID
+	partnerId := session.OtherPartner(userId)
+	now := time.Now()
+
+	if p.Type != hub.SignalCandidate {
+		res, _ := getLiveSession(session.ID).update(liveChange{
+			Op:     liveOpSignal,
+			At:     now,
+			User:   userId,
+			Other:  partnerId,
+			Wins:   session.PartnerA,
+			Signal: p.Type,
+			ID:     env.ID,
+		}).(signalResult)
+		if res.Rejected {
+			sendSocketError(client, "glare", "Your partner is already calling; roll back your offer and answer theirs", env.ID)
+			return
+		}
+		if res.Replaced != "" {
+			sendToUser(session.ID, partnerId, hub.KindError, hub.ErrorPayload{
+				Code:    "glare",
+				Message: "Your partner is calling you at the same time; roll back your offer and answer theirs",
+				RefID:   res.Replaced,
+			})
 		}
 	}
+
+	env.SessionID = session.ID
+	env.From = userId
+	env.SentAt = now.UnixMilli()
+	sessionHub.SendToUser(session.ID, partnerId, env)
 }
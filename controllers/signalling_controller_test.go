@@ -0,0 +1,147 @@
+package controllers
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"testing"
+	"time"
+
+	"mend/hub"
+
+	"github.com/google/uuid"
+)
+
+func TestApplySignalGlare(t *testing.T) {
+	at := time.Date(2025, 1, 1, 12, 0, 0, 0, time.UTC)
+	// a is partner A, whose offer wins glare
+	signal := func(from, kind, id string, after time.Duration) liveChange {
+		other := "b"
+		if from == "b" {
+			other = "a"
+		}
+		return liveChange{Op: liveOpSignal, At: at.Add(after), User: from, Other: other, Wins: "a", Signal: kind, ID: id}
+	}
+
+	tests := []struct {
+		name    string
+		changes []liveChange
+		want    signalResult // Outcome of the last change
+		pending string       // Offer left pending
+	}{
+		{"first offer", []liveChange{signal("b", hub.SignalOffer, "b1", 0)}, signalResult{}, "b1"},
+		{"B offers while A's is pending", []liveChange{
+			signal("a", hub.SignalOffer, "a1", 0),
+			signal("b", hub.SignalOffer, "b1", time.Second),
+		}, signalResult{Rejected: true}, "a1"},
+		{"A offers while B's is pending", []liveChange{
+			signal("b", hub.SignalOffer, "b1", 0),
+			signal("a", hub.SignalOffer, "a1", time.Second),
+		}, signalResult{Replaced: "b1"}, "a1"},
+		{"A renegotiates", []liveChange{
+			signal("a", hub.SignalOffer, "a1", 0),
+			signal("a", hub.SignalOffer, "a2", time.Second),
+		}, signalResult{}, "a2"},
+		{"offer after the answer", []liveChange{
+			signal("a", hub.SignalOffer, "a1", 0),
+			signal("b", hub.SignalAnswer, "b1", time.Second),
+			signal("b", hub.SignalOffer, "b2", 2*time.Second),
+		}, signalResult{}, "b2"},
+		{"offer after a hangup", []liveChange{
+			signal("a", hub.SignalOffer, "a1", 0),
+			signal("a", hub.SignalHangup, "a2", time.Second),
+			signal("b", hub.SignalOffer, "b1", 2*time.Second),
+		}, signalResult{}, "b1"},
+		{"unanswered offer times out", []liveChange{
+			signal("a", hub.SignalOffer, "a1", 0),
+			signal("b", hub.SignalOffer, "b1", offerTimeout+time.Second),
+		}, signalResult{}, "b1"},
+		{"timed-out offer isn't replaced", []liveChange{
+			signal("b", hub.SignalOffer, "b1", 0),
+			signal("a", hub.SignalOffer, "a1", offerTimeout+time.Second),
+		}, signalResult{}, "a1"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			l := &liveSession{}
+			var got interface{}
+			for _, change := range tt.changes {
+				raw, _ := json.Marshal(change)
+				got = l.Apply(raw)
+			}
+			if got != tt.want {
+				t.Errorf("outcome = %+v, want %+v", got, tt.want)
+			}
+			if l.Offer.ID != tt.pending {
+				t.Errorf("pending offer = %q, want %q", l.Offer.ID, tt.pending)
+			}
+		})
+	}
+}
+
+func TestGlareAcrossReplicas(t *testing.T) {
+	r1, r2 := newTestReplicas(t)
+	signal := func(r liveRef, from, other, id string) signalResult {
+		res, _ := r.update(liveChange{Op: liveOpSignal, User: from, Other: other, Wins: "a", Signal: hub.SignalOffer, ID: id}).(signalResult)
+		return res
+	}
+
+	// B calls through one instance, A through the other a moment later: A still wins
+	if res := signal(r2, "b", "a", "b1"); res != (signalResult{}) {
+		t.Fatalf("B's first offer = %+v", res)
+	}
+	if res := signal(r1, "a", "b", "a1"); res.Replaced != "b1" {
+		t.Fatalf("A's offer = %+v, want it to replace b1", res)
+	}
+	if res := signal(r2, "b", "a", "b2"); !res.Rejected {
+		t.Fatalf("B's retry while A's offer is pending = %+v, want rejected", res)
+	}
+	assertSameState(t, r1, r2)
+}
+
+func TestHandleAIModerationEscalates(t *testing.T) {
+	fake := useFakeLLM(t)
+	paused := make(chan string, 1)
+	allowed := true
+	moderatorAllowed = func(context.Context, string) bool { return allowed }
+	moderatorPause = func(sessionId string) { paused <- sessionId }
+	defer func() { moderatorAllowed, moderatorPause = sessionAllowsAI, pauseForEscalation }()
+
+	sessionId := uuid.NewString()
+	conn := newTestConn()
+	client := sessionHub.Register(conn, sessionId, "bob")
+	defer client.Close()
+
+	steps := []struct {
+		transcript string
+		warnings   int  // ai_warning frames sent so far
+		pause      bool // This utterance calls the time-out
+	}{
+		{"I'd like to talk about the weekend", 0, false},
+		{"you are so stupid!", 1, false},
+		{"I HATE when you do that, honestly", 2, false},
+		{"Just shut up for once.", 3, true},
+	}
+	for _, step := range steps {
+		handleAIModeration(sessionId, step.transcript, "alice")
+		waitForCondition(t, fmt.Sprintf("%d warnings after %q", step.warnings, step.transcript), func() bool { return conn.count(hub.KindAIWarning) == step.warnings })
+		select {
+		case id := <-paused:
+			if !step.pause || id != sessionId {
+				t.Fatalf("%q paused session %s", step.transcript, id)
+			}
+		case <-time.After(100 * time.Millisecond):
+			if step.pause {
+				t.Fatalf("%q didn't call a time-out after three warnings", step.transcript)
+			}
+		}
+	}
+
+	// 🤫 With interjections off the model isn't even asked
+	allowed = false
+	before := len(fake.Requests())
+	handleAIModeration(sessionId, "you idiot", "alice")
+	if got := len(fake.Requests()); got != before || conn.count(hub.KindAIWarning) != 3 {
+		t.Errorf("moderated with AI off: %d more requests, %d warnings", got-before, conn.count(hub.KindAIWarning))
+	}
+}
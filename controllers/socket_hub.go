@@ -0,0 +1,96 @@
+package controllers
+
+import (
+	"context"
+	"errors"
+	"log"
+	"os"
+	"time"
+
+	"mend/ai"
+	"mend/config"
+	"mend/hub"
+
+	"github.com/redis/go-redis/v9"
+)
+
+// sessionHub holds every session socket, chat and voice alike, and each session's live state
+var sessionHub = hub.New(hub.Config{NewReplica: newLiveSession})
+
+// SetupSockets configures heartbeats, timeouts, the backplane and voice transcription from
+// the environment; call it after config.LoadEnv and before serving. Sockets are
+// unconfigured defaults until then. With REDIS_URL set, session traffic and live state
+// (time-outs, floor, presence, call setup) fan out through Redis so several replicas can
+// serve the same session; otherwise they stay in this process.
+func SetupSockets() {
+	cfg := hub.Config{
+		PingInterval: time.Duration(config.GetInt("WS_PING_INTERVAL_SECONDS", 25)) * time.Second,
+		PongTimeout:  time.Duration(config.GetInt("WS_PONG_TIMEOUT_SECONDS", 60)) * time.Second,
+		IdleTimeout:  time.Duration(config.GetInt("WS_IDLE_TIMEOUT_SECONDS", 600)) * time.Second,
+		NewReplica:   newLiveSession,
+	}
+	if url := os.Getenv("REDIS_URL"); url != "" {
+		opts, err := redis.ParseURL(url)
+		if err != nil {
+			panic("REDIS_URL is invalid: " + err.Error())
+		}
+		cfg.Broker = hub.NewRedisBroker(redis.NewClient(opts))
+		log.Println("✅ Session sockets fan out through Redis")
+	}
+	sessionHub = hub.New(cfg)
+
+	speechToText = ai.TranscriberFromEnv()
+	if speechToText != nil {
+		log.Println("✅ Voice sessions accept audio for server-side transcription")
+	}
+}
+
+// ShutdownSockets tells every connected client the server is going away, after their
+// queued frames have been written
+func ShutdownSockets(ctx context.Context) error {
+	return sessionHub.Shutdown(ctx)
+}
+
+// broadcastToSession sends a server event to everyone connected to the session
+func broadcastToSession(sessionId, msgType string, payload interface{}) {
+	env, err := hub.NewEnvelope(msgType, sessionId, "", payload)
+	if err != nil {
+		log.Println("❌ Failed to build socket event:", err)
+		return
+	}
+	sessionHub.Broadcast(sessionId, env, nil)
+}
+
+// sendToUser sends a server event to one partner's sockets in the session
+func sendToUser(sessionId, userId, msgType string, payload interface{}) {
+	env, err := hub.NewEnvelope(msgType, sessionId, "", payload)
+	if err != nil {
+		log.Println("❌ Failed to build socket event:", err)
+		return
+	}
+	sessionHub.SendToUser(sessionId, userId, env)
+}
+
+// broadcastControl sends a session control event (time-outs, floor changes) to everyone in the session
+func broadcastControl(sessionId string, payload hub.ControlPayload) {
+	broadcastToSession(sessionId, hub.KindControl, payload)
+}
+
+// sendSocketError reports a rejected frame back to the client that sent it.
+// refId is the rejected frame's id, so the client can tell which one failed.
+func sendSocketError(c *hub.Client, code, message, refId string) {
+	env, err := hub.NewEnvelope(hub.KindError, c.SessionID, "", hub.ErrorPayload{Code: code, Message: message, RefID: refId})
+	if err == nil {
+		c.Send(env)
+	}
+}
+
+// rejectFrame answers a frame that failed to decode
+func rejectFrame(c *hub.Client, err error) {
+	var decodeErr *hub.DecodeError
+	if errors.As(err, &decodeErr) {
+		sendSocketError(c, decodeErr.Code, decodeErr.Message, decodeErr.RefID)
+		return
+	}
+	sendSocketError(c, hub.CodeBadFrame, err.Error(), "")
+}
@@ -0,0 +1,197 @@
+package controllers
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"strings"
+	"time"
+
+	"mend/config"
+	"mend/database"
+	"mend/models"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+const (
+	// Most recent messages the therapist AI reads, before the token budget trims them further
+	therapistHistoryMessages = 50
+	// Rough size of a token, in bytes of English text
+	bytesPerToken = 4
+)
+
+// therapistInput is everything the therapist AI is told before it replies
+type therapistInput struct {
+	Speaker         string // Name of whoever just spoke
+	Message         string // What they said
+	Conversation    string // Recent messages, "Name: text" per line, oldest first
+	Partners        string // Each partner's onboarding goals and challenges
+	PreviousSummary string // How the couple's last session was summed up
+}
+
+// therapistContextTokens is the prompt budget for the context around the message:
+// THERAPIST_CONTEXT_TOKENS, default 2000, at least 1
+func therapistContextTokens() int {
+	return max(config.GetInt("THERAPIST_CONTEXT_TOKENS", 2000), 1)
+}
+
+// estimateTokens approximates how many tokens text costs without a tokenizer
+func estimateTokens(text string) int {
+	return (len(text) + bytesPerToken - 1) / bytesPerToken
+}
+
+// loadTherapistInput gathers the context for replying to message in a session: speaker
+// names, the couple's goals and challenges, the previous session's summary and as much of
+// the conversation before message as fits the token budget. Lookups that fail leave their
+// part out rather than failing the reply.
+func loadTherapistInput(session models.Session, message models.Message) therapistInput {
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+
+	return buildTherapistInput(session, message, loadPartners(ctx, session), previousSessionSummary(ctx, session))
+}
+
+// buildTherapistInput is loadTherapistInput once the partners and previous summary are in.
+// A stored message is left out of the conversation along with anything sent after it. One
+// without a seq, like a transcript sent to ModerateChat, leaves out its speaker's latest
+// message when that has the same text, since it is the same message already stored.
+func buildTherapistInput(session models.Session, message models.Message, partners []models.User, previousSummary string) therapistInput {
+	names := map[string]string{"AI": "Therapist AI"}
+	for i, user := range partners {
+		names[user.ID] = user.Name
+		if user.Name == "" {
+			names[user.ID] = "Partner " + string(rune('A'+i))
+		}
+	}
+
+	in := therapistInput{
+		Speaker:         speakerName(names, message.SpeakerId),
+		Message:         message.Text,
+		Partners:        describePartners(partners, names),
+		PreviousSummary: previousSummary,
+	}
+
+	// 🪟 Newest messages first until the budget left after everything else runs out
+	budget := therapistContextTokens() - estimateTokens(in.Partners+in.PreviousSummary+in.Message)
+	answered := message.Seq > 0
+	var lines []string
+	for i := len(session.Messages) - 1; i >= 0 && len(lines) < therapistHistoryMessages; i-- {
+		m := session.Messages[i]
+		if message.Seq > 0 && m.Seq >= message.Seq {
+			continue // The message being answered, or one sent since
+		}
+		if !answered && m.SpeakerId == message.SpeakerId {
+			answered = true
+			if strings.TrimSpace(m.Text) == strings.TrimSpace(message.Text) {
+				continue // The stored copy of the message being answered
+			}
+		}
+		line := speakerName(names, m.SpeakerId) + ": " + m.Text
+		if budget -= estimateTokens(line) + 1; budget < 0 {
+			break
+		}
+		lines = append(lines, line)
+	}
+	for i, j := 0, len(lines)-1; i < j; i, j = i+1, j-1 {
+		lines[i], lines[j] = lines[j], lines[i]
+	}
+	in.Conversation = strings.Join(lines, "\n")
+	if in.Conversation == "" {
+		in.Conversation = "(this is the first message)"
+	}
+	return in
+}
+
+// loadPartners returns the session's partners, A then B, with whatever could be loaded
+func loadPartners(ctx context.Context, session models.Session) []models.User {
+	partners := []models.User{{ID: session.PartnerA}, {ID: session.PartnerB}}
+	cursor, err := database.GetCollection("users").Find(ctx, bson.M{"id": bson.M{"$in": []string{session.PartnerA, session.PartnerB}}})
+	if err != nil {
+		log.Println("❌ Failed to load partners for the therapist AI:", err)
+		return partners
+	}
+	var users []models.User
+	if err := cursor.All(ctx, &users); err != nil {
+		log.Println("❌ Failed to decode partners for the therapist AI:", err)
+		return partners
+	}
+	for _, user := range users {
+		for i := range partners {
+			if partners[i].ID == user.ID {
+				partners[i] = user
+			}
+		}
+	}
+	return partners
+}
+
+// speakerName resolves a speaker id to the name the therapist AI should use
+func speakerName(names map[string]string, speakerId string) string {
+	if name, ok := names[speakerId]; ok {
+		return name
+	}
+	return "A partner"
+}
+
+// describePartners lists each partner's onboarding goals and challenges
+func describePartners(partners []models.User, names map[string]string) string {
+	var b strings.Builder
+	for _, user := range partners {
+		goals := withOther(user.Goals, user.OtherGoal)
+		challenges := withOther(user.Challenges, user.OtherChallenge)
+		fmt.Fprintf(&b, "- %s: goals: %s; challenges: %s\n", names[user.ID], orNone(goals), orNone(challenges))
+	}
+	return strings.TrimSpace(b.String())
+}
+
+func withOther(items []string, other string) []string {
+	if other = strings.TrimSpace(other); other != "" {
+		return append(append([]string(nil), items...), other)
+	}
+	return items
+}
+
+func orNone(items []string) string {
+	if len(items) == 0 {
+		return "none shared"
+	}
+	return strings.Join(items, ", ")
+}
+
+// previousSessionSummary returns the AI summary of the couple's last ended session
+func previousSessionSummary(ctx context.Context, session models.Session) string {
+	filter := bson.M{
+		"_id":    bson.M{"$ne": session.ID},
+		"status": models.SessionStatusEnded,
+	}
+	if session.RelationshipID != "" {
+		filter["relationshipId"] = session.RelationshipID
+	} else {
+		pair := []string{session.PartnerA, session.PartnerB}
+		filter["partnerA"] = bson.M{"$in": pair}
+		filter["partnerB"] = bson.M{"$in": pair}
+	}
+
+	var previous models.Session
+	err := database.GetCollection("sessions").FindOne(ctx, filter,
+		options.FindOne().
+			SetSort(bson.D{{Key: "endedAt", Value: -1}}).
+			SetProjection(bson.M{"scoreA.summary": 1, "scoreB.summary": 1}),
+	).Decode(&previous)
+	switch {
+	case errors.Is(err, mongo.ErrNoDocuments):
+		return "This is their first session together."
+	case err != nil:
+		log.Println("❌ Failed to load the previous session summary:", err)
+		return "Not available."
+	case previous.ScoreA.Summary != "":
+		return previous.ScoreA.Summary
+	case previous.ScoreB.Summary != "":
+		return previous.ScoreB.Summary
+	}
+	return "Their last session wasn't summarized."
+}
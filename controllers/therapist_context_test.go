@@ -0,0 +1,60 @@
+package controllers
+
+import (
+	"testing"
+
+	"mend/models"
+)
+
+func TestBuildTherapistInput(t *testing.T) {
+	partners := []models.User{
+		{ID: "alice", Name: "Alice", Goals: []string{"listen more"}},
+		{ID: "bob", Challenges: []string{"conflict"}, OtherChallenge: "work stress"},
+	}
+	session := models.Session{Messages: []models.Message{
+		{Seq: 1, SpeakerId: "alice", Text: "Hi"},
+		{Seq: 2, SpeakerId: "bob", Text: "Hello there"},
+		{Seq: 3, SpeakerId: "AI", Text: "How are you both?"},
+		{Seq: 4, SpeakerId: "carol", Text: "?"},
+		{Seq: 5, SpeakerId: "alice", Text: "You never listen"},
+	}}
+	const (
+		all    = "Alice: Hi\nPartner B: Hello there\nTherapist AI: How are you both?\nA partner: ?"
+		recent = "Therapist AI: How are you both?\nA partner: ?"
+		first  = "(this is the first message)"
+	)
+
+	// The partners, summary and message take 40 of the budget, and each line its length / 4 + 1
+	tests := []struct {
+		name    string
+		tokens  string // THERAPIST_CONTEXT_TOKENS
+		message models.Message
+		want    string
+	}{
+		{"whole conversation", "2000", session.Messages[4], all},
+		{"answered message and later ones left out", "2000", session.Messages[1], "Alice: Hi"},
+		{"budget keeps the newest", "60", session.Messages[4], "Partner B: Hello there\n" + recent},
+		{"tighter budget", "53", session.Messages[4], recent},
+		{"nothing fits", "1", session.Messages[4], first},
+		{"budget clamped to 1", "0", session.Messages[4], first},
+		{"unreadable budget uses the default", "lots", session.Messages[4], all},
+		{"transcript already stored", "2000", models.Message{SpeakerId: "alice", Text: " You never listen"}, all},
+		{"transcript not stored yet", "2000", models.Message{SpeakerId: "alice", Text: "Hi"}, all + "\nAlice: You never listen"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			t.Setenv("THERAPIST_CONTEXT_TOKENS", tt.tokens)
+			in := buildTherapistInput(session, tt.message, partners, "They argued about chores.")
+			if in.Conversation != tt.want {
+				t.Errorf("conversation =\n%s\nwant\n%s", in.Conversation, tt.want)
+			}
+		})
+	}
+
+	// 🏷️ Names: the speaker, and a partner without one called by their letter
+	in := buildTherapistInput(session, session.Messages[4], partners, "They argued about chores.")
+	want := "- Alice: goals: listen more; challenges: none shared\n- Partner B: goals: none shared; challenges: conflict, work stress"
+	if in.Speaker != "Alice" || in.Partners != want || in.PreviousSummary != "They argued about chores." {
+		t.Errorf("speaker = %q, partners = %q, summary = %q", in.Speaker, in.Partners, in.PreviousSummary)
+	}
+}
@@ -0,0 +1,315 @@
+package controllers
+
+import (
+	"context"
+	"strings"
+	"sync"
+	"time"
+
+	"mend/database"
+	"mend/middleware"
+	"mend/models"
+	"mend/utils"
+
+	"github.com/gofiber/fiber/v2"
+	"go.mongodb.org/mongo-driver/bson"
+)
+
+const (
+	twoFactorIssuer       = "Mend"
+	twoFactorChallengeTTL = 5 * time.Minute
+	recoveryCodeCount     = 8
+)
+
+// twoFactorNow is the clock used for TOTP checks; swap it for a fixed time offline
+var twoFactorNow = time.Now
+
+// SetupTwoFactor godoc
+// @Summary      Start TOTP enrolment
+// @Description  Generates a new secret and returns it with an otpauth:// URI for QR display. 2FA is only enabled after the first code is confirmed.
+// @Tags         Auth
+// @Produce      json
+// @Success      200 {object} map[string]string
+// @Failure      404,409,500 {object} map[string]string
+// @Router       /api/2fa/setup [post]
+func SetupTwoFactor(c *fiber.Ctx) error {
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+
+	users := database.GetCollection("users")
+	var user models.User
+	if err := users.FindOne(ctx, bson.M{"id": middleware.UserID(c)}).Decode(&user); err != nil {
+		return c.Status(404).JSON(fiber.Map{"error": "User not found"})
+	}
+	if user.TwoFactorEnabled {
+		return c.Status(409).JSON(fiber.Map{"error": "Two-factor authentication is already enabled"})
+	}
+
+	secret, err := utils.GenerateTOTPSecret()
+	if err != nil {
+		return c.Status(500).JSON(fiber.Map{"error": "Failed to generate secret"})
+	}
+	encrypted, err := utils.EncryptSecret(secret)
+	if err != nil {
+		return c.Status(500).JSON(fiber.Map{"error": "Failed to protect secret"})
+	}
+
+	if _, err := users.UpdateOne(ctx, bson.M{"id": user.ID}, bson.M{"$set": bson.M{"twoFactorPending": encrypted}}); err != nil {
+		return c.Status(500).JSON(fiber.Map{"error": "Failed to start enrolment"})
+	}
+
+	return c.JSON(fiber.Map{
+		"secret":          secret,
+		"provisioningUri": utils.TOTPProvisioningURI(secret, user.Email, twoFactorIssuer),
+	})
+}
+
+// EnableTwoFactor godoc
+// @Summary      Confirm TOTP enrolment
+// @Description  Verifies the first code from the authenticator app, enables 2FA and returns single-use recovery codes (shown once)
+// @Tags         Auth
+// @Accept       json
+// @Produce      json
+// @Param        body body map[string]string true "code"
+// @Success      200 {object} map[string]interface{}
+// @Failure      400,404,500 {object} map[string]string
+// @Router       /api/2fa/enable [post]
+func EnableTwoFactor(c *fiber.Ctx) error {
+	var body struct {
+		Code string `json:"code"`
+	}
+	if err := c.BodyParser(&body); err != nil || body.Code == "" {
+		return c.Status(400).JSON(fiber.Map{"error": "Missing code"})
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
+	defer cancel()
+
+	users := database.GetCollection("users")
+	var user models.User
+	if err := users.FindOne(ctx, bson.M{"id": middleware.UserID(c)}).Decode(&user); err != nil {
+		return c.Status(404).JSON(fiber.Map{"error": "User not found"})
+	}
+	if user.TwoFactorPending == "" {
+		return c.Status(400).JSON(fiber.Map{"error": "Start enrolment first"})
+	}
+
+	secret, err := utils.DecryptSecret(user.TwoFactorPending)
+	if err != nil {
+		return c.Status(500).JSON(fiber.Map{"error": "Failed to read secret"})
+	}
+	step, ok := utils.ValidateTOTP(secret, body.Code, twoFactorNow())
+	if !ok {
+		return c.Status(400).JSON(fiber.Map{"error": "Invalid code"})
+	}
+
+	codes, err := utils.GenerateRecoveryCodes(recoveryCodeCount)
+	if err != nil {
+		return c.Status(500).JSON(fiber.Map{"error": "Failed to generate recovery codes"})
+	}
+
+	_, err = users.UpdateOne(ctx,
+		bson.M{"id": user.ID, "twoFactorPending": user.TwoFactorPending},
+		bson.M{
+			"$set": bson.M{
+				"twoFactorEnabled":  true,
+				"twoFactorSecret":   user.TwoFactorPending,
+				"twoFactorLastStep": step,
+				"recoveryCodes":     hashRecoveryCodes(codes),
+			},
+			"$unset": bson.M{"twoFactorPending": ""},
+		},
+	)
+	if err != nil {
+		return c.Status(500).JSON(fiber.Map{"error": "Failed to enable two-factor authentication"})
+	}
+
+	return c.JSON(fiber.Map{
+		"message":       "Two-factor authentication enabled",
+		"recoveryCodes": codes,
+	})
+}
+
+// DisableTwoFactor godoc
+// @Summary      Disable TOTP
+// @Description  Requires the account password plus a current code or a recovery code
+// @Tags         Auth
+// @Accept       json
+// @Produce      json
+// @Param        body body map[string]string true "password, code or recoveryCode"
+// @Success      200 {object} map[string]string
+// @Failure      400,401,404,429,500 {object} map[string]string
+// @Router       /api/2fa/disable [post]
+func DisableTwoFactor(c *fiber.Ctx) error {
+	var body struct {
+		Password     string `json:"password"`
+		Code         string `json:"code"`
+		RecoveryCode string `json:"recoveryCode"`
+	}
+	if err := c.BodyParser(&body); err != nil || body.Password == "" {
+		return c.Status(400).JSON(fiber.Map{"error": "Missing password"})
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
+	defer cancel()
+
+	users := database.GetCollection("users")
+	var user models.User
+	if err := users.FindOne(ctx, bson.M{"id": middleware.UserID(c)}).Decode(&user); err != nil {
+		return c.Status(404).JSON(fiber.Map{"error": "User not found"})
+	}
+	if !user.TwoFactorEnabled {
+		return c.Status(400).JSON(fiber.Map{"error": "Two-factor authentication is not enabled"})
+	}
+
+	// 🔐 A stolen access token mustn't become a way to guess the password: share the login budget
+	ipKey, accountKey := loginKeys(c, user.Email)
+	if wait := loginBackoff(ipKey, accountKey); wait > 0 {
+		return tooManyLoginAttempts(c, wait)
+	}
+	if !utils.CheckPassword(body.Password, user.Password) {
+		recordLoginFailure(ipKey, accountKey)
+		return c.Status(401).JSON(fiber.Map{"error": "Invalid password or code"})
+	}
+
+	ok, err := verifySecondFactor(ctx, user, body.Code, body.RecoveryCode)
+	if err != nil {
+		return c.Status(500).JSON(fiber.Map{"error": "Failed to verify code"})
+	}
+	if !ok {
+		recordLoginFailure(ipKey, accountKey)
+		return c.Status(401).JSON(fiber.Map{"error": "Invalid password or code"})
+	}
+	accountLoginLimiter.Reset(accountKey)
+
+	_, err = users.UpdateOne(ctx, bson.M{"id": user.ID}, bson.M{
+		"$set":   bson.M{"twoFactorEnabled": false},
+		"$unset": bson.M{"twoFactorSecret": "", "twoFactorPending": "", "twoFactorLastStep": "", "recoveryCodes": ""},
+	})
+	if err != nil {
+		return c.Status(500).JSON(fiber.Map{"error": "Failed to disable two-factor authentication"})
+	}
+	return c.JSON(fiber.Map{"message": "Two-factor authentication disabled"})
+}
+
+// LoginTwoFactor godoc
+// @Summary      Complete login with a second factor
+// @Description  Exchanges the challenge token from /api/login plus a TOTP or recovery code for access/refresh tokens
+// @Tags         Auth
+// @Accept       json
+// @Produce      json
+// @Param        body body map[string]string true "challengeToken, code or recoveryCode"
+// @Success      200 {object} map[string]interface{}
+// @Failure      400,401,429,500 {object} map[string]string
+// @Router       /api/login/2fa [post]
+func LoginTwoFactor(c *fiber.Ctx) error {
+	var body struct {
+		ChallengeToken string `json:"challengeToken"`
+		Code           string `json:"code"`
+		RecoveryCode   string `json:"recoveryCode"`
+	}
+	if err := c.BodyParser(&body); err != nil || body.ChallengeToken == "" || (body.Code == "" && body.RecoveryCode == "") {
+		return c.Status(400).JSON(fiber.Map{"error": "Missing challenge token or code"})
+	}
+
+	claims, err := utils.ParseToken(body.ChallengeToken, utils.TokenTypeTwoFactor)
+	if err != nil {
+		return c.Status(401).JSON(fiber.Map{"error": "Login challenge expired, please log in again"})
+	}
+
+	// Six digits are easy to guess without a limit
+	ipKey, accountKey := loginKeys(c, "2fa:"+claims.Subject)
+	if wait := loginBackoff(ipKey, accountKey); wait > 0 {
+		return tooManyLoginAttempts(c, wait)
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
+	defer cancel()
+
+	var user models.User
+	if err := database.GetCollection("users").FindOne(ctx, bson.M{"id": claims.Subject}).Decode(&user); err != nil {
+		return c.Status(401).JSON(fiber.Map{"error": "Invalid code"})
+	}
+
+	ok, err := verifySecondFactor(ctx, user, body.Code, body.RecoveryCode)
+	if err != nil {
+		return c.Status(500).JSON(fiber.Map{"error": "Failed to verify code"})
+	}
+	if !ok {
+		recordLoginFailure(ipKey, accountKey)
+		return c.Status(401).JSON(fiber.Map{"error": "Invalid code"})
+	}
+	accountLoginLimiter.Reset(accountKey)
+
+	tokens, err := startAuthSession(ctx, c, user.ID)
+	if err != nil {
+		return c.Status(500).JSON(fiber.Map{"error": "Failed to issue tokens"})
+	}
+
+	user.Password = ""
+	withPartnerID(ctx, &user)
+	tokens["user"] = user
+	return c.JSON(tokens)
+}
+
+// verifySecondFactor accepts either a fresh TOTP code or an unused recovery code.
+// Both are consumed atomically so the same code can't be used twice.
+func verifySecondFactor(ctx context.Context, user models.User, code, recoveryCode string) (bool, error) {
+	users := database.GetCollection("users")
+
+	if code != "" {
+		secret, err := utils.DecryptSecret(user.TwoFactorSecret)
+		if err != nil {
+			return false, err
+		}
+		step, ok := utils.ValidateTOTP(secret, code, twoFactorNow())
+		if !ok || step <= user.TwoFactorLastStep {
+			return false, nil
+		}
+		res, err := users.UpdateOne(ctx,
+			bson.M{"id": user.ID, "$or": []bson.M{
+				{"twoFactorLastStep": bson.M{"$lt": step}},
+				{"twoFactorLastStep": bson.M{"$exists": false}},
+			}},
+			bson.M{"$set": bson.M{"twoFactorLastStep": step}},
+		)
+		if err != nil {
+			return false, err
+		}
+		return res.MatchedCount == 1, nil
+	}
+
+	recoveryCode = strings.ToLower(strings.TrimSpace(recoveryCode))
+	if recoveryCode == "" {
+		return false, nil
+	}
+	for _, hash := range user.RecoveryCodes {
+		if !utils.CheckPassword(recoveryCode, hash) {
+			continue
+		}
+		res, err := users.UpdateOne(ctx,
+			bson.M{"id": user.ID, "recoveryCodes": hash},
+			bson.M{"$pull": bson.M{"recoveryCodes": hash}},
+		)
+		if err != nil {
+			return false, err
+		}
+		return res.MatchedCount == 1, nil
+	}
+	return false, nil
+}
+
+// hashRecoveryCodes hashes codes in parallel; bcrypt at cost 14 is slow one by one
+func hashRecoveryCodes(codes []string) []string {
+	hashes := make([]string, len(codes))
+	var wg sync.WaitGroup
+	for i, code := range codes {
+		wg.Add(1)
+		go func(i int, code string) {
+			defer wg.Done()
+			hashes[i] = utils.HashPassword(code)
+		}(i, code)
+	}
+	wg.Wait()
+	return hashes
+}
@@ -2,15 +2,18 @@ package controllers
 
 import (
 	"context"
-	"fmt"
+	"errors"
+	"log"
 	"time"
 
 	"mend/database"
+	"mend/middleware"
 	"mend/models"
 	"mend/utils"
 
 	"github.com/gofiber/fiber/v2"
 	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/mongo"
 )
 
 // RegisterUser godoc
@@ -63,6 +66,11 @@ func RegisterUser(c *fiber.Ctx) error {
 		return c.Status(500).JSON(fiber.Map{"error": "Failed to save user"})
 	}
 
+	// 💌 Ask the user to confirm their address before they can invite a partner
+	if err := sendVerificationEmail(ctx, user); err != nil {
+		log.Println("Failed to send verification email:", err)
+	}
+
 	user.Password = "" // Don't return password
 	return c.Status(201).JSON(user)
 }
@@ -89,7 +97,10 @@ func SubmitOnboarding(c *fiber.Ctx) error {
 		return c.Status(400).JSON(fiber.Map{"error": "Invalid payload"})
 	}
 	if data.UserID == "" {
-		return c.Status(400).JSON(fiber.Map{"error": "Missing userId"})
+		data.UserID = middleware.UserID(c)
+	}
+	if data.UserID != middleware.UserID(c) {
+		return forbidden(c)
 	}
 
 	collection := database.GetCollection("users")
@@ -113,13 +124,13 @@ func SubmitOnboarding(c *fiber.Ctx) error {
 
 // LoginUser godoc
 // @Summary Login a user
-// @Description Logs in user by email and password
+// @Description Logs in user by email and password. Accounts with 2FA receive a challengeToken to complete at /api/login/2fa.
 // @Tags Users
 // @Accept json
 // @Produce json
 // @Param credentials body map[string]string true "Login credentials (email & password)"
-// @Success 200 {object} models.User
-// @Failure 400,401,404 {object} map[string]string
+// @Success 200 {object} map[string]interface{} "user plus accessToken and refreshToken"
+// @Failure 400,401,429,500 {object} map[string]string
 // @Router /api/login [post]
 func LoginUser(c *fiber.Ctx) error {
 	type LoginRequest struct {
@@ -132,28 +143,58 @@ func LoginUser(c *fiber.Ctx) error {
 		return c.Status(400).JSON(fiber.Map{"error": "Invalid login payload"})
 	}
 
+	// 🛑 Back off callers that keep failing, per IP and per account
+	ipKey, accountKey := loginKeys(c, req.Email)
+	if wait := loginBackoff(ipKey, accountKey); wait > 0 {
+		return tooManyLoginAttempts(c, wait)
+	}
+
 	collection := database.GetCollection("users")
 	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
 	defer cancel()
 
+	// ✅ Validate password using bcrypt. Unknown emails still pay for a bcrypt comparison
+	// and get the same answer as a wrong password, so neither leaks which emails exist.
 	var user models.User
 	err := collection.FindOne(ctx, fiber.Map{"email": req.Email}).Decode(&user)
-	if err != nil {
-		return c.Status(404).JSON(fiber.Map{"error": "User not found"})
+	if err != nil && !errors.Is(err, mongo.ErrNoDocuments) {
+		return c.Status(500).JSON(fiber.Map{"error": "Failed to log in"})
+	}
+	var valid bool
+	if err == nil {
+		valid = utils.CheckPassword(req.Password, user.Password)
+	} else {
+		valid = utils.CheckDummyPassword(req.Password)
+	}
+	if !valid {
+		recordLoginFailure(ipKey, accountKey)
+		return c.Status(401).JSON(fiber.Map{"error": "Invalid email or password"})
+	}
+	accountLoginLimiter.Reset(accountKey)
+
+	// 🔐 Accounts with 2FA get a short-lived challenge instead of tokens (see /api/login/2fa)
+	if user.TwoFactorEnabled {
+		challenge, err := utils.GenerateToken(user.ID, "", utils.TokenTypeTwoFactor, twoFactorChallengeTTL)
+		if err != nil {
+			return c.Status(500).JSON(fiber.Map{"error": "Failed to start two-factor login"})
+		}
+		return c.JSON(fiber.Map{"twoFactorRequired": true, "challengeToken": challenge})
 	}
 
-	// ✅ Validate password using bcrypt
-	if !utils.CheckPassword(req.Password, user.Password) {
-		return c.Status(401).JSON(fiber.Map{"error": "Invalid password"})
+	tokens, err := startAuthSession(ctx, c, user.ID)
+	if err != nil {
+		return c.Status(500).JSON(fiber.Map{"error": "Failed to issue tokens"})
 	}
 
 	user.Password = "" // Hide password before returning
-	return c.JSON(user)
+	withPartnerID(ctx, &user)
+	tokens["user"] = user
+	return c.JSON(tokens)
 }
 
 // GetUser godoc
 // @Summary Get user by ID
-// @Description Fetches user info by user ID
+// @Description Fetches user info by user ID (only yourself or your partner)
 // @Tags Users
 // @Produce json
 // @Param id path string true "User ID"
@@ -173,6 +214,18 @@ func GetUser(c *fiber.Ctx) error {
 	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
 	defer cancel()
 
+	// 🔒 Only the user themselves or their linked partner may read the profile. Anyone else
+	// gets the same 404 as for an unknown id, so ids can't be probed.
+	callerId := middleware.UserID(c)
+	if userId != callerId {
+		relationship, ok := currentRelationship(ctx, callerId)
+		if !ok || relationship.OtherMember(callerId) != userId {
+			return c.Status(fiber.StatusNotFound).JSON(fiber.Map{
+				"error": "User not found",
+			})
+		}
+	}
+
 	var user models.User
 	err := collection.FindOne(ctx, bson.M{"id": userId}).Decode(&user)
 	if err != nil {
@@ -180,123 +233,9 @@ func GetUser(c *fiber.Ctx) error {
 			"error": "User not found",
 		})
 	}
+	withPartnerID(ctx, &user)
 
 	user.Password = "" // 🔐 Hide password if any (optional field)
 
 	return c.JSON(user)
 }
-
-func InvitePartner(c *fiber.Ctx) error {
-	type InviteRequest struct {
-		YourID    string `json:"yourId"`
-		PartnerID string `json:"partnerId"`
-	}
-
-	var body InviteRequest
-	if err := c.BodyParser(&body); err != nil || body.YourID == "" || body.PartnerID == "" {
-		return c.Status(400).JSON(fiber.Map{"error": "Invalid invite payload"})
-	}
-
-	collection := database.GetCollection("users")
-	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
-	defer cancel()
-
-	// Fetch inviter and invitee
-	var inviter, invitee models.User
-	if err := collection.FindOne(ctx, fiber.Map{"id": body.YourID}).Decode(&inviter); err != nil {
-		return c.Status(404).JSON(fiber.Map{"error": "Inviter not found"})
-	}
-	if err := collection.FindOne(ctx, fiber.Map{"id": body.PartnerID}).Decode(&invitee); err != nil {
-		return c.Status(404).JSON(fiber.Map{"error": "Invitee not found"})
-	}
-
-	// Update inviter
-	_, err := collection.UpdateOne(ctx,
-		fiber.Map{"id": body.YourID},
-		fiber.Map{"$set": fiber.Map{"partnerId": body.PartnerID}},
-	)
-	if err != nil {
-		return c.Status(500).JSON(fiber.Map{"error": "Failed to update inviter"})
-	}
-
-	// Update invitee
-	_, err = collection.UpdateOne(ctx,
-		fiber.Map{"id": body.PartnerID},
-		fiber.Map{"$set": fiber.Map{
-			"partnerId": body.YourID,
-			"invitedBy": body.YourID,
-		}},
-	)
-	if err != nil {
-		return c.Status(500).JSON(fiber.Map{"error": "Failed to update partner"})
-	}
-
-	// ✅ Send Email to invitee
-	subject := "You’ve Been Invited to Mend 💜"
-	bodyHTML := fmt.Sprintf(`
-		<h2>Hello %s,</h2>
-		<p><strong>%s</strong> has invited you to join them on Mend, a space to improve communication and connection.</p>
-		<p>Your Partner ID: <strong>%s</strong></p>
-		<p>Open the app and enter this Partner ID to link accounts.</p>
-		<br/>
-		<p>With love,<br/>The Mend Team</p>
-	`, invitee.Name, inviter.Name, inviter.ID)
-
-	go utils.SendEmail(invitee.Email, subject, bodyHTML)
-
-	return c.JSON(fiber.Map{"message": "Partners linked and invitation email sent"})
-}
-
-// AcceptInvite godoc
-// @Summary Accept an invitation
-// @Description Accepts a partner invite (both users must already exist)
-// @Tags Users
-// @Accept json
-// @Produce json
-// @Param accept body map[string]string true "Accept info: yourId, partnerId"
-// @Success 200 {object} map[string]string
-// @Failure 400,404,500 {object} map[string]string
-// @Router /api/accept-invite [post]
-func AcceptInvite(c *fiber.Ctx) error {
-	type AcceptRequest struct {
-		YourID    string `json:"yourId"`
-		PartnerID string `json:"partnerId"`
-	}
-
-	var body AcceptRequest
-	if err := c.BodyParser(&body); err != nil || body.YourID == "" || body.PartnerID == "" {
-		return c.Status(400).JSON(fiber.Map{"error": "Invalid payload"})
-	}
-
-	collection := database.GetCollection("users")
-	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
-	defer cancel()
-
-	// Verify both users exist
-	var you, partner models.User
-	err1 := collection.FindOne(ctx, fiber.Map{"id": body.YourID}).Decode(&you)
-	err2 := collection.FindOne(ctx, fiber.Map{"id": body.PartnerID}).Decode(&partner)
-
-	if err1 != nil || err2 != nil {
-		return c.Status(404).JSON(fiber.Map{"error": "One or both users not found"})
-	}
-
-	// Verify that inviter already linked you
-	if partner.PartnerID != body.YourID {
-		return c.Status(400).JSON(fiber.Map{"error": "Invite not initiated by this partner"})
-	}
-
-	// Accept the invite: mutual linkage
-	_, err := collection.UpdateOne(ctx,
-		fiber.Map{"id": body.YourID},
-		fiber.Map{"$set": fiber.Map{
-			"partnerId": body.PartnerID,
-			"invitedBy": body.PartnerID,
-		}},
-	)
-	if err != nil {
-		return c.Status(500).JSON(fiber.Map{"error": "Failed to accept invite"})
-	}
-
-	return c.JSON(fiber.Map{"message": "Invite accepted. You're now linked!"})
-}
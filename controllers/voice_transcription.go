@@ -0,0 +1,154 @@
+package controllers
+
+import (
+	"context"
+	"log"
+	"regexp"
+	"sync"
+	"time"
+
+	"mend/ai"
+	"mend/hub"
+)
+
+const (
+	// A gap this long in a speaker's audio ends the utterance
+	audioSilenceFlush = 1500 * time.Millisecond
+	// Utterances are cut at this size so one long monologue still gets transcribed in pieces
+	maxUtteranceAudio = 4 << 20
+	// Largest binary frame a voice socket may send; recorder chunks are far smaller
+	maxAudioFrame = 1 << 20
+	// Clips waiting for the transcriber per socket before new ones are dropped
+	maxQueuedClips = 8
+)
+
+// audioFormat is what ?audioFormat= may be: a short file extension such as webm, wav or m4a
+var audioFormat = regexp.MustCompile(`^[a-z0-9]{2,5}$`)
+
+// speechToText transcribes voice-session audio; nil when the server doesn't transcribe
+var speechToText ai.Transcriber
+
+// audioClip is one utterance of buffered audio and when it was received
+type audioClip struct {
+	audio      []byte
+	start, end time.Time
+}
+
+// audioBuffer collects one speaker's binary audio frames into utterances and transcribes
+// them in order, one at a time
+type audioBuffer struct {
+	client      *hub.Client
+	connectedAt time.Time
+	format      string
+
+	mu     sync.Mutex
+	buf    []byte
+	start  time.Time
+	last   time.Time
+	idle   *time.Timer
+	closed bool
+	clips  chan audioClip
+}
+
+func newAudioBuffer(client *hub.Client, connectedAt time.Time, format string) *audioBuffer {
+	if !audioFormat.MatchString(format) {
+		format = "webm"
+	}
+	b := &audioBuffer{
+		client:      client,
+		connectedAt: connectedAt,
+		format:      format,
+		clips:       make(chan audioClip, maxQueuedClips),
+	}
+	go b.transcribeLoop()
+	return b
+}
+
+// write adds a chunk of audio to the current utterance
+func (b *audioBuffer) write(data []byte) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	if b.closed || len(data) == 0 {
+		return
+	}
+	now := time.Now()
+	if len(b.buf) == 0 {
+		b.start = now
+	}
+	b.buf = append(b.buf, data...)
+	b.last = now
+
+	if b.idle != nil {
+		b.idle.Stop()
+	}
+	b.idle = time.AfterFunc(audioSilenceFlush, b.flush)
+
+	if len(b.buf) >= maxUtteranceAudio {
+		b.flushLocked()
+	}
+}
+
+// flush ends the current utterance and queues it for transcription
+func (b *audioBuffer) flush() {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	b.flushLocked()
+}
+
+func (b *audioBuffer) flushLocked() {
+	if b.idle != nil {
+		b.idle.Stop()
+		b.idle = nil
+	}
+	if b.closed || len(b.buf) == 0 {
+		return
+	}
+	clip := audioClip{audio: b.buf, start: b.start, end: b.last}
+	b.buf = nil
+
+	select {
+	case b.clips <- clip:
+	default:
+		log.Printf("⚠️ Dropping audio from %s in session %s: transcriber is behind\n", b.client.UserID, b.client.SessionID)
+		sendSocketError(b.client, "transcription_busy", "Some of your audio couldn't be transcribed, please repeat that", "")
+	}
+}
+
+// close transcribes whatever is left and stops taking audio
+func (b *audioBuffer) close() {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	b.flushLocked()
+	b.closed = true
+	close(b.clips)
+}
+
+func (b *audioBuffer) transcribeLoop() {
+	for clip := range b.clips {
+		ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
+		text, err := speechToText.Transcribe(ctx, clip.audio, b.format)
+		cancel()
+		if err != nil {
+			log.Printf("❌ Transcription failed for %s in session %s: %v\n", b.client.UserID, b.client.SessionID, err)
+			sendSocketError(b.client, "transcription_failed", "Couldn't transcribe that, please try again", "")
+			continue
+		}
+		if text == "" {
+			continue // Silence
+		}
+
+		// Timed like a client transcript: milliseconds since the socket connected
+		startMs := clip.start.Sub(b.connectedAt).Milliseconds()
+		endMs := max(clip.end.Sub(b.connectedAt).Milliseconds(), startMs+1)
+		payload := &hub.TranscriptPayload{Text: text, StartMs: &startMs, EndMs: &endMs}
+		if err := payload.Validate(); err != nil {
+			log.Println("❌ Discarding transcription:", err)
+			continue
+		}
+		env, err := hub.NewEnvelope(hub.KindTranscript, b.client.SessionID, b.client.UserID, payload)
+		if err != nil {
+			continue
+		}
+		handleTranscript(b.client, env, payload, b.connectedAt, true)
+	}
+}
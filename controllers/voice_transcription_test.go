@@ -0,0 +1,216 @@
+package controllers
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"reflect"
+	"sync"
+	"testing"
+	"time"
+
+	"mend/hub"
+)
+
+// testConn is a socket that keeps every frame written to it and blocks reads until closed
+type testConn struct {
+	mu        sync.Mutex
+	written   [][]byte
+	closed    chan struct{}
+	closeOnce sync.Once
+}
+
+func newTestConn() *testConn { return &testConn{closed: make(chan struct{})} }
+
+func (c *testConn) ReadMessage() (int, []byte, error) {
+	<-c.closed
+	return 0, nil, errors.New("connection closed")
+}
+func (c *testConn) WriteMessage(_ int, data []byte) error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.written = append(c.written, append([]byte(nil), data...))
+	return nil
+}
+func (c *testConn) WriteControl(int, []byte, time.Time) error { return nil }
+func (c *testConn) SetWriteDeadline(time.Time) error          { return nil }
+func (c *testConn) SetReadDeadline(time.Time) error           { return nil }
+func (c *testConn) SetPongHandler(func(appData string) error) {}
+func (c *testConn) SetReadLimit(int64)                        {}
+func (c *testConn) Close() error                              { c.closeOnce.Do(func() { close(c.closed) }); return nil }
+
+// errorCodes are the codes of the error frames written so far
+func (c *testConn) errorCodes() []string {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	var codes []string
+	for _, frame := range c.written {
+		var env struct {
+			Type    string           `json:"type"`
+			Payload hub.ErrorPayload `json:"payload"`
+		}
+		if json.Unmarshal(frame, &env) == nil && env.Type == hub.KindError {
+			codes = append(codes, env.Payload.Code)
+		}
+	}
+	return codes
+}
+
+// count is how many frames of the kind were written so far
+func (c *testConn) count(kind string) int {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	n := 0
+	for _, frame := range c.written {
+		var env hub.Envelope
+		if json.Unmarshal(frame, &env) == nil && env.Type == kind {
+			n++
+		}
+	}
+	return n
+}
+
+// newTestAudioBuffer is an audio buffer on a connected client, without a transcriber
+// draining its clips
+func newTestAudioBuffer(t *testing.T) (*audioBuffer, *testConn) {
+	t.Helper()
+	h := hub.New(hub.Config{})
+	conn := newTestConn()
+	client := h.Register(conn, "s1", "alice")
+	t.Cleanup(func() {
+		conn.Close()
+		h.Shutdown(context.Background())
+	})
+	return &audioBuffer{client: client, connectedAt: time.Now(), format: "webm", clips: make(chan audioClip, maxQueuedClips)}, conn
+}
+
+// queued takes every clip waiting for the transcriber, without blocking
+func queued(b *audioBuffer) []audioClip {
+	var clips []audioClip
+	for {
+		select {
+		case clip, ok := <-b.clips:
+			if !ok {
+				return clips
+			}
+			clips = append(clips, clip)
+		default:
+			return clips
+		}
+	}
+}
+
+func clipSizes(clips []audioClip) []int {
+	sizes := []int{}
+	for _, clip := range clips {
+		sizes = append(sizes, len(clip.audio))
+	}
+	return sizes
+}
+
+func TestAudioBufferFlushes(t *testing.T) {
+	chunk := func(n int) []byte { return make([]byte, n) }
+
+	tests := []struct {
+		name string
+		run  func(b *audioBuffer)
+		want []int // Sizes of the clips queued for transcription
+	}{
+		{"still speaking", func(b *audioBuffer) {
+			b.write(chunk(1000))
+			b.write(chunk(1000))
+		}, []int{}},
+		{"speaker stops", func(b *audioBuffer) {
+			b.write(chunk(1000))
+			b.write(chunk(500))
+			b.flush()
+		}, []int{1500}},
+		{"stop without audio", func(b *audioBuffer) {
+			b.flush()
+			b.write(nil)
+			b.flush()
+		}, []int{}},
+		{"long monologue is cut at 4 MB", func(b *audioBuffer) {
+			b.write(chunk(3 << 20))
+			b.write(chunk(1 << 20))
+			b.write(chunk(10))
+		}, []int{4 << 20}},
+		{"one utterance per stop", func(b *audioBuffer) {
+			b.write(chunk(10))
+			b.flush()
+			b.write(chunk(20))
+			b.flush()
+		}, []int{10, 20}},
+		{"close transcribes what's left", func(b *audioBuffer) {
+			b.write(chunk(300))
+			b.close()
+			b.write(chunk(300))
+			b.flush()
+		}, []int{300}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			b, _ := newTestAudioBuffer(t)
+			tt.run(b)
+			if got := clipSizes(queued(b)); !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("clips = %v, want %v", got, tt.want)
+			}
+			b.mu.Lock()
+			if b.idle != nil {
+				b.idle.Stop()
+			}
+			b.mu.Unlock()
+		})
+	}
+}
+
+func TestAudioBufferFlushesAfterSilence(t *testing.T) {
+	b, _ := newTestAudioBuffer(t)
+
+	b.write([]byte("hel"))
+	time.Sleep(audioSilenceFlush / 3)
+	b.write([]byte("lo"))
+	if clips := queued(b); len(clips) != 0 {
+		t.Fatalf("a short pause ended the utterance: %v", clipSizes(clips))
+	}
+
+	select {
+	case clip := <-b.clips:
+		if string(clip.audio) != "hello" {
+			t.Errorf("clip = %q, want both frames", clip.audio)
+		}
+		if !clip.end.After(clip.start) {
+			t.Errorf("clip runs from %v to %v", clip.start, clip.end)
+		}
+	case <-time.After(2 * audioSilenceFlush):
+		t.Fatal("silence didn't end the utterance")
+	}
+}
+
+func TestAudioBufferDropsWhenTranscriberIsBehind(t *testing.T) {
+	b, conn := newTestAudioBuffer(t)
+
+	for i := 0; i <= maxQueuedClips; i++ {
+		b.write([]byte{byte(i)})
+		b.flush()
+	}
+	if got := len(queued(b)); got != maxQueuedClips {
+		t.Errorf("%d clips queued, want %d", got, maxQueuedClips)
+	}
+	waitForCondition(t, "the busy error", func() bool {
+		codes := conn.errorCodes()
+		return len(codes) == 1 && codes[0] == "transcription_busy"
+	})
+}
+
+// waitForCondition polls until cond holds or the deadline passes
+func waitForCondition(t *testing.T, what string, cond func() bool) {
+	t.Helper()
+	deadline := time.Now().Add(5 * time.Second)
+	for !cond() {
+		if time.Now().After(deadline) {
+			t.Fatalf("timed out waiting for %s", what)
+		}
+		time.Sleep(5 * time.Millisecond)
+	}
+}
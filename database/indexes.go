@@ -0,0 +1,44 @@
+package database
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+// EnsureIndexes creates the indexes the app relies on for correctness
+func EnsureIndexes() {
+	ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
+	defer cancel()
+
+	// A user can be a member of at most one active relationship. The index is multikey
+	// on `members`, so uniqueness applies to each member ID separately.
+	_, err := GetCollection("relationships").Indexes().CreateOne(ctx, mongo.IndexModel{
+		Keys: bson.D{{Key: "members", Value: 1}},
+		Options: options.Index().
+			SetName("one_active_relationship_per_member").
+			SetUnique(true).
+			SetPartialFilterExpression(bson.M{"status": "active"}),
+	})
+	if err != nil {
+		panic(fmt.Errorf("failed to create relationship index: %w", err))
+	}
+
+	// A couple can have at most one session that hasn't ended or been abandoned
+	_, err = GetCollection("sessions").Indexes().CreateOne(ctx, mongo.IndexModel{
+		Keys: bson.D{{Key: "relationshipId", Value: 1}},
+		Options: options.Index().
+			SetName("one_open_session_per_relationship").
+			SetUnique(true).
+			SetPartialFilterExpression(bson.M{"status": bson.M{"$in": []string{"scheduled", "waiting_for_partner", "active", "paused"}}}),
+	})
+	if err != nil {
+		panic(fmt.Errorf("failed to create session index: %w", err))
+	}
+
+	fmt.Println("Indexes ensured")
+}
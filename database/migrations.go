@@ -0,0 +1,188 @@
+package database
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	"mend/utils"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+// MigrateLegacyPartnerLinks turns the old mirrored `partnerId`/`invitedBy` fields on
+// users into relationship documents and tags the couple's sessions with it.
+// One-sided links (left behind by a half-finished invite) are dropped. Safe to re-run.
+func MigrateLegacyPartnerLinks() {
+	ctx, cancel := context.WithTimeout(context.Background(), 2*time.Minute)
+	defer cancel()
+
+	users := GetCollection("users")
+	relationships := GetCollection("relationships")
+	sessions := GetCollection("sessions")
+
+	cursor, err := users.Find(ctx, bson.M{"partnerId": bson.M{"$exists": true, "$ne": ""}})
+	if err != nil {
+		fmt.Println("❌ Partner link migration failed:", err)
+		return
+	}
+
+	var linked []struct {
+		ID        string    `bson:"id"`
+		PartnerID string    `bson:"partnerId"`
+		InvitedBy string    `bson:"invitedBy"`
+		CreatedAt time.Time `bson:"createdAt"`
+	}
+	if err := cursor.All(ctx, &linked); err != nil {
+		fmt.Println("❌ Partner link migration failed:", err)
+		return
+	}
+
+	partnerOf := make(map[string]string, len(linked))
+	for _, u := range linked {
+		partnerOf[u.ID] = u.PartnerID
+	}
+
+	migrated := 0
+	for _, u := range linked {
+		// Each mutual pair is handled once, from the member with the smaller ID
+		if partnerOf[u.PartnerID] != u.ID || u.ID > u.PartnerID {
+			continue
+		}
+
+		count, err := relationships.CountDocuments(ctx, bson.M{
+			"members": bson.M{"$in": []string{u.ID, u.PartnerID}},
+			"status":  "active",
+		})
+		if err != nil || count > 0 {
+			continue
+		}
+
+		initiatedBy := u.InvitedBy
+		if initiatedBy == "" {
+			initiatedBy = u.PartnerID
+		}
+		relationshipId := utils.GeneratePartnerID()
+		_, err = relationships.InsertOne(ctx, bson.M{
+			"_id":                  relationshipId,
+			"members":              []string{u.ID, u.PartnerID},
+			"initiatedBy":          initiatedBy,
+			"status":               "active",
+			"startedAt":            u.CreatedAt.Unix(),
+			"settings":             bson.M{"aiInterjections": true, "coolingOffSeconds": 0},
+			"transcriptVisibility": bson.M{u.ID: true, u.PartnerID: true},
+		})
+		if err != nil {
+			fmt.Println("❌ Failed to migrate partner link for", u.ID, err)
+			continue
+		}
+
+		_, _ = sessions.UpdateMany(ctx,
+			bson.M{
+				"relationshipId": bson.M{"$exists": false},
+				"$or": []bson.M{
+					{"partnerA": u.ID, "partnerB": u.PartnerID},
+					{"partnerA": u.PartnerID, "partnerB": u.ID},
+				},
+			},
+			bson.M{"$set": bson.M{"relationshipId": relationshipId}},
+		)
+		migrated++
+	}
+
+	if _, err := users.UpdateMany(ctx,
+		bson.M{"$or": []bson.M{{"partnerId": bson.M{"$exists": true}}, {"invitedBy": bson.M{"$exists": true}}}},
+		bson.M{"$unset": bson.M{"partnerId": "", "invitedBy": ""}},
+	); err != nil {
+		fmt.Println("❌ Failed to clear legacy partner fields:", err)
+		return
+	}
+
+	if migrated > 0 {
+		fmt.Println("✅ Migrated", migrated, "legacy partner links to relationships")
+	}
+}
+
+// MigrateSessionStatus replaces the old `resolved` flag on sessions with a status.
+// Resolved sessions become ended. Of a couple's unresolved sessions only the newest stays
+// active; older duplicates and sessions without a relationship are abandoned. Safe to re-run.
+func MigrateSessionStatus() {
+	ctx, cancel := context.WithTimeout(context.Background(), 2*time.Minute)
+	defer cancel()
+
+	sessions := GetCollection("sessions")
+
+	if _, err := sessions.UpdateMany(ctx,
+		bson.M{"status": bson.M{"$exists": false}, "resolved": true},
+		bson.M{"$set": bson.M{"status": "ended"}},
+	); err != nil {
+		fmt.Println("❌ Session status migration failed:", err)
+		return
+	}
+
+	cursor, err := sessions.Find(ctx,
+		bson.M{"status": bson.M{"$exists": false}},
+		options.Find().SetSort(bson.M{"createdAt": -1}),
+	)
+	if err != nil {
+		fmt.Println("❌ Session status migration failed:", err)
+		return
+	}
+	var open []struct {
+		ID             string `bson:"_id"`
+		PartnerA       string `bson:"partnerA"`
+		PartnerB       string `bson:"partnerB"`
+		RelationshipID string `bson:"relationshipId"`
+	}
+	if err := cursor.All(ctx, &open); err != nil {
+		fmt.Println("❌ Session status migration failed:", err)
+		return
+	}
+
+	now := time.Now().Unix()
+	kept := make(map[string]bool)
+	for _, s := range open {
+		set := bson.M{"status": "abandoned", "endedAt": now}
+		if s.RelationshipID != "" && !kept[s.RelationshipID] {
+			kept[s.RelationshipID] = true
+			set = bson.M{"status": "active", "joined": []string{s.PartnerA, s.PartnerB}}
+		}
+		if _, err := sessions.UpdateOne(ctx, bson.M{"_id": s.ID}, bson.M{"$set": set}); err != nil {
+			fmt.Println("❌ Failed to migrate session", s.ID, err)
+		}
+	}
+
+	if _, err := sessions.UpdateMany(ctx,
+		bson.M{"resolved": bson.M{"$exists": true}},
+		bson.M{"$unset": bson.M{"resolved": ""}},
+	); err != nil {
+		fmt.Println("❌ Failed to clear legacy resolved flags:", err)
+		return
+	}
+
+	if len(open) > 0 {
+		fmt.Println("✅ Migrated", len(open), "unresolved sessions to statuses")
+	}
+}
+
+// MigrateEmailVerified marks accounts created before email verification existed as
+// verified, so they aren't locked out of inviting a partner. Accounts registered since
+// always store the field, so only older ones are touched. Safe to re-run.
+func MigrateEmailVerified() {
+	ctx, cancel := context.WithTimeout(context.Background(), 2*time.Minute)
+	defer cancel()
+
+	result, err := GetCollection("users").UpdateMany(ctx,
+		bson.M{"emailVerified": bson.M{"$exists": false}},
+		bson.M{"$set": bson.M{"emailVerified": true}},
+	)
+	if err != nil {
+		fmt.Println("❌ Email verification migration failed:", err)
+		return
+	}
+
+	if result.ModifiedCount > 0 {
+		fmt.Println("✅ Marked", result.ModifiedCount, "existing accounts as email-verified")
+	}
+}
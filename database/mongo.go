@@ -36,7 +36,34 @@ func ConnectDB() {
 	DB = client
 }
 
+// DisconnectDB closes the Mongo client, waiting for in-flight operations up to ctx's deadline
+func DisconnectDB(ctx context.Context) {
+	if DB == nil {
+		return
+	}
+	if err := DB.Disconnect(ctx); err != nil {
+		fmt.Println("❌ Failed to disconnect from MongoDB:", err)
+		return
+	}
+	fmt.Println("Disconnected from MongoDB")
+}
+
 // GetCollection returns a Mongo collection from the Mend DB
 func GetCollection(collectionName string) *mongo.Collection {
 	return DB.Database("mend").Collection(collectionName)
 }
+
+// WithTransaction runs fn inside a multi-document transaction.
+// Transactions need a replica set (Atlas clusters are one).
+func WithTransaction(ctx context.Context, fn func(sc mongo.SessionContext) error) error {
+	session, err := DB.StartSession()
+	if err != nil {
+		return err
+	}
+	defer session.EndSession(ctx)
+
+	_, err = session.WithTransaction(ctx, func(sc mongo.SessionContext) (interface{}, error) {
+		return nil, fn(sc)
+	})
+	return err
+}
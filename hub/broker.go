@@ -0,0 +1,83 @@
+package hub
+
+import (
+	"context"
+	"sync"
+)
+
+// Broker fans session traffic out to every server instance that has clients in the session.
+// The hub publishes each broadcast and delivers what its subscriptions receive to its local
+// clients, so partners connected to different replicas still hear each other. Messages
+// published to one session must reach every subscriber in the same order.
+type Broker interface {
+	// Publish sends msg to every subscriber of the session, this instance included, and
+	// returns how many subscribers it reached
+	Publish(ctx context.Context, sessionId string, msg []byte) (receivers int, err error)
+	// Subscribe calls handler, one message at a time, for everything published to the
+	// session until the returned unsubscribe is called. Once it returns, the subscription
+	// counts as a receiver of anything published.
+	Subscribe(ctx context.Context, sessionId string, handler func(msg []byte)) (unsubscribe func(), err error)
+	Close() error
+}
+
+// memoryBroker is the in-process Broker used when there's a single instance
+type memoryBroker struct {
+	mu       sync.RWMutex
+	sessions map[string]*memoryTopic
+}
+
+type memoryTopic struct {
+	mu       sync.Mutex // Serializes delivery so every subscriber sees the same order
+	handlers map[int]func(msg []byte)
+	nextId   int
+}
+
+// NewMemoryBroker returns a Broker that only reaches this process
+func NewMemoryBroker() Broker {
+	return &memoryBroker{sessions: make(map[string]*memoryTopic)}
+}
+
+func (b *memoryBroker) Publish(_ context.Context, sessionId string, msg []byte) (int, error) {
+	b.mu.RLock()
+	topic := b.sessions[sessionId]
+	b.mu.RUnlock()
+	if topic == nil {
+		return 0, nil
+	}
+
+	topic.mu.Lock()
+	defer topic.mu.Unlock()
+	for _, handler := range topic.handlers {
+		handler(msg)
+	}
+	return len(topic.handlers), nil
+}
+
+func (b *memoryBroker) Subscribe(_ context.Context, sessionId string, handler func(msg []byte)) (func(), error) {
+	b.mu.Lock()
+	topic := b.sessions[sessionId]
+	if topic == nil {
+		topic = &memoryTopic{handlers: make(map[int]func(msg []byte))}
+		b.sessions[sessionId] = topic
+	}
+	b.mu.Unlock()
+
+	topic.mu.Lock()
+	id := topic.nextId
+	topic.nextId++
+	topic.handlers[id] = handler
+	topic.mu.Unlock()
+
+	return func() {
+		b.mu.Lock()
+		defer b.mu.Unlock()
+		topic.mu.Lock()
+		defer topic.mu.Unlock()
+		delete(topic.handlers, id)
+		if len(topic.handlers) == 0 && b.sessions[sessionId] == topic {
+			delete(b.sessions, sessionId)
+		}
+	}, nil
+}
+
+func (b *memoryBroker) Close() error { return nil }
@@ -0,0 +1,170 @@
+package hub
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"reflect"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/redis/go-redis/v9"
+)
+
+// recorder collects what a subscription receives
+type recorder struct {
+	mu   sync.Mutex
+	msgs []string
+}
+
+func (r *recorder) handle(msg []byte) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.msgs = append(r.msgs, string(msg))
+}
+
+func (r *recorder) received() []string {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	return append([]string(nil), r.msgs...)
+}
+
+// waitFor polls until cond holds or the deadline passes
+func waitFor(t *testing.T, what string, cond func() bool) {
+	t.Helper()
+	deadline := time.Now().Add(5 * time.Second)
+	for !cond() {
+		if time.Now().After(deadline) {
+			t.Fatalf("timed out waiting for %s", what)
+		}
+		time.Sleep(5 * time.Millisecond)
+	}
+}
+
+// testBrokerOrdering publishes from several goroutines on two instances' brokers and checks
+// that both instances' subscribers see every message in the same order
+func testBrokerOrdering(t *testing.T, a, b Broker, sessionId string) {
+	ctx := context.Background()
+	var onA, onB recorder
+	unsubA, err := a.Subscribe(ctx, sessionId, onA.handle)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer unsubA()
+	unsubB, err := b.Subscribe(ctx, sessionId, onB.handle)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer unsubB()
+
+	const publishers, each = 4, 50
+	var wg sync.WaitGroup
+	for p := 0; p < publishers; p++ {
+		broker := a
+		if p%2 == 1 {
+			broker = b
+		}
+		wg.Add(1)
+		go func(p int) {
+			defer wg.Done()
+			for i := 0; i < each; i++ {
+				if _, err := broker.Publish(ctx, sessionId, []byte(fmt.Sprintf("%d-%d", p, i))); err != nil {
+					t.Error(err)
+				}
+			}
+		}(p)
+	}
+	wg.Wait()
+
+	total := publishers * each
+	waitFor(t, "every message on both subscribers", func() bool {
+		return len(onA.received()) == total && len(onB.received()) == total
+	})
+	if !reflect.DeepEqual(onA.received(), onB.received()) {
+		t.Fatal("subscribers saw the session's messages in different orders")
+	}
+
+	// Each publisher's own messages keep the order they were sent in
+	next := make(map[string]int)
+	for _, msg := range onA.received() {
+		var p, i int
+		fmt.Sscanf(msg, "%d-%d", &p, &i)
+		key := fmt.Sprint(p)
+		if i != next[key] {
+			t.Fatalf("publisher %d's message %d arrived when %d was expected", p, i, next[key])
+		}
+		next[key]++
+	}
+}
+
+func TestMemoryBrokerOrdering(t *testing.T) {
+	broker := NewMemoryBroker()
+	testBrokerOrdering(t, broker, broker, "s1")
+}
+
+func TestMemoryBrokerSubscriptions(t *testing.T) {
+	ctx := context.Background()
+	broker := NewMemoryBroker()
+
+	if n, err := broker.Publish(ctx, "s1", []byte("nobody")); err != nil || n != 0 {
+		t.Fatalf("Publish without subscribers = (%d, %v), want (0, nil)", n, err)
+	}
+
+	var first, second, other recorder
+	unsubFirst, _ := broker.Subscribe(ctx, "s1", first.handle)
+	unsubSecond, _ := broker.Subscribe(ctx, "s1", second.handle)
+	unsubOther, _ := broker.Subscribe(ctx, "s2", other.handle)
+	defer unsubOther()
+
+	if n, _ := broker.Publish(ctx, "s1", []byte("both")); n != 2 {
+		t.Errorf("Publish reached %d subscribers, want 2", n)
+	}
+	unsubFirst()
+	if n, _ := broker.Publish(ctx, "s1", []byte("second only")); n != 1 {
+		t.Errorf("Publish after unsubscribe reached %d subscribers, want 1", n)
+	}
+	unsubSecond()
+
+	if got := first.received(); !reflect.DeepEqual(got, []string{"both"}) {
+		t.Errorf("first subscriber got %q", got)
+	}
+	if got := second.received(); !reflect.DeepEqual(got, []string{"both", "second only"}) {
+		t.Errorf("second subscriber got %q", got)
+	}
+	if got := other.received(); len(got) != 0 {
+		t.Errorf("another session's subscriber got %q", got)
+	}
+}
+
+// TestRedisBrokerOrdering runs against a real Redis: REDIS_TEST_URL=redis://localhost:6379/15
+func TestRedisBrokerOrdering(t *testing.T) {
+	url := os.Getenv("REDIS_TEST_URL")
+	if url == "" {
+		t.Skip("REDIS_TEST_URL not set")
+	}
+	opts, err := redis.ParseURL(url)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// Two brokers on separate connections stand in for two instances
+	a := NewRedisBroker(redis.NewClient(opts))
+	defer a.Close()
+	b := NewRedisBroker(redis.NewClient(opts))
+	defer b.Close()
+
+	sessionId := fmt.Sprintf("test-%d", time.Now().UnixNano())
+	testBrokerOrdering(t, a, b, sessionId)
+
+	// A confirmed subscription is counted by the very next publish
+	var late recorder
+	unsubscribe, err := b.Subscribe(context.Background(), sessionId+"-late", late.handle)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer unsubscribe()
+	if n, err := a.Publish(context.Background(), sessionId+"-late", []byte("hi")); err != nil || n != 1 {
+		t.Fatalf("Publish right after Subscribe = (%d, %v), want (1, nil)", n, err)
+	}
+}
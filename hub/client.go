@@ -0,0 +1,257 @@
+package hub
+
+import (
+	"encoding/json"
+	"errors"
+	"log"
+	"net"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/gofiber/websocket/v2"
+)
+
+// Conn is the part of a WebSocket connection the hub needs
+type Conn interface {
+	ReadMessage() (messageType int, p []byte, err error)
+	WriteMessage(messageType int, data []byte) error
+	WriteControl(messageType int, data []byte, deadline time.Time) error
+	SetWriteDeadline(t time.Time) error
+	SetReadDeadline(t time.Time) error
+	SetPongHandler(h func(appData string) error)
+	SetReadLimit(limit int64)
+	Close() error
+}
+
+// Close codes the server uses, sent with a short reason in the close frame. Clients should
+// reconnect after CloseGoingAway, CloseHeartbeatTimeout and CloseSlowConsumer, and not after
+// CloseSessionEnded.
+const (
+	CloseNormal           = websocket.CloseNormalClosure // 1000
+	CloseGoingAway        = websocket.CloseGoingAway     // 1001: server shutting down
+	CloseSessionEnded     = 4000                         // The session ended or was abandoned
+	CloseIdle             = 4001                         // No frames from the client for too long
+	CloseSlowConsumer     = 4002                         // The client couldn't keep up with its send queue
+	CloseHeartbeatTimeout = 4003                         // No pong (or anything else) in time
+)
+
+// Client is one socket in a session room. All writes go through its send queue and
+// a single writer goroutine, so handlers never write to the connection directly.
+type Client struct {
+	id        string // Unique per connection, so broadcasts can skip the sender on any instance
+	UserID    string
+	SessionID string
+
+	hub       *Hub
+	conn      Conn
+	send      chan []byte
+	done      chan struct{}
+	closeOnce sync.Once
+	writerWG  sync.WaitGroup
+
+	onBinary     func(c *Client, data []byte)
+	readLimit    int64
+	closeCode    int
+	closeReason  string
+	lastActivity atomic.Int64 // Unix nanoseconds of the last application frame read
+
+	holdMu  sync.Mutex
+	holding bool
+	held    []heldFrame
+}
+
+// ID is unique per connection, on every instance
+func (c *Client) ID() string { return c.id }
+
+// heldFrame is live traffic queued while the client catches up on missed messages.
+// seq is the frame's message sequence number, 0 for unsequenced events.
+type heldFrame struct {
+	msg []byte
+	seq int64
+}
+
+// Release sends the live traffic held since RegisterHeld, dropping sequenced frames the client
+// already has (seq <= upTo), and goes back to sending live traffic directly.
+func (c *Client) Release(upTo int64) {
+	c.holdMu.Lock()
+	defer c.holdMu.Unlock()
+	for _, f := range c.held {
+		if f.seq == 0 || f.seq > upTo {
+			c.sendRaw(f.msg)
+		}
+	}
+	c.held = nil
+	c.holding = false
+}
+
+// deliver is the live-traffic path (Broadcast, SendToUser); Send always goes out directly
+func (c *Client) deliver(msg []byte, seq int64) {
+	c.holdMu.Lock()
+	defer c.holdMu.Unlock()
+	if !c.holding {
+		c.sendRaw(msg)
+		return
+	}
+	if len(c.held) >= c.hub.cfg.SendBuffer*4 {
+		log.Printf("🐢 Evicting socket client %s in session %s: too far behind while catching up\n", c.UserID, c.SessionID)
+		c.hub.remove(c)
+		c.CloseWith(CloseSlowConsumer, "too far behind")
+		return
+	}
+	c.held = append(c.held, heldFrame{msg: msg, seq: seq})
+}
+
+// Send queues an envelope for this client. A client whose queue is full is too slow to
+// keep up and gets evicted rather than holding up everyone else.
+func (c *Client) Send(env Envelope) bool {
+	msg, err := json.Marshal(env)
+	if err != nil {
+		log.Println("❌ Failed to encode socket frame:", err)
+		return false
+	}
+	return c.sendRaw(msg)
+}
+
+func (c *Client) sendRaw(msg []byte) bool {
+	select {
+	case <-c.done:
+		return false
+	default:
+	}
+
+	select {
+	case c.send <- msg:
+		return true
+	case <-c.done:
+		return false
+	default:
+		log.Printf("🐢 Evicting slow socket client %s in session %s\n", c.UserID, c.SessionID)
+		c.hub.remove(c)
+		c.CloseWith(CloseSlowConsumer, "send queue full")
+		return false
+	}
+}
+
+// HandleBinary routes binary frames (e.g. audio) to fn instead of onMessage. Call it before Run.
+func (c *Client) HandleBinary(fn func(c *Client, data []byte)) {
+	c.onBinary = fn
+}
+
+// SetReadLimit changes the largest frame this client may send, e.g. to let audio frames
+// through. Call it before Run.
+func (c *Client) SetReadLimit(limit int64) {
+	c.readLimit = limit
+}
+
+// Run reads frames until the connection closes, handing each one to onMessage.
+// It blocks (as the WebSocket handler must) and unregisters the client when done.
+// Any frame or pong keeps the connection alive; only frames count against the idle timeout.
+func (c *Client) Run(onMessage func(c *Client, msg []byte)) {
+	defer func() {
+		c.hub.remove(c)
+		c.Close()
+		c.writerWG.Wait()
+	}()
+
+	// 📏 A bigger frame closes the connection with 1009 before it is buffered
+	c.conn.SetReadLimit(c.readLimit)
+	c.lastActivity.Store(time.Now().UnixNano())
+	c.conn.SetReadDeadline(time.Now().Add(c.hub.cfg.PongTimeout))
+	c.conn.SetPongHandler(func(string) error {
+		return c.conn.SetReadDeadline(time.Now().Add(c.hub.cfg.PongTimeout))
+	})
+
+	for {
+		messageType, msg, err := c.conn.ReadMessage()
+		if err != nil {
+			var netErr net.Error
+			if errors.As(err, &netErr) && netErr.Timeout() {
+				c.CloseWith(CloseHeartbeatTimeout, "heartbeat timeout")
+			}
+			return
+		}
+		c.lastActivity.Store(time.Now().UnixNano())
+		c.conn.SetReadDeadline(time.Now().Add(c.hub.cfg.PongTimeout))
+		if messageType == websocket.BinaryMessage && c.onBinary != nil {
+			c.onBinary(c, msg)
+			continue
+		}
+		onMessage(c, msg)
+	}
+}
+
+// Close closes the connection normally. Safe to call more than once.
+func (c *Client) Close() {
+	c.CloseWith(CloseNormal, "")
+}
+
+// CloseWith stops accepting frames for the client; the writer sends what is already
+// queued, then a close frame with the code and reason, then closes the connection.
+// Only the first close counts.
+func (c *Client) CloseWith(code int, reason string) {
+	c.closeOnce.Do(func() {
+		c.closeCode = code
+		c.closeReason = reason
+		close(c.done)
+	})
+}
+
+func (c *Client) writeLoop() {
+	defer c.writerWG.Done()
+	defer c.conn.Close()
+
+	ping := time.NewTicker(c.hub.cfg.PingInterval)
+	defer ping.Stop()
+
+	for {
+		select {
+		case msg := <-c.send:
+			if !c.write(msg) {
+				return
+			}
+		case <-ping.C:
+			idle := time.Since(time.Unix(0, c.lastActivity.Load()))
+			if c.lastActivity.Load() != 0 && idle > c.hub.cfg.IdleTimeout {
+				c.hub.remove(c)
+				c.CloseWith(CloseIdle, "idle timeout")
+				continue
+			}
+			if err := c.conn.WriteControl(websocket.PingMessage, nil, time.Now().Add(c.hub.cfg.WriteTimeout)); err != nil {
+				c.hub.remove(c)
+				c.CloseWith(CloseHeartbeatTimeout, "ping failed")
+				return
+			}
+		case <-c.done:
+			c.flush()
+			return
+		}
+	}
+}
+
+// flush writes whatever is still queued, then the close frame
+func (c *Client) flush() {
+	for {
+		select {
+		case msg := <-c.send:
+			if !c.write(msg) {
+				return
+			}
+		default:
+			frame := websocket.FormatCloseMessage(c.closeCode, c.closeReason)
+			c.conn.WriteControl(websocket.CloseMessage, frame, time.Now().Add(c.hub.cfg.WriteTimeout))
+			return
+		}
+	}
+}
+
+func (c *Client) write(msg []byte) bool {
+	c.conn.SetWriteDeadline(time.Now().Add(c.hub.cfg.WriteTimeout))
+	if err := c.conn.WriteMessage(websocket.TextMessage, msg); err != nil {
+		log.Printf("❌ Socket write to %s failed: %v\n", c.UserID, err)
+		c.hub.remove(c)
+		c.CloseWith(CloseNormal, "")
+		return false
+	}
+	return true
+}
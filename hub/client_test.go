@@ -0,0 +1,43 @@
+package hub
+
+import (
+	"context"
+	"testing"
+)
+
+func TestClientReadLimit(t *testing.T) {
+	tests := []struct {
+		name   string
+		config int64 // Config.ReadLimit
+		client int64 // Client.SetReadLimit, 0 for none
+		want   int64
+	}{
+		{"default fits the largest SDP", 0, 0, 2 * maxSDPLength},
+		{"configured", 4096, 0, 4096},
+		{"raised for one client", 4096, 1 << 20, 1 << 20},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			h := New(Config{ReadLimit: tt.config})
+			defer h.Shutdown(context.Background())
+
+			conn := newFakeConn()
+			client := h.Register(conn, "s1", "alice")
+			if tt.client > 0 {
+				client.SetReadLimit(tt.client)
+			}
+			done := make(chan struct{})
+			go func() {
+				client.Run(func(*Client, []byte) {})
+				close(done)
+			}()
+			waitFor(t, "the read limit", func() bool { return conn.readLimit.Load() != 0 })
+			conn.Close()
+			<-done
+
+			if got := conn.readLimit.Load(); got != tt.want {
+				t.Errorf("read limit = %d, want %d", got, tt.want)
+			}
+		})
+	}
+}
@@ -0,0 +1,128 @@
+package hub
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/google/uuid"
+)
+
+// EnvelopeVersion is the frame format version spoken by every socket endpoint
+const EnvelopeVersion = 1
+
+// Envelope wraps every frame sent or received over a session socket
+type Envelope struct {
+	Type      string          `json:"type"`    // One of the registered kinds
+	Version   int             `json:"version"` // EnvelopeVersion
+	ID        string          `json:"id"`      // Unique per frame; clients generate their own
+	SessionID string          `json:"sessionId,omitempty"`
+	From      string          `json:"from,omitempty"` // Sender's user ID, "AI" for the moderator, empty for server events
+	SentAt    int64           `json:"sentAt"`         // Unix milliseconds
+	Seq       int64           `json:"seq,omitempty"`  // Per-session sequence number of persisted messages
+	Payload   json.RawMessage `json:"payload,omitempty"`
+}
+
+// NewEnvelope builds a current-version envelope around payload
+func NewEnvelope(msgType, sessionId, from string, payload interface{}) (Envelope, error) {
+	env := Envelope{
+		Type:      msgType,
+		Version:   EnvelopeVersion,
+		ID:        uuid.NewString(),
+		SessionID: sessionId,
+		From:      from,
+		SentAt:    time.Now().UnixMilli(),
+	}
+	if _, ok := registry[msgType]; !ok {
+		return env, fmt.Errorf("unregistered message kind %q", msgType)
+	}
+	if payload != nil {
+		raw, err := json.Marshal(payload)
+		if err != nil {
+			return env, err
+		}
+		env.Payload = raw
+	}
+	return env, nil
+}
+
+// Error codes sent back in error frames
+const (
+	CodeBadFrame           = "bad_frame"
+	CodeUnsupportedVersion = "unsupported_version"
+	CodeUnknownType        = "unknown_type"
+	CodeNotAllowed         = "not_allowed"
+	CodeInvalidPayload     = "invalid_payload"
+)
+
+// DecodeError is why a client frame was rejected
+type DecodeError struct {
+	Code    string
+	Message string
+	RefID   string // ID of the rejected frame, when it could be read
+}
+
+func (e *DecodeError) Error() string {
+	return e.Code + ": " + e.Message
+}
+
+// Decode strictly parses a client frame: unknown fields, other versions, server-only kinds,
+// kinds the endpoint doesn't accept and invalid payloads are all rejected with a DecodeError.
+func Decode(raw []byte, accept ...string) (Envelope, Payload, error) {
+	var env Envelope
+	if err := strictUnmarshal(raw, &env); err != nil {
+		return env, nil, &DecodeError{Code: CodeBadFrame, Message: err.Error()}
+	}
+	reject := func(code, message string) (Envelope, Payload, error) {
+		return env, nil, &DecodeError{Code: code, Message: message, RefID: env.ID}
+	}
+
+	if env.Version != EnvelopeVersion {
+		return reject(CodeUnsupportedVersion, fmt.Sprintf("version %d is not supported, use %d", env.Version, EnvelopeVersion))
+	}
+	if env.ID == "" {
+		return reject(CodeBadFrame, "id is required")
+	}
+	kind, ok := registry[env.Type]
+	if !ok {
+		return reject(CodeUnknownType, fmt.Sprintf("unknown message type %q", env.Type))
+	}
+	if !kind.FromClient || !accepts(accept, env.Type) {
+		return reject(CodeNotAllowed, fmt.Sprintf("%q frames can't be sent on this socket", env.Type))
+	}
+
+	payload := kind.New()
+	if len(env.Payload) == 0 {
+		return reject(CodeInvalidPayload, "payload is required")
+	}
+	if err := strictUnmarshal(env.Payload, payload); err != nil {
+		return reject(CodeInvalidPayload, err.Error())
+	}
+	if err := payload.Validate(); err != nil {
+		return reject(CodeInvalidPayload, err.Error())
+	}
+	return env, payload, nil
+}
+
+func accepts(accept []string, msgType string) bool {
+	for _, t := range accept {
+		if t == msgType {
+			return true
+		}
+	}
+	return false
+}
+
+func strictUnmarshal(raw []byte, v interface{}) error {
+	dec := json.NewDecoder(bytes.NewReader(raw))
+	dec.DisallowUnknownFields()
+	if err := dec.Decode(v); err != nil {
+		return err
+	}
+	if dec.More() {
+		return errors.New("unexpected data after JSON value")
+	}
+	return nil
+}
@@ -0,0 +1,166 @@
+package hub
+
+import (
+	"encoding/json"
+	"errors"
+	"strings"
+	"testing"
+)
+
+// frame builds a raw client frame around a JSON payload
+func frame(msgType, payload string) string {
+	return `{"type":"` + msgType + `","version":1,"id":"f1","sentAt":1,"payload":` + payload + `}`
+}
+
+func TestDecode(t *testing.T) {
+	chatAndSignal := []string{KindChat, KindTyping, KindAck, KindSignal, KindTranscript, KindControl}
+	longText := `"` + strings.Repeat("a", maxTextLength+1) + `"`
+	longSDP := `"` + strings.Repeat("v", maxSDPLength+1) + `"`
+
+	tests := []struct {
+		name     string
+		raw      string
+		accept   []string
+		wantCode string // Empty for a frame that decodes
+		wantRef  string
+	}{
+		{"chat", frame(KindChat, `{"text":"hi"}`), chatAndSignal, "", ""},
+		{"transcript with timing", frame(KindTranscript, `{"text":"hi","startMs":0,"endMs":10}`), chatAndSignal, "", ""},
+		{"typing", frame(KindTyping, `{"typing":true}`), chatAndSignal, "", ""},
+		{"ack", frame(KindAck, `{"seq":3}`), chatAndSignal, "", ""},
+		{"pause", frame(KindControl, `{"action":"pause","seconds":60}`), chatAndSignal, "", ""},
+		{"offer", frame(KindSignal, `{"type":"offer","sdp":"v=0"}`), chatAndSignal, "", ""},
+		{"end of candidates", frame(KindSignal, `{"type":"candidate","candidate":{"candidate":""}}`), chatAndSignal, "", ""},
+		{"hangup", frame(KindSignal, `{"type":"hangup"}`), chatAndSignal, "", ""},
+
+		{"not JSON", `{"type":`, chatAndSignal, CodeBadFrame, ""},
+		{"unknown envelope field", `{"type":"chat","version":1,"id":"f1","extra":1,"payload":{"text":"hi"}}`, chatAndSignal, CodeBadFrame, ""},
+		{"trailing data", frame(KindChat, `{"text":"hi"}`) + `{}`, chatAndSignal, CodeBadFrame, ""},
+		{"old version", `{"type":"chat","version":0,"id":"f1","payload":{"text":"hi"}}`, chatAndSignal, CodeUnsupportedVersion, "f1"},
+		{"future version", `{"type":"chat","version":2,"id":"f1","payload":{"text":"hi"}}`, chatAndSignal, CodeUnsupportedVersion, "f1"},
+		{"missing id", `{"type":"chat","version":1,"payload":{"text":"hi"}}`, chatAndSignal, CodeBadFrame, ""},
+		{"unknown type", frame("shout", `{"text":"hi"}`), chatAndSignal, CodeUnknownType, "f1"},
+		{"server-only kind", frame(KindAIReply, `{"text":"hi"}`), append(chatAndSignal, KindAIReply), CodeNotAllowed, "f1"},
+		{"kind not accepted here", frame(KindSignal, `{"type":"hangup"}`), []string{KindChat}, CodeNotAllowed, "f1"},
+		{"missing payload", `{"type":"chat","version":1,"id":"f1"}`, chatAndSignal, CodeInvalidPayload, "f1"},
+		{"unknown payload field", frame(KindChat, `{"text":"hi","bold":true}`), chatAndSignal, CodeInvalidPayload, "f1"},
+		{"wrong payload type", frame(KindChat, `{"text":42}`), chatAndSignal, CodeInvalidPayload, "f1"},
+		{"empty text", frame(KindChat, `{"text":""}`), chatAndSignal, CodeInvalidPayload, "f1"},
+		{"text too long", frame(KindChat, `{"text":`+longText+`}`), chatAndSignal, CodeInvalidPayload, "f1"},
+		{"startMs without endMs", frame(KindTranscript, `{"text":"hi","startMs":5}`), chatAndSignal, CodeInvalidPayload, "f1"},
+		{"endMs before startMs", frame(KindTranscript, `{"text":"hi","startMs":10,"endMs":10}`), chatAndSignal, CodeInvalidPayload, "f1"},
+		{"negative startMs", frame(KindTranscript, `{"text":"hi","startMs":-1,"endMs":10}`), chatAndSignal, CodeInvalidPayload, "f1"},
+		{"unknown control action", frame(KindControl, `{"action":"session_paused"}`), chatAndSignal, CodeInvalidPayload, "f1"},
+		{"negative pause", frame(KindControl, `{"action":"pause","seconds":-5}`), chatAndSignal, CodeInvalidPayload, "f1"},
+		{"negative ack", frame(KindAck, `{"seq":-1}`), chatAndSignal, CodeInvalidPayload, "f1"},
+		{"offer without sdp", frame(KindSignal, `{"type":"offer"}`), chatAndSignal, CodeInvalidPayload, "f1"},
+		{"sdp too long", frame(KindSignal, `{"type":"answer","sdp":`+longSDP+`}`), chatAndSignal, CodeInvalidPayload, "f1"},
+		{"candidate missing", frame(KindSignal, `{"type":"candidate"}`), chatAndSignal, CodeInvalidPayload, "f1"},
+		{"unknown signal", frame(KindSignal, `{"type":"rollback"}`), chatAndSignal, CodeInvalidPayload, "f1"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			env, payload, err := Decode([]byte(tt.raw), tt.accept...)
+			if tt.wantCode == "" {
+				if err != nil {
+					t.Fatalf("Decode: %v", err)
+				}
+				if payload == nil || env.ID != "f1" {
+					t.Fatalf("Decode returned env %+v, payload %v", env, payload)
+				}
+				return
+			}
+
+			var decodeErr *DecodeError
+			if !errors.As(err, &decodeErr) {
+				t.Fatalf("Decode error = %v, want a *DecodeError", err)
+			}
+			if decodeErr.Code != tt.wantCode {
+				t.Errorf("code = %q (%s), want %q", decodeErr.Code, decodeErr.Message, tt.wantCode)
+			}
+			if decodeErr.RefID != tt.wantRef {
+				t.Errorf("refId = %q, want %q", decodeErr.RefID, tt.wantRef)
+			}
+			if payload != nil {
+				t.Errorf("rejected frame returned payload %v", payload)
+			}
+		})
+	}
+}
+
+func TestDecodePayloadTypes(t *testing.T) {
+	_, payload, err := Decode([]byte(frame(KindChat, `{"text":"hello"}`)), KindChat)
+	if err != nil {
+		t.Fatal(err)
+	}
+	chat, ok := payload.(*ChatPayload)
+	if !ok || chat.Text != "hello" {
+		t.Fatalf("payload = %#v, want *ChatPayload{hello}", payload)
+	}
+
+	_, payload, err = Decode([]byte(frame(KindSignal, `{"type":"candidate","candidate":{"candidate":"c","sdpMid":"0","sdpMLineIndex":1}}`)), KindSignal)
+	if err != nil {
+		t.Fatal(err)
+	}
+	signal, ok := payload.(*SignalPayload)
+	if !ok || signal.Candidate == nil || *signal.Candidate.SDPMid != "0" || *signal.Candidate.SDPMLineIndex != 1 {
+		t.Fatalf("payload = %#v, want a candidate with sdpMid and sdpMLineIndex", payload)
+	}
+}
+
+func TestRegistry(t *testing.T) {
+	fromClient := map[string]bool{
+		KindChat:       true,
+		KindTranscript: true,
+		KindTyping:     true,
+		KindControl:    true,
+		KindAck:        true,
+		KindSignal:     true,
+		KindAIWarning:  false,
+		KindAIReply:    false,
+		KindPresence:   false,
+		KindError:      false,
+	}
+	if len(registry) != len(fromClient) {
+		t.Errorf("registry has %d kinds, want %d", len(registry), len(fromClient))
+	}
+	for name, want := range fromClient {
+		kind, ok := registry[name]
+		if !ok {
+			t.Errorf("kind %q is not registered", name)
+			continue
+		}
+		if kind.Name != name {
+			t.Errorf("kind %q registered as %q", name, kind.Name)
+		}
+		if kind.FromClient != want {
+			t.Errorf("kind %q FromClient = %v, want %v", name, kind.FromClient, want)
+		}
+		if kind.New() == nil {
+			t.Errorf("kind %q New returned nil", name)
+		}
+	}
+}
+
+func TestNewEnvelope(t *testing.T) {
+	if _, err := NewEnvelope("shout", "s1", "u1", nil); err == nil {
+		t.Error("NewEnvelope accepted an unregistered kind")
+	}
+
+	env, err := NewEnvelope(KindChat, "s1", "u1", ChatPayload{Text: "hi"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if env.Version != EnvelopeVersion || env.ID == "" || env.SentAt == 0 {
+		t.Errorf("envelope missing version, id or sentAt: %+v", env)
+	}
+
+	// What the server builds for a client kind must pass the client's strict decoding
+	raw, err := json.Marshal(env)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, _, err := Decode(raw, KindChat); err != nil {
+		t.Errorf("server-built chat frame doesn't decode: %v", err)
+	}
+}
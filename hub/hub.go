@@ -0,0 +1,356 @@
+package hub
+
+import (
+	"context"
+	"encoding/json"
+	"log"
+	"sync"
+	"time"
+
+	"github.com/google/uuid"
+)
+
+// Config tunes per-client queues and heartbeats
+type Config struct {
+	SendBuffer   int           // Frames queued per client before it counts as a slow consumer
+	WriteTimeout time.Duration // Max time for a single write
+	PingInterval time.Duration // How often the server pings each client
+	PongTimeout  time.Duration // Max silence (no pong or frame) before the connection counts as dead
+	IdleTimeout  time.Duration // Max time without an application frame from the client
+	ReadLimit    int64         // Largest frame a client may send, in bytes
+	Broker       Broker        // Session fan-out across instances; in-process when nil
+
+	NewReplica  func(sessionId string) Replica // Shared live state per session; none when nil
+	SyncTimeout time.Duration                  // Max wait for another instance's snapshot of a session
+}
+
+// Hub tracks socket clients in rooms keyed by session ID. Rooms only hold this instance's
+// clients; broadcasts go through the broker so clients on other instances get them too.
+type Hub struct {
+	id     string // Tells this instance's changes apart when they come back from the broker
+	cfg    Config
+	mu     sync.RWMutex
+	rooms  map[string]map[*Client]struct{}
+	closed bool
+
+	subMu sync.Mutex
+	subs  map[string]func() // Broker unsubscribe per room with local clients or holds
+	holds map[string]int    // Replica users (Update, Read, Hold) per session
+
+	repMu    sync.RWMutex
+	replicas map[string]*replica         // Per subscribed session
+	waiters  map[string]chan interface{} // Update results by change ID
+}
+
+// delivery is what travels through the broker: an encoded frame plus who should get it
+type delivery struct {
+	Frame  json.RawMessage `json:"frame,omitempty"`
+	Seq    int64           `json:"seq,omitempty"`
+	Except string          `json:"except,omitempty"` // Client ID to skip
+	To     string          `json:"to,omitempty"`     // Only this user's clients
+	Close  int             `json:"close,omitempty"`  // Close the room with this code instead
+	Reason string          `json:"reason,omitempty"`
+
+	// Replica traffic instead of a frame
+	Change    json.RawMessage `json:"change,omitempty"`    // A change to apply
+	Origin    string          `json:"origin,omitempty"`    // "<hub id>/<change id>" of the change
+	Sync      string          `json:"sync,omitempty"`      // A joining instance asks for a snapshot
+	SyncReply string          `json:"syncReply,omitempty"` // The request a snapshot answers
+	Snapshot  json.RawMessage `json:"snapshot,omitempty"`
+}
+
+// New creates a hub, filling in defaults for unset config
+func New(cfg Config) *Hub {
+	if cfg.SendBuffer <= 0 {
+		cfg.SendBuffer = 64
+	}
+	if cfg.WriteTimeout <= 0 {
+		cfg.WriteTimeout = 10 * time.Second
+	}
+	if cfg.PingInterval <= 0 {
+		cfg.PingInterval = 25 * time.Second
+	}
+	if cfg.PongTimeout <= cfg.PingInterval {
+		cfg.PongTimeout = 2 * cfg.PingInterval
+	}
+	if cfg.IdleTimeout <= 0 {
+		cfg.IdleTimeout = 10 * time.Minute
+	}
+	if cfg.ReadLimit <= 0 {
+		cfg.ReadLimit = 2 * maxSDPLength // Room for the largest SDP, escaped, in its envelope
+	}
+	if cfg.Broker == nil {
+		cfg.Broker = NewMemoryBroker()
+	}
+	if cfg.SyncTimeout <= 0 {
+		cfg.SyncTimeout = 2 * time.Second
+	}
+	return &Hub{
+		id:       uuid.NewString(),
+		cfg:      cfg,
+		rooms:    make(map[string]map[*Client]struct{}),
+		subs:     make(map[string]func()),
+		holds:    make(map[string]int),
+		replicas: make(map[string]*replica),
+		waiters:  make(map[string]chan interface{}),
+	}
+}
+
+// Register adds a connection to its session room and starts its writer
+func (h *Hub) Register(conn Conn, sessionId, userId string) *Client {
+	return h.register(conn, sessionId, userId, false)
+}
+
+// RegisterHeld is Register for a client that first needs to catch up: live traffic is
+// held from the moment it joins the room until the caller calls Release.
+func (h *Hub) RegisterHeld(conn Conn, sessionId, userId string) *Client {
+	return h.register(conn, sessionId, userId, true)
+}
+
+func (h *Hub) register(conn Conn, sessionId, userId string, hold bool) *Client {
+	c := &Client{
+		id:        uuid.NewString(),
+		UserID:    userId,
+		SessionID: sessionId,
+		hub:       h,
+		conn:      conn,
+		readLimit: h.cfg.ReadLimit,
+		send:      make(chan []byte, h.cfg.SendBuffer),
+		done:      make(chan struct{}),
+		holding:   hold,
+	}
+	c.writerWG.Add(1)
+	go c.writeLoop()
+
+	h.mu.Lock()
+	if h.closed {
+		h.mu.Unlock()
+		c.CloseWith(CloseGoingAway, "server shutting down")
+		return c
+	}
+	if h.rooms[sessionId] == nil {
+		h.rooms[sessionId] = make(map[*Client]struct{})
+	}
+	h.rooms[sessionId][c] = struct{}{}
+	h.mu.Unlock()
+
+	h.subscribe(sessionId)
+	return c
+}
+
+func (h *Hub) remove(c *Client) {
+	h.mu.Lock()
+	room, ok := h.rooms[c.SessionID]
+	delete(room, c)
+	empty := ok && len(room) == 0
+	if empty {
+		delete(h.rooms, c.SessionID)
+	}
+	h.mu.Unlock()
+
+	// Not inline: remove can run inside a broker delivery
+	if empty {
+		go h.unsubscribeIfEmpty(c.SessionID)
+	}
+}
+
+// subscribe makes sure this instance receives the session's traffic and, when the hub keeps
+// replicas, starts catching its replica up
+func (h *Hub) subscribe(sessionId string) {
+	h.subMu.Lock()
+	defer h.subMu.Unlock()
+	if h.subs[sessionId] != nil {
+		return
+	}
+	r := h.newReplica(sessionId)
+	if r != nil {
+		h.repMu.Lock()
+		h.replicas[sessionId] = r
+		h.repMu.Unlock()
+	}
+	unsubscribe, err := h.cfg.Broker.Subscribe(context.Background(), sessionId, func(msg []byte) {
+		h.receive(sessionId, msg)
+	})
+	if err != nil {
+		log.Printf("❌ Failed to subscribe to session %s: %v\n", sessionId, err)
+		h.dropReplica(sessionId)
+		return
+	}
+	h.subs[sessionId] = unsubscribe
+	if r != nil {
+		h.requestSnapshot(sessionId, r)
+	}
+}
+
+// unsubscribeIfEmpty drops the session's subscription, and this instance's replica of its
+// state, once no local client or hold is left
+func (h *Hub) unsubscribeIfEmpty(sessionId string) {
+	h.subMu.Lock()
+	defer h.subMu.Unlock()
+	h.mu.RLock()
+	empty := len(h.rooms[sessionId]) == 0 && h.holds[sessionId] == 0
+	h.mu.RUnlock()
+	if unsubscribe := h.subs[sessionId]; empty && unsubscribe != nil {
+		unsubscribe()
+		delete(h.subs, sessionId)
+		h.dropReplica(sessionId)
+	}
+}
+
+// dropReplica forgets a replica that no longer receives the session's changes
+func (h *Hub) dropReplica(sessionId string) {
+	h.repMu.Lock()
+	r := h.replicas[sessionId]
+	delete(h.replicas, sessionId)
+	h.repMu.Unlock()
+	if r != nil {
+		r.finishSync(nil) // Nobody waits forever on a replica that was never synced
+	}
+}
+
+// publish hands a delivery to the broker and returns how many instances it reached
+func (h *Hub) publish(sessionId string, d delivery) (int, error) {
+	msg, err := json.Marshal(d)
+	if err != nil {
+		log.Println("❌ Failed to encode socket frame:", err)
+		return 0, err
+	}
+	receivers, err := h.cfg.Broker.Publish(context.Background(), sessionId, msg)
+	if err != nil {
+		log.Printf("❌ Failed to publish to session %s: %v\n", sessionId, err)
+	}
+	return receivers, err
+}
+
+// receive delivers a published frame to this instance's clients in the room
+func (h *Hub) receive(sessionId string, msg []byte) {
+	var d delivery
+	if err := json.Unmarshal(msg, &d); err != nil {
+		log.Println("❌ Failed to decode broker message:", err)
+		return
+	}
+	if d.Change != nil || d.Sync != "" || d.SyncReply != "" {
+		h.receiveState(sessionId, d)
+		return
+	}
+	if d.Close != 0 {
+		h.closeLocal(sessionId, d.Close, d.Reason)
+		return
+	}
+	for _, c := range h.clients(sessionId) {
+		if c.id == d.Except || (d.To != "" && c.UserID != d.To) {
+			continue
+		}
+		c.deliver(d.Frame, d.Seq)
+	}
+}
+
+// CloseSession disconnects everyone in a session room, on every instance, with the given
+// close code and reason
+func (h *Hub) CloseSession(sessionId string, code int, reason string) {
+	h.publish(sessionId, delivery{Close: code, Reason: reason})
+}
+
+func (h *Hub) closeLocal(sessionId string, code int, reason string) {
+	h.mu.Lock()
+	room := h.rooms[sessionId]
+	delete(h.rooms, sessionId)
+	h.mu.Unlock()
+	for c := range room {
+		c.CloseWith(code, reason)
+	}
+	go h.unsubscribeIfEmpty(sessionId)
+}
+
+// Shutdown stops accepting clients and closes every connection with CloseGoingAway, after
+// each client's queued frames are written. It returns when all writers are done or ctx expires.
+func (h *Hub) Shutdown(ctx context.Context) error {
+	h.mu.Lock()
+	h.closed = true
+	var all []*Client
+	for _, room := range h.rooms {
+		for c := range room {
+			all = append(all, c)
+		}
+	}
+	h.rooms = make(map[string]map[*Client]struct{})
+	h.mu.Unlock()
+
+	for _, c := range all {
+		c.CloseWith(CloseGoingAway, "server shutting down")
+	}
+
+	h.subMu.Lock()
+	for sessionId, unsubscribe := range h.subs {
+		unsubscribe()
+		delete(h.subs, sessionId)
+		h.dropReplica(sessionId)
+	}
+	h.subMu.Unlock()
+	if err := h.cfg.Broker.Close(); err != nil {
+		log.Println("❌ Failed to close broker:", err)
+	}
+
+	done := make(chan struct{})
+	go func() {
+		for _, c := range all {
+			c.writerWG.Wait()
+		}
+		close(done)
+	}()
+	select {
+	case <-done:
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+// Broadcast sends an envelope to everyone in the session, on every instance, except `except` (may be nil)
+func (h *Hub) Broadcast(sessionId string, env Envelope, except *Client) {
+	msg, err := json.Marshal(env)
+	if err != nil {
+		log.Println("❌ Failed to encode socket frame:", err)
+		return
+	}
+	d := delivery{Frame: msg, Seq: env.Seq}
+	if except != nil {
+		d.Except = except.id
+	}
+	h.publish(sessionId, d)
+}
+
+// SendToUser sends an envelope to every connection a user has in the session, on every instance
+func (h *Hub) SendToUser(sessionId, userId string, env Envelope) {
+	msg, err := json.Marshal(env)
+	if err != nil {
+		log.Println("❌ Failed to encode socket frame:", err)
+		return
+	}
+	h.publish(sessionId, delivery{Frame: msg, Seq: env.Seq, To: userId})
+}
+
+// Connections counts the sockets a user has in the session room on this instance
+func (h *Hub) Connections(sessionId, userId string) int {
+	h.mu.RLock()
+	defer h.mu.RUnlock()
+	n := 0
+	for c := range h.rooms[sessionId] {
+		if c.UserID == userId {
+			n++
+		}
+	}
+	return n
+}
+
+// clients snapshots a room so sends never happen under the hub lock
+func (h *Hub) clients(sessionId string) []*Client {
+	h.mu.RLock()
+	defer h.mu.RUnlock()
+	room := h.rooms[sessionId]
+	list := make([]*Client, 0, len(room))
+	for c := range room {
+		list = append(list, c)
+	}
+	return list
+}
@@ -0,0 +1,255 @@
+package hub
+
+import (
+	"errors"
+	"fmt"
+)
+
+// Message kinds. Every frame on a session socket is one of these.
+const (
+	KindChat       = "chat"
+	KindTranscript = "transcript"
+	KindAIWarning  = "ai_warning"
+	KindAIReply    = "ai_reply"
+	KindPresence   = "presence"
+	KindTyping     = "typing"
+	KindControl    = "control"
+	KindError      = "error"
+	KindAck        = "ack"
+	KindSignal     = "signal"
+)
+
+// Payload is implemented by every message payload so frames can be checked after decoding
+type Payload interface {
+	Validate() error
+}
+
+// Kind describes one registered message kind
+type Kind struct {
+	Name       string
+	FromClient bool           // Clients may send it; otherwise only the server emits it
+	New        func() Payload // Fresh payload value to decode into
+}
+
+var registry = map[string]Kind{}
+
+// RegisterKind adds a message kind to the registry
+func RegisterKind(k Kind) {
+	registry[k.Name] = k
+}
+
+func init() {
+	RegisterKind(Kind{Name: KindChat, FromClient: true, New: func() Payload { return &ChatPayload{} }})
+	RegisterKind(Kind{Name: KindTranscript, FromClient: true, New: func() Payload { return &TranscriptPayload{} }})
+	RegisterKind(Kind{Name: KindTyping, FromClient: true, New: func() Payload { return &TypingPayload{} }})
+	RegisterKind(Kind{Name: KindControl, FromClient: true, New: func() Payload { return &ControlPayload{} }})
+	RegisterKind(Kind{Name: KindAck, FromClient: true, New: func() Payload { return &AckPayload{} }})
+	RegisterKind(Kind{Name: KindSignal, FromClient: true, New: func() Payload { return &SignalPayload{} }})
+	RegisterKind(Kind{Name: KindAIWarning, New: func() Payload { return &AIWarningPayload{} }})
+	RegisterKind(Kind{Name: KindAIReply, New: func() Payload { return &AIReplyPayload{} }})
+	RegisterKind(Kind{Name: KindPresence, New: func() Payload { return &PresencePayload{} }})
+	RegisterKind(Kind{Name: KindError, New: func() Payload { return &ErrorPayload{} }})
+}
+
+const (
+	maxTextLength = 4000
+	maxSDPLength  = 64 * 1024
+)
+
+// ChatPayload is a typed chat message
+type ChatPayload struct {
+	Text string `json:"text"`
+}
+
+func (p *ChatPayload) Validate() error {
+	return validateText(p.Text)
+}
+
+// TranscriptPayload is one recognised utterance. StartMs/EndMs are milliseconds since
+// the sender's socket connected and are used for interruption detection.
+type TranscriptPayload struct {
+	Text    string `json:"text"`
+	StartMs *int64 `json:"startMs,omitempty"`
+	EndMs   *int64 `json:"endMs,omitempty"`
+}
+
+func (p *TranscriptPayload) Validate() error {
+	if err := validateText(p.Text); err != nil {
+		return err
+	}
+	if (p.StartMs == nil) != (p.EndMs == nil) {
+		return errors.New("startMs and endMs must be sent together")
+	}
+	if p.StartMs != nil && (*p.StartMs < 0 || *p.EndMs <= *p.StartMs) {
+		return errors.New("endMs must be after startMs, both non-negative")
+	}
+	return nil
+}
+
+// TypingPayload tells the partner someone is typing or speaking
+type TypingPayload struct {
+	Typing bool `json:"typing"`
+}
+
+func (p *TypingPayload) Validate() error { return nil }
+
+// Control actions clients may send
+const (
+	ActionPause        = "pause"
+	ActionResume       = "resume"
+	ActionRequestFloor = "request_floor"
+	ActionReleaseFloor = "release_floor"
+)
+
+// Control events the server sends
+const (
+	ActionSessionPaused  = "session_paused"
+	ActionCoolingOff     = "cooling_off"
+	ActionSessionResumed = "session_resumed"
+	ActionFloorGranted   = "floor_granted"
+	ActionFloorRequested = "floor_requested"
+	ActionFloorReleased  = "floor_released"
+	ActionInterrupt      = "interrupt"
+)
+
+// ControlPayload carries session control: time-outs and floor control requests from
+// clients, and the matching events from the server. Fields not used by an action are omitted.
+type ControlPayload struct {
+	Action      string `json:"action"`
+	Seconds     int    `json:"seconds,omitempty"`     // pause: requested cooling-off; session_paused: granted one
+	UserID      string `json:"userId,omitempty"`      // Floor holder/requester, or the partner who was interrupted
+	By          string `json:"by,omitempty"`          // Who paused or resumed ("AI" for the moderator)
+	Reason      string `json:"reason,omitempty"`      // floor_released: released, time_up or paused
+	Remaining   int    `json:"remaining,omitempty"`   // cooling_off: seconds left
+	MaxSeconds  int    `json:"maxSeconds,omitempty"`  // floor_granted: speaking time
+	ExpiresAt   int64  `json:"expiresAt,omitempty"`   // floor_granted: Unix time the floor runs out
+	PausedUntil int64  `json:"pausedUntil,omitempty"` // session_paused: Unix time the time-out ends
+	Count       int    `json:"count,omitempty"`       // interrupt: interruptions so far
+	Message     string `json:"message,omitempty"`     // interrupt: warning to show
+}
+
+func (p *ControlPayload) Validate() error {
+	switch p.Action {
+	case ActionPause:
+		if p.Seconds < 0 {
+			return errors.New("seconds can't be negative")
+		}
+		return nil
+	case ActionResume, ActionRequestFloor, ActionReleaseFloor:
+		return nil
+	default:
+		return fmt.Errorf("unknown control action %q", p.Action)
+	}
+}
+
+// AckPayload acknowledges persisted messages. Clients ack the highest seq they have
+// received; the server acks each client send with its id and the seq it was stored under.
+type AckPayload struct {
+	ID        string `json:"id,omitempty"`
+	Seq       int64  `json:"seq"`
+	Duplicate bool   `json:"duplicate,omitempty"` // The id had already been stored
+}
+
+func (p *AckPayload) Validate() error {
+	if p.Seq < 0 {
+		return errors.New("seq can't be negative")
+	}
+	return nil
+}
+
+// WebRTC signal types
+const (
+	SignalOffer     = "offer"
+	SignalAnswer    = "answer"
+	SignalCandidate = "candidate"
+	SignalHangup    = "hangup"
+)
+
+// ICECandidate mirrors RTCIceCandidateInit. An empty candidate marks the end of candidates.
+type ICECandidate struct {
+	Candidate     string  `json:"candidate"`
+	SDPMid        *string `json:"sdpMid,omitempty"`
+	SDPMLineIndex *uint16 `json:"sdpMLineIndex,omitempty"`
+}
+
+// SignalPayload carries WebRTC signalling between the two partners: an SDP offer or
+// answer, an ICE candidate, or a hangup. The server only passes it on to the other partner.
+type SignalPayload struct {
+	Type      string        `json:"type"`
+	SDP       string        `json:"sdp,omitempty"`       // offer, answer
+	Candidate *ICECandidate `json:"candidate,omitempty"` // candidate
+}
+
+func (p *SignalPayload) Validate() error {
+	switch p.Type {
+	case SignalOffer, SignalAnswer:
+		if p.SDP == "" {
+			return errors.New("sdp is required")
+		}
+		if len(p.SDP) > maxSDPLength {
+			return fmt.Errorf("sdp is longer than %d bytes", maxSDPLength)
+		}
+	case SignalCandidate:
+		if p.Candidate == nil {
+			return errors.New("candidate is required")
+		}
+	case SignalHangup:
+	default:
+		return fmt.Errorf("unknown signal type %q", p.Type)
+	}
+	return nil
+}
+
+// AIWarningPayload is a moderator warning about something a partner said
+type AIWarningPayload struct {
+	Message string `json:"message"`
+	Speaker string `json:"speaker,omitempty"`
+}
+
+func (p *AIWarningPayload) Validate() error { return nil }
+
+// AIReplyPayload is something the therapist AI says in the session
+type AIReplyPayload struct {
+	Text string `json:"text"`
+}
+
+func (p *AIReplyPayload) Validate() error { return nil }
+
+// Presence events
+const (
+	PresenceJoined       = "joined"
+	PresenceLeft         = "left"         // Gone past the grace period
+	PresenceReconnecting = "reconnecting" // Disconnected, still within the grace period
+	PresenceTyping       = "typing"
+	PresenceSpeaking     = "speaking"
+)
+
+// PresencePayload reports a partner joining, dropping out or leaving the session, or
+// starting/stopping typing or speaking (Active).
+type PresencePayload struct {
+	UserID string `json:"userId"`
+	Status string `json:"status"`
+	Active *bool  `json:"active,omitempty"` // typing, speaking: started (true) or stopped (false)
+	Since  int64  `json:"since,omitempty"`  // Unix time of the change
+}
+
+func (p *PresencePayload) Validate() error { return nil }
+
+// ErrorPayload explains why a frame was rejected. RefID is the rejected frame's id, if it had one.
+type ErrorPayload struct {
+	Code    string `json:"code"`
+	Message string `json:"message"`
+	RefID   string `json:"refId,omitempty"`
+}
+
+func (p *ErrorPayload) Validate() error { return nil }
+
+func validateText(text string) error {
+	if text == "" {
+		return errors.New("text is required")
+	}
+	if len(text) > maxTextLength {
+		return fmt.Errorf("text is longer than %d bytes", maxTextLength)
+	}
+	return nil
+}
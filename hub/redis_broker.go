@@ -0,0 +1,125 @@
+package hub
+
+import (
+	"context"
+	"log"
+	"strings"
+	"sync"
+
+	"github.com/redis/go-redis/v9"
+)
+
+const redisChannelPrefix = "mend:session:"
+
+// redisBroker fans session traffic out through Redis Pub/Sub, one channel per session.
+// All subscriptions share one Pub/Sub connection read by a single goroutine, which keeps
+// each session's messages in the order Redis received them.
+type redisBroker struct {
+	client redis.UniversalClient
+	pubsub *redis.PubSub
+
+	mu        sync.RWMutex
+	handlers  map[string]func(msg []byte) // By channel
+	confirmed map[string]chan struct{}    // Closed when Redis confirms the channel's subscription
+	done      chan struct{}
+}
+
+// NewRedisBroker returns a Broker backed by Redis Pub/Sub. Any redis.UniversalClient works,
+// including one pointed at a local or in-memory Redis for development.
+func NewRedisBroker(client redis.UniversalClient) Broker {
+	b := &redisBroker{
+		client:    client,
+		pubsub:    client.Subscribe(context.Background()),
+		handlers:  make(map[string]func(msg []byte)),
+		confirmed: make(map[string]chan struct{}),
+		done:      make(chan struct{}),
+	}
+	go b.readLoop()
+	return b
+}
+
+func (b *redisBroker) Publish(ctx context.Context, sessionId string, msg []byte) (int, error) {
+	receivers, err := b.client.Publish(ctx, redisChannelPrefix+sessionId, msg).Result()
+	return int(receivers), err
+}
+
+func (b *redisBroker) Subscribe(ctx context.Context, sessionId string, handler func(msg []byte)) (func(), error) {
+	channel := redisChannelPrefix + sessionId
+	confirmed := make(chan struct{})
+	b.mu.Lock()
+	b.handlers[channel] = handler
+	b.confirmed[channel] = confirmed
+	b.mu.Unlock()
+
+	forget := func() {
+		b.mu.Lock()
+		delete(b.handlers, channel)
+		delete(b.confirmed, channel)
+		b.mu.Unlock()
+	}
+	if err := b.pubsub.Subscribe(ctx, channel); err != nil {
+		forget()
+		return nil, err
+	}
+
+	// ⏳ Until Redis confirms, a publish wouldn't reach this subscription or count it
+	select {
+	case <-confirmed:
+	case <-ctx.Done():
+		forget()
+		return nil, ctx.Err()
+	case <-b.done:
+		forget()
+		return nil, redis.ErrClosed
+	}
+
+	return func() {
+		b.mu.Lock()
+		delete(b.handlers, channel)
+		b.mu.Unlock()
+		if err := b.pubsub.Unsubscribe(context.Background(), channel); err != nil {
+			log.Printf("❌ Failed to unsubscribe from %s: %v\n", channel, err)
+		}
+	}, nil
+}
+
+func (b *redisBroker) Close() error {
+	close(b.done)
+	return b.pubsub.Close()
+}
+
+func (b *redisBroker) readLoop() {
+	messages := b.pubsub.ChannelWithSubscriptions()
+	for {
+		select {
+		case received, ok := <-messages:
+			if !ok {
+				return
+			}
+			switch m := received.(type) {
+			case *redis.Subscription:
+				if m.Kind != "subscribe" {
+					continue
+				}
+				b.mu.Lock()
+				if confirmed := b.confirmed[m.Channel]; confirmed != nil {
+					close(confirmed)
+					delete(b.confirmed, m.Channel)
+				}
+				b.mu.Unlock()
+			case *redis.Message:
+				if !strings.HasPrefix(m.Channel, redisChannelPrefix) {
+					continue
+				}
+				b.mu.RLock()
+				handler := b.handlers[m.Channel]
+				b.mu.RUnlock()
+				if handler != nil {
+					handler([]byte(m.Payload))
+				}
+			}
+		case <-b.done:
+			return
+		}
+	}
+}
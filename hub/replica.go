@@ -0,0 +1,233 @@
+package hub
+
+import (
+	"errors"
+	"log"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/google/uuid"
+)
+
+// Replica is a copy of a session's shared live state (who holds the floor, who is connected,
+// whether the session is paused), kept by every instance with clients in the session. Changes
+// go through the broker with Hub.Update and every copy applies them in the broker's order, so
+// all copies agree. Apply must depend only on the replica and the change: no local clock, no
+// I/O. An instance that joins a running session asks the others for a snapshot first.
+type Replica interface {
+	// Apply makes one change and returns its outcome; Update hands it back to the caller
+	Apply(change []byte) interface{}
+	// Snapshot encodes the whole state for an instance joining the session
+	Snapshot() []byte
+	// Restore replaces the state with another instance's snapshot
+	Restore(snapshot []byte) error
+}
+
+// ErrNoReplica is returned by Update and Read when the session's replica isn't available:
+// the hub has no Config.NewReplica or couldn't subscribe to the session
+var ErrNoReplica = errors.New("hub: session replica not available")
+
+// How long Update waits for its change to come back through the broker
+const updateTimeout = 5 * time.Second
+
+// replica is this instance's copy of one session's state and how far it has caught up
+type replica struct {
+	state Replica
+
+	mu       sync.Mutex // Serializes Apply, Snapshot, Restore and Read
+	nonce    string     // Our snapshot request
+	seen     bool       // Our request came back, so later changes aren't in the snapshot
+	buffered [][]byte   // Changes since our request, applied once the snapshot is in
+	synced   bool
+	ready    chan struct{} // Closed once synced
+}
+
+// newReplica starts an unsynced copy of a session's state, if the hub keeps any
+func (h *Hub) newReplica(sessionId string) *replica {
+	if h.cfg.NewReplica == nil {
+		return nil
+	}
+	return &replica{state: h.cfg.NewReplica(sessionId), nonce: uuid.NewString(), ready: make(chan struct{})}
+}
+
+// requestSnapshot asks the session's other instances for the current state. Alone in the
+// session, the fresh replica already is the current state; if nobody answers in time the
+// replica starts fresh too.
+func (h *Hub) requestSnapshot(sessionId string, r *replica) {
+	receivers, err := h.publish(sessionId, delivery{Sync: r.nonce})
+	if err != nil || receivers <= 1 {
+		r.finishSync(nil)
+		return
+	}
+	time.AfterFunc(h.cfg.SyncTimeout, func() {
+		r.mu.Lock()
+		synced := r.synced
+		r.mu.Unlock()
+		if !synced {
+			log.Printf("⚠️ No snapshot of session %s arrived, starting from fresh state\n", sessionId)
+			r.finishSync(nil)
+		}
+	})
+}
+
+// finishSync restores a snapshot (nil keeps the fresh state) and catches up on buffered changes
+func (r *replica) finishSync(snapshot []byte) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if r.synced {
+		return
+	}
+	if snapshot != nil {
+		if err := r.state.Restore(snapshot); err != nil {
+			log.Println("❌ Failed to restore session snapshot:", err)
+		}
+	}
+	for _, change := range r.buffered {
+		r.state.Apply(change)
+	}
+	r.buffered = nil
+	r.synced = true
+	close(r.ready)
+}
+
+// receiveState handles the replica traffic in a delivery: snapshot requests and replies, and changes
+func (h *Hub) receiveState(sessionId string, d delivery) {
+	h.repMu.RLock()
+	r := h.replicas[sessionId]
+	h.repMu.RUnlock()
+	if r == nil {
+		return
+	}
+
+	switch {
+	case d.Sync != "":
+		r.mu.Lock()
+		if d.Sync == r.nonce {
+			r.seen = true
+			r.mu.Unlock()
+			return
+		}
+		if !r.synced {
+			r.mu.Unlock()
+			return // Still catching up ourselves
+		}
+		// 📸 The snapshot is taken at the request's place in the order; sending it can't wait
+		// for this delivery to finish
+		reply := delivery{SyncReply: d.Sync, Snapshot: r.state.Snapshot()}
+		r.mu.Unlock()
+		go h.publish(sessionId, reply)
+
+	case d.SyncReply != "":
+		r.mu.Lock()
+		ours := d.SyncReply == r.nonce && r.seen && !r.synced
+		r.mu.Unlock()
+		if ours {
+			r.finishSync(d.Snapshot)
+		}
+
+	case d.Change != nil:
+		r.mu.Lock()
+		if !r.synced {
+			if r.seen {
+				r.buffered = append(r.buffered, d.Change)
+			}
+			r.mu.Unlock()
+			return
+		}
+		result := r.state.Apply(d.Change)
+		r.mu.Unlock()
+
+		if hubId, changeId, ok := strings.Cut(d.Origin, "/"); ok && hubId == h.id {
+			h.repMu.Lock()
+			waiter := h.waiters[changeId]
+			delete(h.waiters, changeId)
+			h.repMu.Unlock()
+			if waiter != nil {
+				waiter <- result
+			}
+		}
+	}
+}
+
+// acquire subscribes to the session if needed and waits until this instance's replica has
+// caught up. The replica stays subscribed until release is called.
+func (h *Hub) acquire(sessionId string) (*replica, func()) {
+	h.subMu.Lock()
+	h.holds[sessionId]++
+	h.subMu.Unlock()
+	h.subscribe(sessionId)
+
+	var once sync.Once
+	release := func() {
+		once.Do(func() {
+			h.subMu.Lock()
+			if h.holds[sessionId]--; h.holds[sessionId] <= 0 {
+				delete(h.holds, sessionId)
+			}
+			h.subMu.Unlock()
+			h.unsubscribeIfEmpty(sessionId)
+		})
+	}
+
+	h.repMu.RLock()
+	r := h.replicas[sessionId]
+	h.repMu.RUnlock()
+	if r != nil {
+		<-r.ready
+	}
+	return r, release
+}
+
+// Hold keeps this instance subscribed to the session, with its replica up to date, until
+// release is called, e.g. while a timer that will update the session is pending
+func (h *Hub) Hold(sessionId string) (release func()) {
+	_, release = h.acquire(sessionId)
+	return release
+}
+
+// Read calls fn with this instance's up-to-date replica of the session's state. No change
+// applies while fn runs; fn must only read the replica and must not call Update.
+func (h *Hub) Read(sessionId string, fn func(state Replica)) error {
+	r, release := h.acquire(sessionId)
+	defer release()
+	if r == nil {
+		return ErrNoReplica
+	}
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	fn(r.state)
+	return nil
+}
+
+// Update publishes a change to every replica of the session and returns what applying it to
+// this instance's replica returned. Changes from all instances apply in the same order
+// everywhere, so the outcome is the same on every replica.
+func (h *Hub) Update(sessionId string, change []byte) (interface{}, error) {
+	r, release := h.acquire(sessionId)
+	defer release()
+	if r == nil {
+		return nil, ErrNoReplica
+	}
+
+	changeId := uuid.NewString()
+	result := make(chan interface{}, 1)
+	h.repMu.Lock()
+	h.waiters[changeId] = result
+	h.repMu.Unlock()
+	defer func() {
+		h.repMu.Lock()
+		delete(h.waiters, changeId)
+		h.repMu.Unlock()
+	}()
+
+	if _, err := h.publish(sessionId, delivery{Change: change, Origin: h.id + "/" + changeId}); err != nil {
+		return nil, err
+	}
+	select {
+	case res := <-result:
+		return res, nil
+	case <-time.After(updateTimeout):
+		return nil, errors.New("hub: change to session " + sessionId + " didn't come back from the broker")
+	}
+}
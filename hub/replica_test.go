@@ -0,0 +1,195 @@
+package hub
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"reflect"
+	"strconv"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+// counter is a Replica that adds up numbers and remembers the order they came in
+type counter struct {
+	Total int      `json:"total"`
+	Log   []string `json:"log"`
+}
+
+func (c *counter) Apply(change []byte) interface{} {
+	n, _ := strconv.Atoi(string(change))
+	c.Total += n
+	c.Log = append(c.Log, string(change))
+	return c.Total
+}
+
+func (c *counter) Snapshot() []byte {
+	snapshot, _ := json.Marshal(c)
+	return snapshot
+}
+
+func (c *counter) Restore(snapshot []byte) error {
+	return json.Unmarshal(snapshot, c)
+}
+
+// fakeConn is a socket that accepts every write and blocks reads until closed
+type fakeConn struct {
+	closed    chan struct{}
+	closeOnce sync.Once
+	readLimit atomic.Int64
+}
+
+func newFakeConn() *fakeConn { return &fakeConn{closed: make(chan struct{})} }
+
+func (c *fakeConn) ReadMessage() (int, []byte, error) {
+	<-c.closed
+	return 0, nil, errors.New("connection closed")
+}
+func (c *fakeConn) WriteMessage(int, []byte) error            { return nil }
+func (c *fakeConn) WriteControl(int, []byte, time.Time) error { return nil }
+func (c *fakeConn) SetWriteDeadline(time.Time) error          { return nil }
+func (c *fakeConn) SetReadDeadline(time.Time) error           { return nil }
+func (c *fakeConn) SetPongHandler(func(appData string) error) {}
+func (c *fakeConn) SetReadLimit(limit int64)                  { c.readLimit.Store(limit) }
+func (c *fakeConn) Close() error                              { c.closeOnce.Do(func() { close(c.closed) }); return nil }
+
+// newCounterHub is one instance on the shared broker
+func newCounterHub(broker Broker) *Hub {
+	return New(Config{
+		Broker:      broker,
+		NewReplica:  func(string) Replica { return &counter{} },
+		SyncTimeout: 100 * time.Millisecond,
+	})
+}
+
+func readCounter(t *testing.T, h *Hub, sessionId string) counter {
+	t.Helper()
+	var got counter
+	if err := h.Read(sessionId, func(state Replica) {
+		c := state.(*counter)
+		got = counter{Total: c.Total, Log: append([]string(nil), c.Log...)}
+	}); err != nil {
+		t.Fatal(err)
+	}
+	return got
+}
+
+func TestReplicasAgreeAcrossHubs(t *testing.T) {
+	broker := NewMemoryBroker()
+	h1, h2 := newCounterHub(broker), newCounterHub(broker)
+	defer h1.Shutdown(context.Background())
+	defer h2.Shutdown(context.Background())
+	h1.Register(newFakeConn(), "s1", "alice")
+	h2.Register(newFakeConn(), "s1", "bob")
+
+	// Concurrent changes from both instances apply in one order everywhere
+	var wg sync.WaitGroup
+	for i := 1; i <= 20; i++ {
+		h := h1
+		if i%2 == 0 {
+			h = h2
+		}
+		wg.Add(1)
+		go func(h *Hub, n int) {
+			defer wg.Done()
+			if _, err := h.Update("s1", []byte(strconv.Itoa(n))); err != nil {
+				t.Error(err)
+			}
+		}(h, i)
+	}
+	wg.Wait()
+
+	on1, on2 := readCounter(t, h1, "s1"), readCounter(t, h2, "s1")
+	if on1.Total != 210 || !reflect.DeepEqual(on1, on2) {
+		t.Fatalf("replicas differ: %+v vs %+v", on1, on2)
+	}
+
+	// Update returns the outcome of applying the change here, in order
+	total, err := h2.Update("s1", []byte("5"))
+	if err != nil || total != 215 {
+		t.Fatalf("Update = (%v, %v), want (215, nil)", total, err)
+	}
+}
+
+func TestReplicaJoiningLateGetsSnapshot(t *testing.T) {
+	broker := NewMemoryBroker()
+	h1, h2 := newCounterHub(broker), newCounterHub(broker)
+	defer h1.Shutdown(context.Background())
+	defer h2.Shutdown(context.Background())
+
+	h1.Register(newFakeConn(), "s1", "alice")
+	for _, n := range []string{"1", "2", "3"} {
+		if _, err := h1.Update("s1", []byte(n)); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	h2.Register(newFakeConn(), "s1", "bob")
+	if got := readCounter(t, h2, "s1"); got.Total != 6 || !reflect.DeepEqual(got.Log, []string{"1", "2", "3"}) {
+		t.Fatalf("late replica = %+v, want the snapshot of 1, 2, 3", got)
+	}
+
+	if _, err := h2.Update("s1", []byte("4")); err != nil {
+		t.Fatal(err)
+	}
+	if on1, on2 := readCounter(t, h1, "s1"), readCounter(t, h2, "s1"); !reflect.DeepEqual(on1, on2) || on1.Total != 10 {
+		t.Fatalf("replicas differ after the join: %+v vs %+v", on1, on2)
+	}
+}
+
+func TestReplicaLifetime(t *testing.T) {
+	h := newCounterHub(NewMemoryBroker())
+	defer h.Shutdown(context.Background())
+
+	// Alone and without clients, an update still applies to a fresh replica...
+	if total, err := h.Update("s1", []byte("7")); err != nil || total != 7 {
+		t.Fatalf("Update = (%v, %v), want (7, nil)", total, err)
+	}
+	// ...which is gone once nothing keeps it
+	if got := readCounter(t, h, "s1"); got.Total != 0 {
+		t.Fatalf("replica outlived its last user: %+v", got)
+	}
+
+	release := h.Hold("s1")
+	h.Update("s1", []byte("3"))
+	if got := readCounter(t, h, "s1"); got.Total != 3 {
+		t.Fatalf("held replica = %+v, want total 3", got)
+	}
+	release()
+	if got := readCounter(t, h, "s1"); got.Total != 0 {
+		t.Fatalf("replica outlived its hold: %+v", got)
+	}
+}
+
+func TestReplicaStartsFreshWithoutSnapshot(t *testing.T) {
+	broker := NewMemoryBroker()
+
+	// Someone else is subscribed but never answers snapshot requests
+	unsubscribe, _ := broker.Subscribe(context.Background(), "s1", func([]byte) {})
+	defer unsubscribe()
+
+	h := newCounterHub(broker)
+	defer h.Shutdown(context.Background())
+	h.Register(newFakeConn(), "s1", "alice")
+
+	start := time.Now()
+	if total, err := h.Update("s1", []byte("2")); err != nil || total != 2 {
+		t.Fatalf("Update = (%v, %v), want (2, nil)", total, err)
+	}
+	if waited := time.Since(start); waited < 100*time.Millisecond {
+		t.Errorf("replica was ready after %v, before the sync timeout", waited)
+	}
+}
+
+func TestHubWithoutReplicas(t *testing.T) {
+	h := New(Config{})
+	defer h.Shutdown(context.Background())
+	if _, err := h.Update("s1", []byte("1")); !errors.Is(err, ErrNoReplica) {
+		t.Errorf("Update error = %v, want ErrNoReplica", err)
+	}
+	if err := h.Read("s1", func(Replica) { t.Error("Read called fn without a replica") }); !errors.Is(err, ErrNoReplica) {
+		t.Errorf("Read error = %v, want ErrNoReplica", err)
+	}
+}
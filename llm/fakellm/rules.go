@@ -0,0 +1,136 @@
+package fakellm
+
+import (
+	"encoding/json"
+	"strings"
+
+	"mend/llm"
+)
+
+// hostileWords make the fake moderator warn and the fake scorer mark a conversation down
+var hostileWords = []string{"hate", "stupid", "idiot", "shut up", "always", "never", "whatever"}
+
+// schemaMarker starts the JSON Schema in a structured-output prompt
+const schemaMarker = "matching this JSON Schema:"
+
+// respond picks a canned reply by recognising which of the backend's prompts this is
+func respond(req ChatRequest) string {
+	var prompt strings.Builder
+	for _, m := range req.Messages {
+		prompt.WriteString(m.Content)
+		prompt.WriteString("\n")
+	}
+	text := prompt.String()
+	lower := strings.ToLower(text)
+	hostile := countHostile(quoted(withoutSchemas(text)))
+
+	switch {
+	case strings.Contains(lower, "rate their communication"):
+		return scoreReply(countHostile(text[max(strings.LastIndex(text, "Transcript:"), 0):]))
+	case strings.Contains(lower, "is_flagged"):
+		return moderationReply(hostile)
+	case strings.Contains(lower, "conversation moderator"):
+		return voiceModerationReply(hostile)
+	case strings.Contains(lower, "reflection"):
+		return "You both showed up and stayed in the conversation, which matters. " +
+			"There were moments of frustration, especially around feeling unheard. " +
+			"Keep naming your feelings rather than your partner's faults, and you'll keep making progress."
+	case strings.Contains(lower, "therapeutic"), strings.Contains(lower, "therapist"):
+		if hostile > 0 {
+			return "I can hear how strongly you feel. Could you try saying what you need, starting with \"I feel\"?"
+		}
+		return "Thank you for sharing that. How do you think your partner felt hearing it?"
+	}
+	if req.ResponseFormat != nil && req.ResponseFormat.Type == "json_object" {
+		return "{}"
+	}
+	return "I hear you."
+}
+
+// withoutSchemas drops the JSON Schemas appended by llm.Schema.Instructions, whose quoted
+// descriptions would otherwise be mistaken for the message being judged
+func withoutSchemas(text string) string {
+	for {
+		i := strings.Index(text, schemaMarker)
+		if i < 0 {
+			return text
+		}
+		rest := text[i+len(schemaMarker):]
+		schema, err := llm.ExtractJSON(rest)
+		if err != nil {
+			return text[:i]
+		}
+		text = text[:i] + rest[strings.Index(rest, schema)+len(schema):]
+	}
+}
+
+// quoted returns the parts of the prompt in double quotes: the message being judged,
+// rather than the instructions around it
+func quoted(text string) string {
+	parts := strings.Split(text, `"`)
+	var b strings.Builder
+	for i := 1; i < len(parts); i += 2 {
+		b.WriteString(parts[i])
+		b.WriteString(" ")
+	}
+	if b.Len() == 0 {
+		return text
+	}
+	return b.String()
+}
+
+func countHostile(text string) int {
+	lower := strings.ToLower(text)
+	n := 0
+	for _, w := range hostileWords {
+		n += strings.Count(lower, w)
+	}
+	return n
+}
+
+func scoreReply(hostile int) string {
+	score := max(5-hostile, 1)
+	summary := "The tone was respectful and both partners listened to each other."
+	if hostile > 0 {
+		summary = "There was some tension and absolute language, but both partners kept talking."
+	}
+	return mustJSON(map[string]interface{}{
+		"empathy":            score,
+		"listening":          score,
+		"respect":            score,
+		"clarity":            min(score+1, 5),
+		"conflictResolution": score,
+		"summary":            summary,
+	})
+}
+
+func moderationReply(hostile int) string {
+	result := map[string]interface{}{
+		"tone":       "calm",
+		"respect":    "respectful",
+		"clarity":    "clear",
+		"empathy":    "shows understanding",
+		"warning":    "",
+		"is_flagged": false,
+	}
+	if hostile > 0 {
+		result["tone"] = "angry"
+		result["respect"] = "disrespectful"
+		result["empathy"] = "little understanding"
+		result["warning"] = "Please use respectful language."
+		result["is_flagged"] = true
+	}
+	return mustJSON(result)
+}
+
+func voiceModerationReply(hostile int) string {
+	if hostile > 0 {
+		return mustJSON(map[string]interface{}{"tone": "hostile", "empathy": 3, "clarity": 6, "respect": 2, "warning": true})
+	}
+	return mustJSON(map[string]interface{}{"tone": "respectful", "empathy": 8, "clarity": 8, "respect": 9, "warning": false})
+}
+
+func mustJSON(v interface{}) string {
+	b, _ := json.Marshal(v)
+	return string(b)
+}
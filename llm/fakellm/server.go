@@ -0,0 +1,146 @@
+// Package fakellm is an offline, deterministic stand-in for an OpenAI-compatible
+// chat-completions server. It recognises the backend's prompts (therapist reply,
+// moderation, reflection, scoring) and answers each with a canned, rule-based reply, so
+// the whole session flow runs without credentials or network. Use it with httptest:
+//
+//	srv := httptest.NewServer(fakellm.New())
+//	llm.SetDefault(llm.NewLocal(srv.URL+"/v1", "fake", ""))
+//
+// or as a process (cmd/fakellm) with LLM_PROVIDER=local and LLM_BASE_URL pointing at it.
+package fakellm
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Server answers POST /v1/chat/completions (OpenAI and local style) and
+// /openai/deployments/{name}/chat/completions (Azure style), streaming or not
+type Server struct {
+	mu       sync.Mutex
+	script   []string
+	requests []ChatRequest
+}
+
+// New returns a rule-based fake server
+func New() *Server {
+	return &Server{}
+}
+
+// Script queues replies that are returned, in order, before the rules apply again
+func (s *Server) Script(replies ...string) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.script = append(s.script, replies...)
+}
+
+// Requests returns every chat request received so far
+func (s *Server) Requests() []ChatRequest {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return append([]ChatRequest(nil), s.requests...)
+}
+
+// ChatRequest is the part of a chat-completions request the fake looks at
+type ChatRequest struct {
+	Model    string `json:"model"`
+	Messages []struct {
+		Role    string `json:"role"`
+		Content string `json:"content"`
+	} `json:"messages"`
+	Stream         bool `json:"stream"`
+	ResponseFormat *struct {
+		Type string `json:"type"`
+	} `json:"response_format"`
+}
+
+func (s *Server) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost || !strings.HasSuffix(r.URL.Path, "/chat/completions") {
+		writeError(w, http.StatusNotFound, "Only POST .../chat/completions is supported")
+		return
+	}
+	var req ChatRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil || len(req.Messages) == 0 {
+		writeError(w, http.StatusBadRequest, "Invalid chat completion request")
+		return
+	}
+
+	s.mu.Lock()
+	s.requests = append(s.requests, req)
+	var reply string
+	if len(s.script) > 0 {
+		reply, s.script = s.script[0], s.script[1:]
+	}
+	s.mu.Unlock()
+	if reply == "" {
+		reply = respond(req)
+	}
+
+	model := req.Model
+	if model == "" {
+		model = "fake"
+	}
+	id := fmt.Sprintf("chatcmpl-fake-%d", time.Now().UnixNano())
+	created := time.Now().Unix()
+
+	if req.Stream {
+		stream(w, id, created, model, reply)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]interface{}{
+		"id":      id,
+		"object":  "chat.completion",
+		"created": created,
+		"model":   model,
+		"choices": []map[string]interface{}{{
+			"index":         0,
+			"message":       map[string]string{"role": "assistant", "content": reply},
+			"finish_reason": "stop",
+		}},
+		"usage": map[string]int{"prompt_tokens": 0, "completion_tokens": 0, "total_tokens": 0},
+	})
+}
+
+// stream sends the reply as server-sent events, a word per chunk
+func stream(w http.ResponseWriter, id string, created int64, model, reply string) {
+	w.Header().Set("Content-Type", "text/event-stream")
+	w.Header().Set("Cache-Control", "no-cache")
+	flusher, _ := w.(http.Flusher)
+
+	send := func(delta map[string]string, finish interface{}) {
+		chunk, _ := json.Marshal(map[string]interface{}{
+			"id":      id,
+			"object":  "chat.completion.chunk",
+			"created": created,
+			"model":   model,
+			"choices": []map[string]interface{}{{"index": 0, "delta": delta, "finish_reason": finish}},
+		})
+		fmt.Fprintf(w, "data: %s\n\n", chunk)
+		if flusher != nil {
+			flusher.Flush()
+		}
+	}
+
+	send(map[string]string{"role": "assistant"}, nil)
+	for _, word := range strings.SplitAfter(reply, " ") {
+		send(map[string]string{"content": word}, nil)
+	}
+	send(map[string]string{}, "stop")
+	fmt.Fprint(w, "data: [DONE]\n\n")
+	if flusher != nil {
+		flusher.Flush()
+	}
+}
+
+func writeError(w http.ResponseWriter, status int, message string) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	json.NewEncoder(w).Encode(map[string]interface{}{
+		"error": map[string]string{"message": message, "type": "invalid_request_error"},
+	})
+}
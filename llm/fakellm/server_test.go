@@ -0,0 +1,194 @@
+package fakellm
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"mend/llm"
+	"mend/prompts"
+)
+
+// newFake serves a fresh fake and returns a local-provider model talking to it
+func newFake(t *testing.T) (*Server, llm.LLM, *httptest.Server) {
+	t.Helper()
+	fake := New()
+	srv := httptest.NewServer(fake)
+	t.Cleanup(srv.Close)
+	return fake, llm.NewLocal(srv.URL+"/v1", "fake", ""), srv
+}
+
+func render(t *testing.T, name string, vars prompts.Vars) llm.Request {
+	t.Helper()
+	req, err := prompts.Get(name).Render(vars)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return req
+}
+
+func TestRules(t *testing.T) {
+	schema := func(s llm.Schema) string { return s.Instructions() }
+	moderation := llm.Schema{Name: "moderation", Fields: []llm.Field{
+		{Name: "tone", Type: llm.TypeString, Required: true},
+		{Name: "is_flagged", Type: llm.TypeBoolean, Required: true},
+	}}
+	voice := llm.Schema{Name: "voice", Fields: []llm.Field{{Name: "warning", Type: llm.TypeBoolean, Required: true}}}
+	score := llm.Schema{Name: "score", Fields: []llm.Field{{Name: "empathy", Type: llm.TypeInteger, Required: true}}}
+
+	tests := []struct {
+		name   string
+		prompt string
+		vars   prompts.Vars
+		want   string // Substring of the reply, or for JSON replies a field and its value
+	}{
+		{"therapist, calm", prompts.TherapistReply, prompts.Vars{
+			"speaker": "alice", "transcript": "I'd like us to plan the weekend together",
+			"conversation": "(no earlier messages)", "partners": "Not available.", "previous_summary": "Not available.",
+		}, "How do you think your partner felt"},
+		{"therapist, hostile", prompts.TherapistReply, prompts.Vars{
+			"speaker": "alice", "transcript": "You never listen to me",
+			"conversation": "(no earlier messages)", "partners": "Not available.", "previous_summary": "Not available.",
+		}, `starting with "I feel"`},
+		{"moderation, calm", prompts.Moderation, prompts.Vars{
+			"speaker": "alice", "message": "Thanks for hearing me out", "schema": schema(moderation),
+		}, `"is_flagged":false`},
+		{"moderation, hostile", prompts.Moderation, prompts.Vars{
+			"speaker": "alice", "message": "Shut up, that's stupid", "schema": schema(moderation),
+		}, `"is_flagged":true`},
+		{"voice moderation, calm", prompts.VoiceModeration, prompts.Vars{
+			"speaker": "bob", "transcript": "I see what you mean", "context": "", "schema": schema(voice),
+		}, `"warning":false`},
+		{"voice moderation, hostile", prompts.VoiceModeration, prompts.Vars{
+			"speaker": "bob", "transcript": "Whatever, I hate this", "context": "", "schema": schema(voice),
+		}, `"warning":true`},
+		{"reflection", prompts.Reflection, prompts.Vars{
+			"transcript": "alice: I feel unheard\nbob: I'm sorry",
+		}, "You both showed up"},
+		{"score, respectful", prompts.Score, prompts.Vars{
+			"schema": schema(score), "interruptions": "none recorded", "transcript": "alice: I feel unheard\nbob: I'm listening",
+		}, `"empathy":5`},
+		{"score, hostile", prompts.Score, prompts.Vars{
+			"schema": schema(score), "interruptions": "none recorded", "transcript": "alice: You always do this\nbob: You never care",
+		}, `"empathy":3`},
+	}
+	_, model, _ := newFake(t)
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			reply, err := model.Chat(context.Background(), render(t, tt.prompt, tt.vars))
+			if err != nil {
+				t.Fatal(err)
+			}
+			if !strings.Contains(reply, tt.want) {
+				t.Errorf("reply = %s, want it to contain %s", reply, tt.want)
+			}
+		})
+	}
+}
+
+func TestStructuredRepliesParse(t *testing.T) {
+	_, model, _ := newFake(t)
+	schema := llm.Schema{Name: "communication_score", Fields: []llm.Field{
+		{Name: "empathy", Type: llm.TypeInteger, Required: true, Min: 1, Max: 5},
+		{Name: "listening", Type: llm.TypeInteger, Required: true, Min: 1, Max: 5},
+		{Name: "respect", Type: llm.TypeInteger, Required: true, Min: 1, Max: 5},
+		{Name: "clarity", Type: llm.TypeInteger, Required: true, Min: 1, Max: 5},
+		{Name: "conflictResolution", Type: llm.TypeInteger, Required: true, Min: 1, Max: 5},
+		{Name: "summary", Type: llm.TypeString, Required: true},
+	}}
+	req := render(t, prompts.Score, prompts.Vars{
+		"schema": schema.Instructions(), "interruptions": "none recorded", "transcript": "alice: I hate it when you're late",
+	})
+
+	var score struct {
+		Empathy int    `json:"empathy"`
+		Clarity int    `json:"clarity"`
+		Summary string `json:"summary"`
+	}
+	if err := llm.ChatStructured(context.Background(), model, req, schema, 0, &score); err != nil {
+		t.Fatal(err)
+	}
+	if score.Empathy != 4 || score.Clarity != 5 || score.Summary == "" {
+		t.Errorf("score = %+v, want empathy 4, clarity 5 and a summary", score)
+	}
+}
+
+func TestTransports(t *testing.T) {
+	fake, model, srv := newFake(t)
+	ctx := context.Background()
+	req := llm.Request{Messages: []llm.Message{llm.User("hello")}}
+
+	var deltas []string
+	reply, err := model.Stream(ctx, req, func(d string) error { deltas = append(deltas, d); return nil })
+	if err != nil || reply != "I hear you." || len(deltas) != 3 {
+		t.Errorf("Stream = (%q, %v) in %q, want \"I hear you.\" a word at a time", reply, err, deltas)
+	}
+
+	azure := llm.NewAzure(srv.URL, "key", "gpt-4o", "2024-02-01")
+	if reply, err := azure.Chat(ctx, req); err != nil || reply != "I hear you." {
+		t.Errorf("Azure-style Chat = (%q, %v)", reply, err)
+	}
+
+	if reply, err := model.ChatJSON(ctx, req); err != nil || reply != "{}" {
+		t.Errorf("ChatJSON without a known prompt = (%q, %v), want {}", reply, err)
+	}
+
+	if got := len(fake.Requests()); got != 3 {
+		t.Errorf("recorded %d requests, want 3", got)
+	}
+	if got := fake.Requests()[1].Model; got != "gpt-4o" {
+		t.Errorf("Azure request model = %q, want the deployment", got)
+	}
+}
+
+func TestScript(t *testing.T) {
+	fake, model, _ := newFake(t)
+	fake.Script("first", "second")
+	req := llm.Request{Messages: []llm.Message{llm.User("hello")}}
+
+	for _, want := range []string{"first", "second", "I hear you."} {
+		if reply, err := model.Chat(context.Background(), req); err != nil || reply != want {
+			t.Errorf("Chat = (%q, %v), want %q", reply, err, want)
+		}
+	}
+}
+
+func TestBadRequests(t *testing.T) {
+	_, _, srv := newFake(t)
+	tests := []struct {
+		name   string
+		method string
+		path   string
+		body   string
+		want   int
+	}{
+		{"wrong path", http.MethodPost, "/v1/embeddings", `{}`, http.StatusNotFound},
+		{"wrong method", http.MethodGet, "/v1/chat/completions", ``, http.StatusNotFound},
+		{"not json", http.MethodPost, "/v1/chat/completions", `hello`, http.StatusBadRequest},
+		{"no messages", http.MethodPost, "/v1/chat/completions", `{"messages":[]}`, http.StatusBadRequest},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			req, _ := http.NewRequest(tt.method, srv.URL+tt.path, strings.NewReader(tt.body))
+			resp, err := http.DefaultClient.Do(req)
+			if err != nil {
+				t.Fatal(err)
+			}
+			defer resp.Body.Close()
+			if resp.StatusCode != tt.want {
+				t.Errorf("status = %d, want %d", resp.StatusCode, tt.want)
+			}
+			var body struct {
+				Error struct {
+					Message string `json:"message"`
+				} `json:"error"`
+			}
+			if json.NewDecoder(resp.Body).Decode(&body); body.Error.Message == "" {
+				t.Error("no OpenAI-style error message")
+			}
+		})
+	}
+}
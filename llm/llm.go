@@ -0,0 +1,151 @@
+// Package llm is the one way the backend talks to a chat-completion model. Every AI
+// feature goes through the LLM interface; which provider answers is a matter of config.
+package llm
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"os"
+	"sync"
+)
+
+// Message roles
+const (
+	RoleSystem    = "system"
+	RoleUser      = "user"
+	RoleAssistant = "assistant"
+)
+
+// Message is one turn of a conversation with the model
+type Message struct {
+	Role    string `json:"role"`
+	Content string `json:"content"`
+}
+
+// Request is a chat completion request. Zero values leave the provider's defaults.
+type Request struct {
+	Messages    []Message
+	Model       string   // Overrides the provider's model (the deployment on Azure)
+	Temperature *float32 // Nil for the provider's default; 0 asks for the most deterministic reply
+	MaxTokens   int
+}
+
+// LLM is a chat-completion model
+type LLM interface {
+	// Chat returns the model's reply
+	Chat(ctx context.Context, req Request) (string, error)
+	// ChatJSON asks for a reply that is a single JSON object (JSON mode) and returns it unparsed
+	ChatJSON(ctx context.Context, req Request) (string, error)
+	// Stream calls onDelta with each piece of the reply as it arrives and returns the full
+	// reply. An error from onDelta stops the stream.
+	Stream(ctx context.Context, req Request, onDelta func(delta string) error) (string, error)
+}
+
+var (
+	// ErrNotConfigured means no provider is set up (see FromEnv)
+	ErrNotConfigured = errors.New("llm: no provider configured")
+	// ErrEmptyResponse means the provider answered without any content
+	ErrEmptyResponse = errors.New("llm: empty response")
+)
+
+// System and User build the usual two-message prompt
+func System(content string) Message { return Message{Role: RoleSystem, Content: content} }
+func User(content string) Message   { return Message{Role: RoleUser, Content: content} }
+
+// FromEnv builds the provider named by LLM_PROVIDER:
+//
+//   - azure: Azure OpenAI (OPENAI_ENDPOINT, OPENAI_DEPLOYMENT, OPENAI_API_KEY, OPENAI_API_VERSION)
+//   - openai: OpenAI (OPENAI_API_KEY, OPENAI_MODEL)
+//   - local: any OpenAI-compatible server (LLM_BASE_URL, LLM_MODEL, optional LLM_API_KEY)
+//   - fake: a Scripted model that always answers "{}" (for local runs without a provider)
+//
+// Without LLM_PROVIDER it uses Azure when OPENAI_ENDPOINT and OPENAI_DEPLOYMENT are set,
+// as the backend always has, and OpenAI when only OPENAI_API_KEY is.
+func FromEnv() (LLM, error) {
+	provider := os.Getenv("LLM_PROVIDER")
+	if provider == "" {
+		switch {
+		case os.Getenv("OPENAI_ENDPOINT") != "" && os.Getenv("OPENAI_DEPLOYMENT") != "":
+			provider = "azure"
+		case os.Getenv("OPENAI_API_KEY") != "":
+			provider = "openai"
+		default:
+			return nil, ErrNotConfigured
+		}
+	}
+
+	switch provider {
+	case "azure":
+		endpoint, deployment := os.Getenv("OPENAI_ENDPOINT"), os.Getenv("OPENAI_DEPLOYMENT")
+		if endpoint == "" || deployment == "" {
+			return nil, fmt.Errorf("%w: azure needs OPENAI_ENDPOINT and OPENAI_DEPLOYMENT", ErrNotConfigured)
+		}
+		return NewAzure(endpoint, os.Getenv("OPENAI_API_KEY"), deployment, getenv("OPENAI_API_VERSION", defaultAzureAPIVersion)), nil
+	case "openai":
+		if os.Getenv("OPENAI_API_KEY") == "" {
+			return nil, fmt.Errorf("%w: openai needs OPENAI_API_KEY", ErrNotConfigured)
+		}
+		return NewOpenAI(os.Getenv("OPENAI_API_KEY"), getenv("OPENAI_MODEL", defaultOpenAIModel)), nil
+	case "local":
+		baseURL := os.Getenv("LLM_BASE_URL")
+		if baseURL == "" {
+			return nil, fmt.Errorf("%w: local needs LLM_BASE_URL", ErrNotConfigured)
+		}
+		return NewLocal(baseURL, getenv("LLM_MODEL", "local"), os.Getenv("LLM_API_KEY")), nil
+	case "fake":
+		return NewScripted(), nil
+	default:
+		return nil, fmt.Errorf("%w: unknown LLM_PROVIDER %q", ErrNotConfigured, provider)
+	}
+}
+
+var (
+	defaultOnce sync.Once
+	defaultMu   sync.RWMutex
+	defaultLLM  LLM
+	defaultErr  error
+)
+
+// Default returns the process-wide model, built from the environment on first use
+// (after config.LoadEnv has run). Without a provider every call fails with ErrNotConfigured.
+func Default() LLM {
+	defaultOnce.Do(func() {
+		l, err := FromEnv()
+		defaultMu.Lock()
+		if defaultLLM == nil {
+			defaultLLM, defaultErr = l, err
+		}
+		defaultMu.Unlock()
+	})
+	defaultMu.RLock()
+	defer defaultMu.RUnlock()
+	if defaultLLM == nil {
+		return unavailable{err: defaultErr}
+	}
+	return defaultLLM
+}
+
+// SetDefault replaces the process-wide model, e.g. with a Scripted one in tests
+func SetDefault(l LLM) {
+	defaultOnce.Do(func() {})
+	defaultMu.Lock()
+	defer defaultMu.Unlock()
+	defaultLLM, defaultErr = l, nil
+}
+
+// unavailable is the Default when no provider could be built
+type unavailable struct{ err error }
+
+func (u unavailable) Chat(context.Context, Request) (string, error)     { return "", u.err }
+func (u unavailable) ChatJSON(context.Context, Request) (string, error) { return "", u.err }
+func (u unavailable) Stream(context.Context, Request, func(string) error) (string, error) {
+	return "", u.err
+}
+
+func getenv(key, fallback string) string {
+	if value := os.Getenv(key); value != "" {
+		return value
+	}
+	return fallback
+}
@@ -0,0 +1,122 @@
+package llm
+
+import (
+	"context"
+	"errors"
+	"io"
+	"math"
+	"strings"
+
+	openai "github.com/sashabaranov/go-openai"
+)
+
+const (
+	defaultAzureAPIVersion = "2024-02-15-preview"
+	defaultOpenAIModel     = "gpt-4o"
+)
+
+// openAIModel speaks the OpenAI chat-completions API, which Azure OpenAI and most local
+// model servers (Ollama, vLLM, llama.cpp, LM Studio) implement too
+type openAIModel struct {
+	client *openai.Client
+	model  string // Model name, or the deployment name on Azure
+}
+
+// NewAzure returns an Azure OpenAI deployment
+func NewAzure(endpoint, apiKey, deployment, apiVersion string) LLM {
+	cfg := openai.DefaultAzureConfig(apiKey, endpoint)
+	cfg.APIVersion = apiVersion
+	// The deployment name is used as is; the default mapper strips dots from it
+	cfg.AzureModelMapperFunc = func(model string) string { return model }
+	return &openAIModel{client: openai.NewClientWithConfig(cfg), model: deployment}
+}
+
+// NewOpenAI returns a model on the public OpenAI API
+func NewOpenAI(apiKey, model string) LLM {
+	return &openAIModel{client: openai.NewClient(apiKey), model: model}
+}
+
+// NewLocal returns a model on an OpenAI-compatible server, e.g. http://localhost:11434/v1
+func NewLocal(baseURL, model, apiKey string) LLM {
+	cfg := openai.DefaultConfig(apiKey)
+	cfg.BaseURL = strings.TrimSuffix(baseURL, "/")
+	return &openAIModel{client: openai.NewClientWithConfig(cfg), model: model}
+}
+
+func (m *openAIModel) request(req Request) openai.ChatCompletionRequest {
+	messages := make([]openai.ChatCompletionMessage, len(req.Messages))
+	for i, msg := range req.Messages {
+		messages[i] = openai.ChatCompletionMessage{Role: msg.Role, Content: msg.Content}
+	}
+	model := m.model
+	if req.Model != "" {
+		model = req.Model
+	}
+	r := openai.ChatCompletionRequest{
+		Model:     model,
+		Messages:  messages,
+		MaxTokens: req.MaxTokens,
+	}
+	if req.Temperature != nil {
+		r.Temperature = *req.Temperature
+		// 🌡️ The client drops a zero temperature from the request; the smallest one stands in for it
+		if r.Temperature == 0 {
+			r.Temperature = math.SmallestNonzeroFloat32
+		}
+	}
+	return r
+}
+
+func (m *openAIModel) complete(ctx context.Context, r openai.ChatCompletionRequest) (string, error) {
+	resp, err := m.client.CreateChatCompletion(ctx, r)
+	if err != nil {
+		return "", err
+	}
+	if len(resp.Choices) == 0 || resp.Choices[0].Message.Content == "" {
+		return "", ErrEmptyResponse
+	}
+	return resp.Choices[0].Message.Content, nil
+}
+
+func (m *openAIModel) Chat(ctx context.Context, req Request) (string, error) {
+	return m.complete(ctx, m.request(req))
+}
+
+func (m *openAIModel) ChatJSON(ctx context.Context, req Request) (string, error) {
+	r := m.request(req)
+	r.ResponseFormat = &openai.ChatCompletionResponseFormat{Type: openai.ChatCompletionResponseFormatTypeJSONObject}
+	return m.complete(ctx, r)
+}
+
+func (m *openAIModel) Stream(ctx context.Context, req Request, onDelta func(delta string) error) (string, error) {
+	r := m.request(req)
+	r.Stream = true
+	stream, err := m.client.CreateChatCompletionStream(ctx, r)
+	if err != nil {
+		return "", err
+	}
+	defer stream.Close()
+
+	var reply strings.Builder
+	for {
+		chunk, err := stream.Recv()
+		if errors.Is(err, io.EOF) {
+			break
+		}
+		if err != nil {
+			return reply.String(), err
+		}
+		if len(chunk.Choices) == 0 || chunk.Choices[0].Delta.Content == "" {
+			continue
+		}
+		delta := chunk.Choices[0].Delta.Content
+		reply.WriteString(delta)
+		if err := onDelta(delta); err != nil {
+			return reply.String(), err
+		}
+	}
+	if reply.Len() == 0 {
+		return "", ErrEmptyResponse
+	}
+	return reply.String(), nil
+}
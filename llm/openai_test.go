@@ -0,0 +1,81 @@
+package llm
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"io"
+	"math"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+func TestOpenAIEmptyResponses(t *testing.T) {
+	tests := []struct {
+		name string
+		body string
+	}{
+		{"no choices", `{"id":"x","object":"chat.completion","choices":[]}`},
+		{"empty content", `{"id":"x","object":"chat.completion","choices":[{"index":0,"message":{"role":"assistant","content":""}}]}`},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				w.Header().Set("Content-Type", "application/json")
+				io.WriteString(w, tt.body)
+			}))
+			defer srv.Close()
+
+			model := NewLocal(srv.URL+"/v1", "local", "")
+			req := Request{Messages: []Message{User("Score this.")}}
+			if _, err := model.Chat(context.Background(), req); !errors.Is(err, ErrEmptyResponse) {
+				t.Errorf("Chat error = %v, want ErrEmptyResponse", err)
+			}
+
+			// An empty reply is the provider's problem, not a malformed one to repair
+			var out struct{}
+			err := ChatStructured(context.Background(), model, req, Schema{Name: "s"}, 2, &out)
+			var outErr *StructuredOutputError
+			if !errors.As(err, &outErr) || !errors.Is(err, ErrEmptyResponse) || outErr.Attempts != 1 {
+				t.Errorf("ChatStructured error = %v, want ErrEmptyResponse after one attempt", err)
+			}
+		})
+	}
+}
+
+func TestOpenAITemperature(t *testing.T) {
+	zero, warm := float32(0), float32(0.7)
+	tests := []struct {
+		name        string
+		temperature *float32
+		want        float64 // -1 when the request should leave it out
+	}{
+		{"provider default", nil, -1},
+		{"zero", &zero, 0},
+		{"set", &warm, 0.7},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var sent map[string]interface{}
+			srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				json.NewDecoder(r.Body).Decode(&sent)
+				w.Header().Set("Content-Type", "application/json")
+				io.WriteString(w, `{"id":"x","object":"chat.completion","choices":[{"index":0,"message":{"role":"assistant","content":"ok"}}]}`)
+			}))
+			defer srv.Close()
+
+			model := NewLocal(srv.URL+"/v1", "local", "")
+			if _, err := model.Chat(context.Background(), Request{Messages: []Message{User("Hi")}, Temperature: tt.temperature}); err != nil {
+				t.Fatal(err)
+			}
+			got, ok := sent["temperature"].(float64)
+			switch {
+			case tt.want < 0 && ok:
+				t.Errorf("temperature %v sent, want the provider's default", got)
+			case tt.want >= 0 && (!ok || math.Abs(got-tt.want) > 1e-6):
+				t.Errorf("temperature = %v (sent %v), want %v", got, ok, tt.want)
+			}
+		})
+	}
+}
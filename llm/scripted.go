@@ -0,0 +1,56 @@
+package llm
+
+import (
+	"context"
+	"strings"
+	"sync"
+)
+
+// Scripted is a deterministic LLM for tests and offline development. It answers with its
+// replies in order; once they run out it repeats the last one ("{}" if it has none).
+// Every request is recorded so callers can check what was asked.
+type Scripted struct {
+	mu       sync.Mutex
+	replies  []string
+	next     int
+	Requests []Request
+}
+
+// NewScripted returns a Scripted model that gives these replies in order
+func NewScripted(replies ...string) *Scripted {
+	return &Scripted{replies: replies}
+}
+
+func (s *Scripted) reply(req Request) (string, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.Requests = append(s.Requests, req)
+	if len(s.replies) == 0 {
+		return "{}", nil
+	}
+	reply := s.replies[min(s.next, len(s.replies)-1)]
+	s.next++
+	return reply, nil
+}
+
+func (s *Scripted) Chat(_ context.Context, req Request) (string, error) {
+	return s.reply(req)
+}
+
+func (s *Scripted) ChatJSON(_ context.Context, req Request) (string, error) {
+	return s.reply(req)
+}
+
+// Stream delivers the scripted reply a word at a time
+func (s *Scripted) Stream(_ context.Context, req Request, onDelta func(delta string) error) (string, error) {
+	reply, err := s.reply(req)
+	if err != nil {
+		return "", err
+	}
+	for _, word := range strings.SplitAfter(reply, " ") {
+		if err := onDelta(word); err != nil {
+			return reply, err
+		}
+	}
+	return reply, nil
+}
@@ -0,0 +1,289 @@
+package llm
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"math"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// Field types a Schema understands
+const (
+	TypeInteger = "integer"
+	TypeString  = "string"
+	TypeBoolean = "boolean"
+)
+
+// Field describes one property of the JSON object a prompt asks for
+type Field struct {
+	Name        string
+	Type        string // TypeInteger, TypeString or TypeBoolean
+	Description string
+	Required    bool
+	Min, Max    int // Integers are clamped into [Min, Max] when Max > Min
+}
+
+// Schema is the JSON object a prompt expects back. Replies are checked and normalised
+// against it: numbers given as strings ("4", "4/5") are converted, integers are clamped
+// to their range and unknown properties are dropped.
+type Schema struct {
+	Name   string
+	Fields []Field
+}
+
+// JSONSchema returns the schema in JSON Schema form, for prompts and provider APIs
+func (s Schema) JSONSchema() map[string]interface{} {
+	properties := map[string]interface{}{}
+	required := []string{}
+	for _, f := range s.Fields {
+		p := map[string]interface{}{"type": f.Type}
+		if f.Description != "" {
+			p["description"] = f.Description
+		}
+		if f.Type == TypeInteger && f.Max > f.Min {
+			p["minimum"], p["maximum"] = f.Min, f.Max
+		}
+		properties[f.Name] = p
+		if f.Required {
+			required = append(required, f.Name)
+		}
+	}
+	return map[string]interface{}{
+		"title":                s.Name,
+		"type":                 "object",
+		"properties":           properties,
+		"required":             required,
+		"additionalProperties": false,
+	}
+}
+
+// Instructions is the prompt text telling the model exactly what to return
+func (s Schema) Instructions() string {
+	schema, _ := json.MarshalIndent(s.JSONSchema(), "", "  ")
+	return "Respond with only a JSON object (no markdown, no commentary) matching this JSON Schema:\n" + string(schema)
+}
+
+var (
+	// ErrNoJSON means the reply contained no JSON object at all
+	ErrNoJSON = errors.New("llm: no JSON object in reply")
+)
+
+// SchemaError lists what was wrong with a reply that did not match its schema
+type SchemaError struct {
+	Schema   string
+	Problems []string
+}
+
+func (e *SchemaError) Error() string {
+	return fmt.Sprintf("llm: reply does not match %s: %s", e.Schema, strings.Join(e.Problems, "; "))
+}
+
+// StructuredOutputError is returned when no usable reply came back within the allowed
+// attempts. Err is the last problem: ErrNoJSON, a *SchemaError or the provider's error.
+type StructuredOutputError struct {
+	Schema   string
+	Attempts int
+	Raw      string // Last reply, for logs
+	Err      error
+}
+
+func (e *StructuredOutputError) Error() string {
+	return fmt.Sprintf("llm: no valid %s after %d attempt(s): %v", e.Schema, e.Attempts, e.Err)
+}
+
+func (e *StructuredOutputError) Unwrap() error { return e.Err }
+
+// ChatStructured asks for a JSON reply matching schema and decodes it into out (a pointer
+// to a struct whose json tags match the field names). A reply that can't be used is sent
+// back to the model with what was wrong, at most maxRepairs times.
+func ChatStructured(ctx context.Context, model LLM, req Request, schema Schema, maxRepairs int, out interface{}) error {
+	messages := append([]Message(nil), req.Messages...)
+	var raw string
+	var lastErr error
+
+	for attempt := 1; attempt <= maxRepairs+1; attempt++ {
+		req.Messages = messages
+		reply, err := model.ChatJSON(ctx, req)
+		if err != nil {
+			// Provider failures aren't the model's fault; retrying with a repair prompt won't help
+			return &StructuredOutputError{Schema: schema.Name, Attempts: attempt, Raw: raw, Err: err}
+		}
+		raw = reply
+
+		normalized, err := schema.Parse(reply)
+		if err == nil {
+			return json.Unmarshal(normalized, out)
+		}
+		lastErr = err
+
+		// 🔧 Show the model its reply and what was wrong with it, and ask again
+		messages = append(messages,
+			Message{Role: RoleAssistant, Content: reply},
+			User("That reply can't be used: "+err.Error()+". "+schema.Instructions()),
+		)
+	}
+	return &StructuredOutputError{Schema: schema.Name, Attempts: maxRepairs + 1, Raw: raw, Err: lastErr}
+}
+
+// Parse extracts the JSON object from a reply and checks it against the schema,
+// returning the normalised object
+func (s Schema) Parse(reply string) ([]byte, error) {
+	obj, err := ExtractJSON(reply)
+	if err != nil {
+		return nil, err
+	}
+	var fields map[string]interface{}
+	if err := json.Unmarshal([]byte(obj), &fields); err != nil {
+		return nil, &SchemaError{Schema: s.Name, Problems: []string{"not a JSON object: " + err.Error()}}
+	}
+
+	normalized := map[string]interface{}{}
+	var problems []string
+	for _, f := range s.Fields {
+		value, ok := lookup(fields, f.Name)
+		if !ok || value == nil {
+			if f.Required {
+				problems = append(problems, fmt.Sprintf("%q is missing", f.Name))
+			}
+			continue
+		}
+		v, err := f.coerce(value)
+		if err != nil {
+			problems = append(problems, fmt.Sprintf("%q %v", f.Name, err))
+			continue
+		}
+		normalized[f.Name] = v
+	}
+	if len(problems) > 0 {
+		sort.Strings(problems)
+		return nil, &SchemaError{Schema: s.Name, Problems: problems}
+	}
+	return json.Marshal(normalized)
+}
+
+// lookup finds a property by name, ignoring case and underscores ("conflict_resolution")
+func lookup(fields map[string]interface{}, name string) (interface{}, bool) {
+	if v, ok := fields[name]; ok {
+		return v, true
+	}
+	want := strings.ToLower(strings.ReplaceAll(name, "_", ""))
+	for k, v := range fields {
+		if strings.ToLower(strings.ReplaceAll(k, "_", "")) == want {
+			return v, true
+		}
+	}
+	return nil, false
+}
+
+func (f Field) coerce(value interface{}) (interface{}, error) {
+	switch f.Type {
+	case TypeInteger:
+		n, err := toNumber(value)
+		if err != nil {
+			return nil, err
+		}
+		i := int(math.Round(n))
+		if f.Max > f.Min {
+			i = min(max(i, f.Min), f.Max)
+		}
+		return i, nil
+	case TypeBoolean:
+		switch v := value.(type) {
+		case bool:
+			return v, nil
+		case string:
+			if b, err := strconv.ParseBool(strings.TrimSpace(v)); err == nil {
+				return b, nil
+			}
+		}
+		return nil, errors.New("must be true or false")
+	default:
+		switch v := value.(type) {
+		case string:
+			return strings.TrimSpace(v), nil
+		case float64, bool:
+			return fmt.Sprint(v), nil
+		}
+		return nil, errors.New("must be a string")
+	}
+}
+
+// toNumber accepts JSON numbers and numeric strings such as "4", "4.5" or "4/5"
+func toNumber(value interface{}) (float64, error) {
+	switch v := value.(type) {
+	case float64:
+		return v, nil
+	case string:
+		s := strings.TrimSpace(v)
+		if i := strings.Index(s, "/"); i >= 0 {
+			s = strings.TrimSpace(s[:i])
+		}
+		if n, err := strconv.ParseFloat(s, 64); err == nil {
+			return n, nil
+		}
+	}
+	return 0, errors.New("must be a number")
+}
+
+// ExtractJSON finds the JSON object in a model reply, tolerating markdown code fences and
+// prose before or after it
+func ExtractJSON(reply string) (string, error) {
+	text := strings.TrimSpace(reply)
+	if i := strings.Index(text, "```"); i >= 0 {
+		fenced := text[i+3:]
+		if nl := strings.Index(fenced, "\n"); nl >= 0 {
+			fenced = fenced[nl+1:] // Drop the language tag line
+		}
+		if end := strings.Index(fenced, "```"); end >= 0 {
+			fenced = fenced[:end]
+		}
+		if obj, ok := firstObject(fenced); ok {
+			return obj, nil
+		}
+	}
+	if obj, ok := firstObject(text); ok {
+		return obj, nil
+	}
+	return "", ErrNoJSON
+}
+
+// firstObject returns the first balanced {...} in text that parses as JSON
+func firstObject(text string) (string, bool) {
+	for start := strings.Index(text, "{"); start >= 0; {
+		depth, inString, escaped := 0, false, false
+	scan:
+		for i := start; i < len(text); i++ {
+			c := text[i]
+			switch {
+			case escaped:
+				escaped = false
+			case c == '\\' && inString:
+				escaped = true
+			case c == '"':
+				inString = !inString
+			case inString:
+			case c == '{':
+				depth++
+			case c == '}':
+				depth--
+				if depth == 0 {
+					candidate := text[start : i+1]
+					if json.Valid([]byte(candidate)) {
+						return candidate, true
+					}
+					break scan // Not valid JSON; try the next '{'
+				}
+			}
+		}
+		next := strings.Index(text[start+1:], "{")
+		if next < 0 {
+			break
+		}
+		start += next + 1
+	}
+	return "", false
+}
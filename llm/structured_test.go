@@ -0,0 +1,245 @@
+package llm
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+// scoreSchema mirrors the communication score: five areas on the 1–5 rubric and a summary
+var scoreSchema = Schema{
+	Name: "communication_score",
+	Fields: []Field{
+		{Name: "empathy", Type: TypeInteger, Required: true, Min: 1, Max: 5},
+		{Name: "listening", Type: TypeInteger, Required: true, Min: 1, Max: 5},
+		{Name: "respect", Type: TypeInteger, Required: true, Min: 1, Max: 5},
+		{Name: "clarity", Type: TypeInteger, Required: true, Min: 1, Max: 5},
+		{Name: "conflictResolution", Type: TypeInteger, Required: true, Min: 1, Max: 5},
+		{Name: "summary", Type: TypeString, Required: true},
+		{Name: "flagged", Type: TypeBoolean},
+	},
+}
+
+type score struct {
+	Empathy            int    `json:"empathy"`
+	Listening          int    `json:"listening"`
+	Respect            int    `json:"respect"`
+	Clarity            int    `json:"clarity"`
+	ConflictResolution int    `json:"conflictResolution"`
+	Summary            string `json:"summary"`
+	Flagged            bool   `json:"flagged"`
+}
+
+const validScore = `{"empathy":4,"listening":3,"respect":5,"clarity":4,"conflictResolution":2,"summary":"Tense but honest."}`
+
+func TestExtractJSON(t *testing.T) {
+	tests := []struct {
+		name  string
+		reply string
+		want  string
+	}{
+		{"bare object", `{"a":1}`, `{"a":1}`},
+		{"surrounding whitespace", "\n  {\"a\":1}  \n", `{"a":1}`},
+		{"json fence", "```json\n{\"a\":1}\n```", `{"a":1}`},
+		{"untagged fence", "```\n{\"a\":1}\n```", `{"a":1}`},
+		{"fence after prose", "Here you go:\n```json\n{\"a\":1}\n```\nHope that helps!", `{"a":1}`},
+		{"leading prose", `Sure! Here is the score: {"a":1}`, `{"a":1}`},
+		{"trailing prose", `{"a":1} Let me know if you need more.`, `{"a":1}`},
+		{"nested object", `Result: {"a":{"b":[1,2]}} done`, `{"a":{"b":[1,2]}}`},
+		{"braces inside strings", `{"summary":"they said \"{ok}\" twice"}`, `{"summary":"they said \"{ok}\" twice"}`},
+		{"invalid braces before the object", `Scores {like this} follow: {"a":1}`, `{"a":1}`},
+		{"unclosed fence", "```json\n{\"a\":1}", `{"a":1}`},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := ExtractJSON(tt.reply)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if got != tt.want {
+				t.Errorf("ExtractJSON = %s, want %s", got, tt.want)
+			}
+		})
+	}
+
+	for _, reply := range []string{"", "I can't score this conversation.", "```\nnope\n```", `{"a":`, `[1,2,3]`} {
+		if got, err := ExtractJSON(reply); !errors.Is(err, ErrNoJSON) {
+			t.Errorf("ExtractJSON(%q) = (%s, %v), want ErrNoJSON", reply, got, err)
+		}
+	}
+}
+
+func TestSchemaParse(t *testing.T) {
+	base := score{Empathy: 4, Listening: 3, Respect: 5, Clarity: 4, ConflictResolution: 2, Summary: "Tense but honest."}
+	with := func(change func(s *score)) score {
+		s := base
+		change(&s)
+		return s
+	}
+
+	tests := []struct {
+		name     string
+		reply    string
+		want     score
+		problems []string // Expected SchemaError problems; nil when the reply is usable
+	}{
+		{"valid", validScore, base, nil},
+		{"fenced with prose", "Here's my assessment:\n```json\n" + validScore + "\n```", base, nil},
+		{"numbers as strings", `{"empathy":"4","listening":"3","respect":"5","clarity":"4","conflictResolution":"2","summary":"Tense but honest."}`, base, nil},
+		{"fractions", `{"empathy":"4/5","listening":"3 / 5","respect":5,"clarity":4,"conflictResolution":"2/5","summary":"Tense but honest."}`, base, nil},
+		{"clamped to the rubric", `{"empathy":9,"listening":0,"respect":-3,"clarity":"10/10","conflictResolution":5,"summary":"x"}`,
+			score{Empathy: 5, Listening: 1, Respect: 1, Clarity: 5, ConflictResolution: 5, Summary: "x"}, nil},
+		{"rounded", `{"empathy":3.6,"listening":2.4,"respect":5,"clarity":4,"conflictResolution":2,"summary":"Tense but honest."}`,
+			with(func(s *score) { s.Empathy, s.Listening = 4, 2 }), nil},
+		{"snake case and capitals", `{"Empathy":4,"listening":3,"RESPECT":5,"clarity":4,"conflict_resolution":2,"summary":"Tense but honest."}`, base, nil},
+		{"unknown properties dropped", `{"empathy":4,"listening":3,"respect":5,"clarity":4,"conflictResolution":2,"summary":"Tense but honest.","mood":"sad"}`, base, nil},
+		{"summary trimmed", `{"empathy":4,"listening":3,"respect":5,"clarity":4,"conflictResolution":2,"summary":"  Tense but honest.\n"}`, base, nil},
+		{"optional boolean as a string", `{"empathy":4,"listening":3,"respect":5,"clarity":4,"conflictResolution":2,"summary":"Tense but honest.","flagged":"true"}`,
+			with(func(s *score) { s.Flagged = true }), nil},
+		{"missing fields", `{"empathy":4,"respect":5,"clarity":4,"summary":"Tense but honest."}`, score{},
+			[]string{`"conflictResolution" is missing`, `"listening" is missing`}},
+		{"null counts as missing", `{"empathy":null,"listening":3,"respect":5,"clarity":4,"conflictResolution":2,"summary":"x"}`, score{},
+			[]string{`"empathy" is missing`}},
+		{"words instead of numbers", `{"empathy":"high","listening":3,"respect":5,"clarity":4,"conflictResolution":[2],"summary":"x"}`, score{},
+			[]string{`"conflictResolution" must be a number`, `"empathy" must be a number`}},
+		{"wrong boolean", `{"empathy":4,"listening":3,"respect":5,"clarity":4,"conflictResolution":2,"summary":"x","flagged":"maybe"}`, score{},
+			[]string{`"flagged" must be true or false`}},
+		{"summary not a string", `{"empathy":4,"listening":3,"respect":5,"clarity":4,"conflictResolution":2,"summary":{"tone":"calm"}}`, score{},
+			[]string{`"summary" must be a string`}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			normalized, err := scoreSchema.Parse(tt.reply)
+			if tt.problems != nil {
+				var schemaErr *SchemaError
+				if !errors.As(err, &schemaErr) {
+					t.Fatalf("Parse error = %v, want a SchemaError", err)
+				}
+				if !reflect.DeepEqual(schemaErr.Problems, tt.problems) {
+					t.Errorf("problems = %q, want %q", schemaErr.Problems, tt.problems)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			var got score
+			if err := json.Unmarshal(normalized, &got); err != nil {
+				t.Fatal(err)
+			}
+			if got != tt.want {
+				t.Errorf("Parse = %+v, want %+v", got, tt.want)
+			}
+		})
+	}
+
+	if _, err := scoreSchema.Parse("no JSON here"); !errors.Is(err, ErrNoJSON) {
+		t.Errorf("Parse without JSON = %v, want ErrNoJSON", err)
+	}
+}
+
+// failing is a provider that is down
+type failing struct{ calls int }
+
+var errProviderDown = errors.New("provider down")
+
+func (f *failing) Chat(context.Context, Request) (string, error) { return "", errProviderDown }
+func (f *failing) ChatJSON(context.Context, Request) (string, error) {
+	f.calls++
+	return "", errProviderDown
+}
+func (f *failing) Stream(context.Context, Request, func(string) error) (string, error) {
+	return "", errProviderDown
+}
+
+func TestChatStructured(t *testing.T) {
+	req := Request{Messages: []Message{System("You score conversations."), User("Score this.")}}
+
+	tests := []struct {
+		name       string
+		replies    []string
+		maxRepairs int
+		want       score
+		wantCalls  int
+		wantErr    bool // A StructuredOutputError wrapping a SchemaError or ErrNoJSON
+	}{
+		{"valid first time", []string{validScore}, 2,
+			score{Empathy: 4, Listening: 3, Respect: 5, Clarity: 4, ConflictResolution: 2, Summary: "Tense but honest."}, 1, false},
+		{"repaired after a missing field", []string{`{"empathy":4}`, validScore}, 2,
+			score{Empathy: 4, Listening: 3, Respect: 5, Clarity: 4, ConflictResolution: 2, Summary: "Tense but honest."}, 2, false},
+		{"repaired after prose", []string{"I'd rate them fairly well overall.", validScore}, 1,
+			score{Empathy: 4, Listening: 3, Respect: 5, Clarity: 4, ConflictResolution: 2, Summary: "Tense but honest."}, 2, false},
+		{"gives up after the repairs", []string{`{"empathy":4}`, `{"empathy":4}`, `{"empathy":4}`, validScore}, 2, score{}, 3, true},
+		{"no repairs allowed", []string{"nope", validScore}, 0, score{}, 1, true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			model := NewScripted(tt.replies...)
+			var got score
+			err := ChatStructured(context.Background(), model, req, scoreSchema, tt.maxRepairs, &got)
+
+			if len(model.Requests) != tt.wantCalls {
+				t.Errorf("model called %d times, want %d", len(model.Requests), tt.wantCalls)
+			}
+			if tt.wantErr {
+				var outErr *StructuredOutputError
+				if !errors.As(err, &outErr) {
+					t.Fatalf("error = %v, want a StructuredOutputError", err)
+				}
+				if outErr.Attempts != tt.wantCalls || outErr.Raw != tt.replies[tt.wantCalls-1] {
+					t.Errorf("error = %+v, want %d attempts and the last reply", outErr, tt.wantCalls)
+				}
+				var schemaErr *SchemaError
+				if !errors.As(err, &schemaErr) && !errors.Is(err, ErrNoJSON) {
+					t.Errorf("error wraps %v, want the reply's problem", outErr.Err)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			if got != tt.want {
+				t.Errorf("decoded %+v, want %+v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestChatStructuredRepairPrompt(t *testing.T) {
+	model := NewScripted(`{"empathy":"lots"}`, validScore)
+	req := Request{Messages: []Message{User("Score this.")}}
+	var got score
+	if err := ChatStructured(context.Background(), model, req, scoreSchema, 1, &got); err != nil {
+		t.Fatal(err)
+	}
+
+	// The retry carries the bad reply and what was wrong with it
+	repair := model.Requests[1].Messages
+	if len(repair) != 3 || repair[1].Role != RoleAssistant || repair[1].Content != `{"empathy":"lots"}` {
+		t.Fatalf("repair conversation = %+v", repair)
+	}
+	for _, want := range []string{`"empathy" must be a number`, `"listening" is missing`, "JSON Schema"} {
+		if !strings.Contains(repair[2].Content, want) {
+			t.Errorf("repair prompt doesn't mention %s: %s", want, repair[2].Content)
+		}
+	}
+	// The caller's request isn't modified
+	if len(req.Messages) != 1 || len(model.Requests[0].Messages) != 1 {
+		t.Error("the repair leaked into the original request")
+	}
+}
+
+func TestChatStructuredProviderError(t *testing.T) {
+	model := &failing{}
+	var got score
+	err := ChatStructured(context.Background(), model, Request{Messages: []Message{User("Score this.")}}, scoreSchema, 3, &got)
+	if !errors.Is(err, errProviderDown) {
+		t.Fatalf("error = %v, want the provider's error", err)
+	}
+	if model.calls != 1 {
+		t.Errorf("provider called %d times, want no retries", model.calls)
+	}
+}
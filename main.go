@@ -7,13 +7,19 @@
 package main
 
 import (
+	"context"
+	"log"
 	"os"
+	"os/signal"
+	"syscall"
+	"time"
 
 	"github.com/gofiber/adaptor/v2"
 	"github.com/gofiber/fiber/v2"
 	httpSwagger "github.com/swaggo/http-swagger"
 
 	"mend/config"
+	"mend/controllers"
 	"mend/database"
 	"mend/routes"
 
@@ -26,8 +32,13 @@ func main() {
 
 	// Connect DB
 	database.ConnectDB()
+	database.MigrateLegacyPartnerLinks()
+	database.MigrateSessionStatus()
+	database.MigrateEmailVerified()
+	database.EnsureIndexes()
 
 	// Init app
+	controllers.SetupSockets()
 	app := fiber.New()
 
 	// ✅ This is the only correct way to serve Swagger UI in Fiber
@@ -41,5 +52,28 @@ func main() {
 	if port == "" {
 		port = "5000"
 	}
-	app.Listen(":" + port)
+	ctx, stop := signal.NotifyContext(context.Background(), syscall.SIGINT, syscall.SIGTERM)
+	defer stop()
+
+	go func() {
+		if err := app.Listen(":" + port); err != nil {
+			log.Println("❌ Server stopped:", err)
+			stop()
+		}
+	}()
+	<-ctx.Done()
+
+	// 🛑 Graceful shutdown: tell socket clients we're going away (after flushing what they're
+	// owed), stop taking requests, then close the database
+	log.Println("Shutting down...")
+	shutdownCtx, cancel := context.WithTimeout(context.Background(), 15*time.Second)
+	defer cancel()
+
+	if err := controllers.ShutdownSockets(shutdownCtx); err != nil {
+		log.Println("⚠️ Some sockets did not close cleanly:", err)
+	}
+	if err := app.ShutdownWithContext(shutdownCtx); err != nil {
+		log.Println("⚠️ HTTP shutdown:", err)
+	}
+	database.DisconnectDB(shutdownCtx)
 }
@@ -0,0 +1,76 @@
+package middleware
+
+import (
+	"context"
+	"strings"
+	"time"
+
+	"mend/database"
+	"mend/utils"
+
+	"github.com/gofiber/fiber/v2"
+	"go.mongodb.org/mongo-driver/bson"
+)
+
+const (
+	userIDKey    = "userId"
+	sessionIDKey = "authSessionId"
+)
+
+// RequireAuth resolves the caller from a bearer access token and rejects anonymous requests.
+// WebSocket clients can't set headers on the upgrade request, so `?token=` is accepted too.
+func RequireAuth(c *fiber.Ctx) error {
+	token := bearerToken(c)
+	if token == "" {
+		return c.Status(fiber.StatusUnauthorized).JSON(fiber.Map{"error": "Missing access token"})
+	}
+
+	claims, err := utils.ParseToken(token, utils.TokenTypeAccess)
+	if err != nil {
+		return c.Status(fiber.StatusUnauthorized).JSON(fiber.Map{"error": "Invalid or expired access token"})
+	}
+
+	// Access tokens die with their device session (logout, logout-all, refresh token reuse)
+	if !authSessionActive(claims.SessionID, claims.Subject) {
+		return c.Status(fiber.StatusUnauthorized).JSON(fiber.Map{"error": "Session has been revoked"})
+	}
+
+	c.Locals(userIDKey, claims.Subject)
+	c.Locals(sessionIDKey, claims.SessionID)
+	return c.Next()
+}
+
+// UserID returns the authenticated caller's ID (empty if RequireAuth didn't run)
+func UserID(c *fiber.Ctx) string {
+	id, _ := c.Locals(userIDKey).(string)
+	return id
+}
+
+// SessionID returns the auth session (device) the caller's access token belongs to
+func SessionID(c *fiber.Ctx) string {
+	id, _ := c.Locals(sessionIDKey).(string)
+	return id
+}
+
+func authSessionActive(sessionId, userId string) bool {
+	if sessionId == "" {
+		return false
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	count, err := database.GetCollection("authSessions").CountDocuments(ctx, bson.M{
+		"_id":       sessionId,
+		"userId":    userId,
+		"revokedAt": bson.M{"$exists": false},
+	})
+	return err == nil && count > 0
+}
+
+func bearerToken(c *fiber.Ctx) string {
+	header := c.Get(fiber.HeaderAuthorization)
+	if len(header) > 7 && strings.EqualFold(header[:7], "Bearer ") {
+		return strings.TrimSpace(header[7:])
+	}
+	return c.Query("token")
+}
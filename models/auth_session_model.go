@@ -0,0 +1,32 @@
+package models
+
+// Why a device session was revoked
+const (
+	RevokeReasonLogout            = "logout"              // The device logged out
+	RevokeReasonLogoutAll         = "logout_all"          // The user logged out everywhere
+	RevokeReasonRefreshTokenReuse = "refresh_token_reuse" // A rotated refresh token was presented again
+	RevokeReasonPasswordReset     = "password_reset"      // The password was reset
+)
+
+// AuthSession is one logged-in device. Every refresh token issued to that device
+// belongs to the same family, identified by the session ID.
+type AuthSession struct {
+	ID           string `json:"id" bson:"_id"`                                        // UUID, also the token family ID
+	UserID       string `json:"userId" bson:"userId"`                                 // Owner
+	UserAgent    string `json:"userAgent,omitempty" bson:"userAgent,omitempty"`       // Device hint
+	IP           string `json:"ip,omitempty" bson:"ip,omitempty"`                     // Login IP
+	CreatedAt    int64  `json:"createdAt" bson:"createdAt"`                           // Login time
+	LastUsedAt   int64  `json:"lastUsedAt" bson:"lastUsedAt"`                         // Last refresh
+	RevokedAt    int64  `json:"revokedAt,omitempty" bson:"revokedAt,omitempty"`       // Set on logout/revocation
+	RevokeReason string `json:"revokeReason,omitempty" bson:"revokeReason,omitempty"` // See RevokeReason*
+}
+
+// RefreshToken is a single-use refresh token; only its hash is stored
+type RefreshToken struct {
+	ID        string `json:"-" bson:"_id"`                             // SHA-256 of the token
+	FamilyID  string `json:"familyId" bson:"familyId"`                 // AuthSession.ID
+	UserID    string `json:"userId" bson:"userId"`                     // Owner
+	CreatedAt int64  `json:"createdAt" bson:"createdAt"`               // Issue time
+	ExpiresAt int64  `json:"expiresAt" bson:"expiresAt"`               // Unix expiry
+	UsedAt    int64  `json:"usedAt,omitempty" bson:"usedAt,omitempty"` // Set once rotated
+}
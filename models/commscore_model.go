@@ -9,5 +9,7 @@ type CommunicationScore struct {
 	Clarity            int    `json:"clarity" bson:"clarity"`
 	ConflictResolution int    `json:"conflictResolution" bson:"conflictResolution"`
 	Summary            string `json:"summary,omitempty" bson:"summary,omitempty"`
+	Interruptions      int    `json:"interruptions" bson:"interruptions"` // Measured from overlapping speech
 	CreatedAt          int64  `json:"createdAt" bson:"createdAt"`
+	PromptVersion      string `json:"promptVersion,omitempty" bson:"promptVersion,omitempty"` // Prompt an AI score came from, e.g. "score@v1"
 }
@@ -0,0 +1,22 @@
+package models
+
+const (
+	InviteStatusPending  = "pending"
+	InviteStatusAccepted = "accepted"
+	InviteStatusDeclined = "declined"
+	InviteStatusExpired  = "expired"
+	InviteStatusRevoked  = "revoked"
+)
+
+// PartnerInvite is a one-time code a user shares with their partner to link accounts
+type PartnerInvite struct {
+	ID           string `json:"id" bson:"_id"`                                        // UUID
+	Code         string `json:"code" bson:"code"`                                     // Normalised, e.g. 7KQ2-M9XD
+	InviterID    string `json:"inviterId" bson:"inviterId"`                           // Who created it
+	InviteeEmail string `json:"inviteeEmail,omitempty" bson:"inviteeEmail,omitempty"` // Optional addressee
+	InviteeID    string `json:"inviteeId,omitempty" bson:"inviteeId,omitempty"`       // Who redeemed/declined it
+	Status       string `json:"status" bson:"status"`                                 // pending/accepted/declined/expired/revoked
+	CreatedAt    int64  `json:"createdAt" bson:"createdAt"`                           // Unix time
+	ExpiresAt    int64  `json:"expiresAt" bson:"expiresAt"`                           // Unix time
+	RespondedAt  int64  `json:"respondedAt,omitempty" bson:"respondedAt,omitempty"`   // Accept/decline/revoke time
+}
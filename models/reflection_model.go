@@ -6,4 +6,6 @@ type Reflection struct {
 	UserID    string `json:"userId" bson:"userId"`       // Who submitted
 	Text      string `json:"text" bson:"text"`           // Reflection content
 	Timestamp int64  `json:"timestamp" bson:"timestamp"` // Unix time
+	// Prompt an AI-written reflection came from, e.g. "reflection@v1"
+	PromptVersion string `json:"promptVersion,omitempty" bson:"promptVersion,omitempty"`
 }
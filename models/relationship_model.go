@@ -0,0 +1,42 @@
+package models
+
+const (
+	RelationshipStatusActive = "active"
+	RelationshipStatusEnded  = "ended"
+)
+
+// RelationshipSettings are preferences shared by both partners
+type RelationshipSettings struct {
+	AIInterjections    bool `json:"aiInterjections" bson:"aiInterjections"`       // Therapist AI may reply, warn and call time-outs in live sessions
+	CoolingOffSeconds  int  `json:"coolingOffSeconds" bson:"coolingOffSeconds"`   // Default time-out length, 0 = server default
+	MaxSpeakingSeconds int  `json:"maxSpeakingSeconds" bson:"maxSpeakingSeconds"` // How long one partner may hold the floor, 0 = server default
+}
+
+// Relationship is the couple: the single source of truth for who is partnered with whom.
+// Each period two users are linked is its own record (its ID is the couple ID), so
+// re-linking later starts a fresh history.
+type Relationship struct {
+	ID          string   `json:"id" bson:"_id"`                                      // UUID, the couple ID
+	Members     []string `json:"members" bson:"members"`                             // The two user IDs
+	InitiatedBy string   `json:"initiatedBy" bson:"initiatedBy"`                     // Who sent the invite
+	Status      string   `json:"status" bson:"status"`                               // active / ended
+	StartedAt   int64    `json:"startedAt" bson:"startedAt"`                         // Unix time
+	EndedAt     int64    `json:"endedAt,omitempty" bson:"endedAt,omitempty"`         // Unix time
+	EndedBy     string   `json:"endedBy,omitempty" bson:"endedBy,omitempty"`         // Who unlinked
+	Anniversary string   `json:"anniversary,omitempty" bson:"anniversary,omitempty"` // YYYY-MM-DD, set by the couple
+
+	Settings RelationshipSettings `json:"settings" bson:"settings"`
+
+	// Per member: may the former partner still see this relationship's transcripts?
+	TranscriptVisibility map[string]bool `json:"transcriptVisibility" bson:"transcriptVisibility"`
+}
+
+// OtherMember returns the partner of userId in this relationship
+func (r Relationship) OtherMember(userId string) string {
+	for _, m := range r.Members {
+		if m != userId {
+			return m
+		}
+	}
+	return ""
+}
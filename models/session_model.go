@@ -1,19 +1,95 @@
 package models
 
+// Session lifecycle: scheduled → waiting_for_partner → active ⇄ paused → ended.
+// Any open session can be abandoned instead of ended, and an active one falls back to
+// waiting_for_partner when a partner has been disconnected for too long.
+const (
+	SessionStatusScheduled         = "scheduled"
+	SessionStatusWaitingForPartner = "waiting_for_partner"
+	SessionStatusActive            = "active"
+	SessionStatusPaused            = "paused"
+	SessionStatusEnded             = "ended"
+	SessionStatusAbandoned         = "abandoned"
+)
+
+// SessionOpenStatuses are the states in which a session still counts as the couple's current one
+var SessionOpenStatuses = []string{
+	SessionStatusScheduled,
+	SessionStatusWaitingForPartner,
+	SessionStatusActive,
+	SessionStatusPaused,
+}
+
+// sessionTransitions lists, per state, the states a session may move to next
+var sessionTransitions = map[string][]string{
+	SessionStatusScheduled:         {SessionStatusWaitingForPartner, SessionStatusAbandoned},
+	SessionStatusWaitingForPartner: {SessionStatusActive, SessionStatusAbandoned},
+	SessionStatusActive:            {SessionStatusPaused, SessionStatusWaitingForPartner, SessionStatusEnded, SessionStatusAbandoned},
+	SessionStatusPaused:            {SessionStatusActive, SessionStatusEnded, SessionStatusAbandoned},
+}
+
+// CanTransitionSession reports whether a session may move from one state to another
+func CanTransitionSession(from, to string) bool {
+	for _, next := range sessionTransitions[from] {
+		if next == to {
+			return true
+		}
+	}
+	return false
+}
+
 type Message struct {
-	SpeakerId string `json:"speakerId" bson:"speakerId"`
-	SessionId string `json:"sessionId" bson:"sessionId"`
-	Text      string `json:"text" bson:"text"`
-	Timestamp int64  `json:"timestamp" bson:"timestamp"`
+	SpeakerId   string `json:"speakerId" bson:"speakerId"`
+	SessionId   string `json:"sessionId" bson:"sessionId"`
+	Text        string `json:"text" bson:"text"`
+	Timestamp   int64  `json:"timestamp" bson:"timestamp"`
+	Seq         int64  `json:"seq" bson:"seq"`                                     // Per-session order, from 1
+	ClientMsgID string `json:"clientMsgId,omitempty" bson:"clientMsgId,omitempty"` // Sender's frame id, for de-duplication
+	Source      string `json:"source,omitempty" bson:"source,omitempty"`           // MessageSourceVoice for spoken messages, empty for chat
+	StartMs     int64  `json:"startMs,omitempty" bson:"startMs,omitempty"`         // Voice: speech start, Unix milliseconds
+	EndMs       int64  `json:"endMs,omitempty" bson:"endMs,omitempty"`             // Voice: speech end, Unix milliseconds
+	Flagged     bool   `json:"flagged,omitempty" bson:"flagged,omitempty"`         // The moderator warned about this message
+	// Prompt an AI message came from, e.g. "therapist_reply@v1"
+	PromptVersion string `json:"promptVersion,omitempty" bson:"promptVersion,omitempty"`
+}
+
+// MessageSourceVoice marks messages transcribed from the voice session
+const MessageSourceVoice = "voice"
+
+// SessionTransition records one state change and who caused it
+type SessionTransition struct {
+	From string `json:"from" bson:"from"`
+	To   string `json:"to" bson:"to"`
+	By   string `json:"by,omitempty" bson:"by,omitempty"` // User ID, "AI" for the moderator, empty when the server moved it
+	At   int64  `json:"at" bson:"at"`                     // Unix time
 }
 
 type Session struct {
-	ID        string             `json:"id" bson:"_id"`              // UUID
-	PartnerA  string             `json:"partnerA" bson:"partnerA"`   // User A
-	PartnerB  string             `json:"partnerB" bson:"partnerB"`   // User B
-	Messages  []Message          `json:"messages" bson:"messages"`   // Chat transcript
-	ScoreA    CommunicationScore `json:"scoreA" bson:"scoreA"`       // A's score
-	ScoreB    CommunicationScore `json:"scoreB" bson:"scoreB"`       // B's score
-	CreatedAt int64              `json:"createdAt" bson:"createdAt"` // Session time
-	Resolved  bool               `json:"resolved" bson:"resolved"`   // Has reflection happened
+	ID             string              `json:"id" bson:"_id"`                                            // UUID
+	PartnerA       string              `json:"partnerA" bson:"partnerA"`                                 // User A
+	PartnerB       string              `json:"partnerB" bson:"partnerB"`                                 // User B
+	RelationshipID string              `json:"relationshipId,omitempty" bson:"relationshipId,omitempty"` // Relationship period it belongs to
+	Status         string              `json:"status" bson:"status"`                                     // See SessionStatus*
+	Joined         []string            `json:"joined" bson:"joined"`                                     // Partners who have joined so far
+	Transitions    []SessionTransition `json:"transitions" bson:"transitions"`                           // State history, oldest first
+	ScheduledFor   int64               `json:"scheduledFor,omitempty" bson:"scheduledFor,omitempty"`     // Unix time, scheduled sessions only
+	Messages       []Message           `json:"messages" bson:"messages"`                                 // Chat transcript
+	LastSeq        int64               `json:"lastSeq" bson:"lastSeq"`                                   // Highest message seq handed out
+	ClientMsgIDs   []string            `json:"-" bson:"clientMsgIds,omitempty"`                          // Client frame ids already stored
+	Acked          map[string]int64    `json:"acked,omitempty" bson:"acked,omitempty"`                   // Per user: highest seq they confirmed
+	Interruptions  map[string]int      `json:"interruptions,omitempty" bson:"interruptions,omitempty"`   // Per user: times they cut in on their partner
+	ScoreA         CommunicationScore  `json:"scoreA" bson:"scoreA"`                                     // A's score
+	ScoreB         CommunicationScore  `json:"scoreB" bson:"scoreB"`                                     // B's score
+	CreatedAt      int64               `json:"createdAt" bson:"createdAt"`                               // Session time
+	StartedAt      int64               `json:"startedAt,omitempty" bson:"startedAt,omitempty"`           // Both partners joined
+	PausedUntil    int64               `json:"pausedUntil,omitempty" bson:"pausedUntil,omitempty"`       // End of the current cooling-off
+	EndedAt        int64               `json:"endedAt,omitempty" bson:"endedAt,omitempty"`               // Ended or abandoned
+}
+
+// OtherPartner returns the partner of userId in this session
+func (s Session) OtherPartner(userId string) string {
+	if s.PartnerA == userId {
+		return s.PartnerB
+	}
+	return s.PartnerA
 }
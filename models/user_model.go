@@ -3,17 +3,25 @@ package models
 import "time"
 
 type User struct {
-	ID             string    `json:"id" bson:"id"`
-	Name           string    `json:"name" bson:"name"`
-	Email          string    `json:"email" bson:"email"`
-	Password       string    `json:"password,omitempty" bson:"password,omitempty"`
-	Gender         string    `json:"gender,omitempty" bson:"gender,omitempty"`
-	Goals          []string  `json:"goals,omitempty" bson:"goals,omitempty"`
-	OtherGoal      string    `json:"otherGoal,omitempty" bson:"otherGoal,omitempty"`
-	Challenges     []string  `json:"challenges,omitempty" bson:"challenges,omitempty"`
-	OtherChallenge string    `json:"otherChallenge,omitempty" bson:"otherChallenge,omitempty"`
-	ColorCode      string    `json:"colorCode,omitempty" bson:"colorCode,omitempty"`
-	PartnerID      string    `json:"partnerId,omitempty" bson:"partnerId,omitempty"`
-	InvitedBy      string    `json:"invitedBy,omitempty" bson:"invitedBy,omitempty"`
-	CreatedAt      time.Time `json:"createdAt" bson:"createdAt"`
+	ID             string   `json:"id" bson:"id"`
+	Name           string   `json:"name" bson:"name"`
+	Email          string   `json:"email" bson:"email"`
+	EmailVerified  bool     `json:"emailVerified" bson:"emailVerified"`
+	Password       string   `json:"password,omitempty" bson:"password,omitempty"`
+	Gender         string   `json:"gender,omitempty" bson:"gender,omitempty"`
+	Goals          []string `json:"goals,omitempty" bson:"goals,omitempty"`
+	OtherGoal      string   `json:"otherGoal,omitempty" bson:"otherGoal,omitempty"`
+	Challenges     []string `json:"challenges,omitempty" bson:"challenges,omitempty"`
+	OtherChallenge string   `json:"otherChallenge,omitempty" bson:"otherChallenge,omitempty"`
+	ColorCode      string   `json:"colorCode,omitempty" bson:"colorCode,omitempty"`
+	PartnerID      string   `json:"partnerId,omitempty" bson:"-"` // Filled from the active relationship, never stored
+
+	// Two-factor auth (TOTP). Secrets and recovery codes never leave the server.
+	TwoFactorEnabled  bool     `json:"twoFactorEnabled" bson:"twoFactorEnabled"`
+	TwoFactorSecret   string   `json:"-" bson:"twoFactorSecret,omitempty"`   // AES-GCM encrypted
+	TwoFactorPending  string   `json:"-" bson:"twoFactorPending,omitempty"`  // Encrypted, awaiting first code
+	TwoFactorLastStep int64    `json:"-" bson:"twoFactorLastStep,omitempty"` // Last accepted TOTP step (replay guard)
+	RecoveryCodes     []string `json:"-" bson:"recoveryCodes,omitempty"`     // bcrypt hashes, single use
+
+	CreatedAt time.Time `json:"createdAt" bson:"createdAt"`
 }
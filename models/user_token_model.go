@@ -0,0 +1,16 @@
+package models
+
+const (
+	TokenPurposePasswordReset = "password_reset"
+	TokenPurposeEmailVerify   = "email_verify"
+)
+
+// UserToken is a single-use, expiring token mailed to a user; only its hash is stored
+type UserToken struct {
+	ID        string `json:"-" bson:"_id"`                             // SHA-256 of the token
+	UserID    string `json:"userId" bson:"userId"`                     // Owner
+	Purpose   string `json:"purpose" bson:"purpose"`                   // password_reset / email_verify
+	CreatedAt int64  `json:"createdAt" bson:"createdAt"`               // Issue time
+	ExpiresAt int64  `json:"expiresAt" bson:"expiresAt"`               // Unix expiry
+	UsedAt    int64  `json:"usedAt,omitempty" bson:"usedAt,omitempty"` // Set once redeemed
+}
@@ -0,0 +1,232 @@
+// Package prompts holds every prompt the backend sends to the model, as versioned templates
+// embedded from templates/. Each file is named <prompt>.<version>.tmpl and starts with a
+// header, followed by a "system" and a "user" template:
+//
+//	---
+//	description: What the prompt is for
+//	model: gpt-4o                  (optional; the provider's model otherwise)
+//	temperature: 0.7               (optional; the provider's default otherwise)
+//	variables: transcript, speaker
+//	---
+//	{{define "system"}}...{{end}}
+//	{{define "user"}}... {{.transcript}} ...{{end}}
+//
+// A prompt's active version is its highest one unless PROMPT_VERSION_<NAME> picks another,
+// e.g. PROMPT_VERSION_THERAPIST_REPLY=v1. Every stored AI output records the Prompt.ID it
+// was made with, so clinicians can compare revisions.
+package prompts
+
+import (
+	"bytes"
+	"embed"
+	"fmt"
+	"io/fs"
+	"log"
+	"os"
+	"path"
+	"sort"
+	"strconv"
+	"strings"
+	"text/template"
+
+	"mend/llm"
+)
+
+// Prompt names
+const (
+	TherapistReply  = "therapist_reply"
+	Moderation      = "moderation"
+	VoiceModeration = "voice_moderation"
+	Reflection      = "reflection"
+	Score           = "score"
+)
+
+// Vars are the values a prompt's variables are filled in with
+type Vars map[string]string
+
+// Prompt is one version of a prompt template
+type Prompt struct {
+	Name        string
+	Version     string
+	Description string
+	Model       string
+	Temperature *float32 // Nil when the header leaves it to the provider
+	Variables   []string
+
+	tmpl *template.Template
+}
+
+// ID names the prompt and version together, e.g. "score@v1"; it's what stored outputs record
+func (p *Prompt) ID() string { return p.Name + "@" + p.Version }
+
+// Render fills in the prompt and returns it as a request carrying the prompt's model and
+// temperature. Every declared variable must be given. Others are ignored, so a caller can
+// fill in what every version of a prompt needs and whichever one is active still renders.
+func (p *Prompt) Render(vars Vars) (llm.Request, error) {
+	var missing []string
+	for _, v := range p.Variables {
+		if _, ok := vars[v]; !ok {
+			missing = append(missing, fmt.Sprintf("%q", v))
+		}
+	}
+	if len(missing) > 0 {
+		return llm.Request{}, fmt.Errorf("prompts: %s: missing %s", p.ID(), strings.Join(missing, ", "))
+	}
+
+	req := llm.Request{Model: p.Model, Temperature: p.Temperature}
+	for _, part := range []struct{ name, role string }{{"system", llm.RoleSystem}, {"user", llm.RoleUser}} {
+		if p.tmpl.Lookup(part.name) == nil {
+			continue
+		}
+		var b bytes.Buffer
+		if err := p.tmpl.ExecuteTemplate(&b, part.name, vars); err != nil {
+			return llm.Request{}, fmt.Errorf("prompts: %s: %w", p.ID(), err)
+		}
+		req.Messages = append(req.Messages, llm.Message{Role: part.role, Content: strings.TrimSpace(b.String())})
+	}
+	return req, nil
+}
+
+//go:embed templates/*.tmpl
+var templateFiles embed.FS
+
+// registry holds every version of every prompt, by name then version
+var registry = mustLoad(templateFiles)
+
+// Get returns the active version of a prompt. It panics for a name with no templates, which
+// is a programming error.
+func Get(name string) *Prompt {
+	versions, ok := registry[name]
+	if !ok {
+		panic("prompts: no prompt named " + name)
+	}
+	latest := Versions(name)[len(versions)-1]
+
+	key := "PROMPT_VERSION_" + strings.ToUpper(name)
+	if want := os.Getenv(key); want != "" {
+		if p, ok := versions[want]; ok {
+			return p
+		}
+		log.Printf("⚠️ %s=%s is not a version of %s, using %s\n", key, want, name, latest)
+	}
+	return versions[latest]
+}
+
+// Lookup returns a specific version of a prompt
+func Lookup(name, version string) (*Prompt, bool) {
+	p, ok := registry[name][version]
+	return p, ok
+}
+
+// Versions lists a prompt's versions, oldest first
+func Versions(name string) []string {
+	versions := make([]string, 0, len(registry[name]))
+	for v := range registry[name] {
+		versions = append(versions, v)
+	}
+	sort.Slice(versions, func(i, j int) bool { return older(versions[i], versions[j]) })
+	return versions
+}
+
+// older orders versions numerically when they look like v1, v2, v10, and by name otherwise
+func older(a, b string) bool {
+	na, errA := strconv.Atoi(strings.TrimPrefix(a, "v"))
+	nb, errB := strconv.Atoi(strings.TrimPrefix(b, "v"))
+	if errA == nil && errB == nil {
+		return na < nb
+	}
+	return a < b
+}
+
+// mustLoad parses every embedded template. Templates ship inside the binary, so a broken one
+// stops the server at startup rather than failing on its first use.
+func mustLoad(files fs.FS) map[string]map[string]*Prompt {
+	paths, err := fs.Glob(files, "templates/*.tmpl")
+	if err != nil {
+		panic(err)
+	}
+	prompts := make(map[string]map[string]*Prompt)
+	for _, file := range paths {
+		raw, err := fs.ReadFile(files, file)
+		if err != nil {
+			panic(err)
+		}
+		p, err := parse(strings.TrimSuffix(path.Base(file), ".tmpl"), string(raw))
+		if err != nil {
+			panic(fmt.Sprintf("prompts: %s: %v", file, err))
+		}
+		if prompts[p.Name] == nil {
+			prompts[p.Name] = make(map[string]*Prompt)
+		}
+		prompts[p.Name][p.Version] = p
+	}
+	return prompts
+}
+
+// parse reads one template file named <prompt>.<version>
+func parse(base, raw string) (*Prompt, error) {
+	name, version, ok := strings.Cut(base, ".")
+	if !ok || name == "" || version == "" {
+		return nil, fmt.Errorf("file name must be <prompt>.<version>.tmpl")
+	}
+	p := &Prompt{Name: name, Version: version}
+
+	raw = strings.ReplaceAll(raw, "\r\n", "\n")
+	if !strings.HasPrefix(raw, "---\n") {
+		return nil, fmt.Errorf("missing --- header")
+	}
+	header, body, ok := strings.Cut(raw[len("---\n"):], "\n---\n")
+	if !ok {
+		return nil, fmt.Errorf("unterminated --- header")
+	}
+	for _, line := range strings.Split(header, "\n") {
+		if strings.TrimSpace(line) == "" {
+			continue
+		}
+		key, value, ok := strings.Cut(line, ":")
+		if !ok {
+			return nil, fmt.Errorf("bad header line %q", line)
+		}
+		value = strings.TrimSpace(value)
+		switch strings.TrimSpace(key) {
+		case "description":
+			p.Description = value
+		case "model":
+			p.Model = value
+		case "temperature":
+			t, err := strconv.ParseFloat(value, 32)
+			if err != nil || t < 0 || t > 2 {
+				return nil, fmt.Errorf("temperature must be between 0 and 2")
+			}
+			temperature := float32(t)
+			p.Temperature = &temperature
+		case "variables":
+			for _, v := range strings.Split(value, ",") {
+				if v = strings.TrimSpace(v); v != "" {
+					p.Variables = append(p.Variables, v)
+				}
+			}
+		default:
+			return nil, fmt.Errorf("unknown header %q", key)
+		}
+	}
+
+	tmpl, err := template.New(base).Option("missingkey=error").Parse(body)
+	if err != nil {
+		return nil, err
+	}
+	if tmpl.Lookup("user") == nil {
+		return nil, fmt.Errorf(`no {{define "user"}} template`)
+	}
+	p.tmpl = tmpl
+
+	// 🧪 A dry run with every declared variable catches templates using undeclared ones
+	vars := Vars{}
+	for _, v := range p.Variables {
+		vars[v] = ""
+	}
+	if _, err := p.Render(vars); err != nil {
+		return nil, err
+	}
+	return p, nil
+}
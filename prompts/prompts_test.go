@@ -0,0 +1,147 @@
+package prompts
+
+import (
+	"reflect"
+	"strings"
+	"testing"
+
+	"mend/llm"
+)
+
+// tmplFile builds a template file from its header lines and body
+func tmplFile(header, body string) string {
+	return "---\n" + header + "\n---\n" + body
+}
+
+const userOnly = `{{define "user"}}Hello {{.name}}{{end}}`
+
+func TestParse(t *testing.T) {
+	zero, cool := float32(0), float32(0.3)
+
+	tests := []struct {
+		name    string
+		base    string
+		raw     string
+		want    Prompt // Compared without the template
+		wantErr string
+	}{
+		{"full header", "greet.v1", tmplFile("description: Says hello\nmodel: gpt-4o\ntemperature: 0.3\nvariables: name, mood", userOnly),
+			Prompt{Name: "greet", Version: "v1", Description: "Says hello", Model: "gpt-4o", Temperature: &cool, Variables: []string{"name", "mood"}}, ""},
+		{"zero temperature", "greet.v1", tmplFile("temperature: 0\nvariables: name", userOnly),
+			Prompt{Name: "greet", Version: "v1", Temperature: &zero, Variables: []string{"name"}}, ""},
+		{"provider's temperature", "greet.v1", tmplFile("variables: name", userOnly),
+			Prompt{Name: "greet", Version: "v1", Variables: []string{"name"}}, ""},
+		{"windows line endings", "greet.v2", strings.ReplaceAll(tmplFile("description: Says hello\n\nvariables: name", userOnly), "\n", "\r\n"),
+			Prompt{Name: "greet", Version: "v2", Description: "Says hello", Variables: []string{"name"}}, ""},
+		{"unknown header", "greet.v1", tmplFile("top_p: 0.9\nvariables: name", userOnly), Prompt{}, `unknown header "top_p"`},
+		{"header without a colon", "greet.v1", tmplFile("variables name", userOnly), Prompt{}, "bad header line"},
+		{"temperature too high", "greet.v1", tmplFile("temperature: 2.5\nvariables: name", userOnly), Prompt{}, "between 0 and 2"},
+		{"negative temperature", "greet.v1", tmplFile("temperature: -0.1\nvariables: name", userOnly), Prompt{}, "between 0 and 2"},
+		{"temperature not a number", "greet.v1", tmplFile("temperature: warm\nvariables: name", userOnly), Prompt{}, "between 0 and 2"},
+		{"no version", "greet", tmplFile("variables: name", userOnly), Prompt{}, "<prompt>.<version>.tmpl"},
+		{"no header", "greet.v1", userOnly, Prompt{}, "missing --- header"},
+		{"unterminated header", "greet.v1", "---\nvariables: name\n" + userOnly, Prompt{}, "unterminated"},
+		{"no user template", "greet.v1", tmplFile("variables: name", `{{define "system"}}Be kind{{end}}`), Prompt{}, `no {{define "user"}}`},
+		{"undeclared variable", "greet.v1", tmplFile("variables: name", `{{define "user"}}Hello {{.name}}, you seem {{.mood}}{{end}}`), Prompt{}, `"mood"`},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			p, err := parse(tt.base, tt.raw)
+			if tt.wantErr != "" {
+				if err == nil || !strings.Contains(err.Error(), tt.wantErr) {
+					t.Fatalf("error = %v, want one mentioning %s", err, tt.wantErr)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			got := *p
+			got.tmpl = nil
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("parsed %+v, want %+v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestRender(t *testing.T) {
+	cool := float32(0.3)
+	p, err := parse("greet.v1", tmplFile("model: gpt-4o\ntemperature: 0.3\nvariables: name, mood",
+		`{{define "system"}}Be kind.{{end}}
+{{define "user"}}
+  Hello {{.name}}, you seem {{.mood}}.
+{{end}}`))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// Variables a prompt doesn't declare are ignored
+	req, err := p.Render(Vars{"name": "Sam", "mood": "tired", "unused": "x"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := llm.Request{Model: "gpt-4o", Temperature: &cool, Messages: []llm.Message{
+		llm.System("Be kind."),
+		llm.User("Hello Sam, you seem tired."),
+	}}
+	if !reflect.DeepEqual(req, want) {
+		t.Errorf("rendered %+v, want %+v", req, want)
+	}
+
+	if _, err := p.Render(Vars{"unused": "x"}); err == nil || !strings.Contains(err.Error(), `missing "name", "mood"`) {
+		t.Errorf("render without variables = %v, want both reported missing", err)
+	}
+}
+
+func TestVersionsAndOverride(t *testing.T) {
+	const name = "ordering"
+	registry[name] = make(map[string]*Prompt)
+	defer delete(registry, name)
+	for _, v := range []string{"v10", "v2", "v1"} {
+		p, err := parse(name+"."+v, tmplFile("variables: name", userOnly))
+		if err != nil {
+			t.Fatal(err)
+		}
+		registry[name][v] = p
+	}
+
+	if got := Versions(name); !reflect.DeepEqual(got, []string{"v1", "v2", "v10"}) {
+		t.Errorf("versions = %v, want numeric order", got)
+	}
+
+	tests := []struct {
+		override string
+		want     string
+	}{
+		{"", "v10"},
+		{"v2", "v2"},
+		{"v3", "v10"}, // Not a version: falls back to the latest
+	}
+	for _, tt := range tests {
+		t.Run("PROMPT_VERSION_ORDERING="+tt.override, func(t *testing.T) {
+			t.Setenv("PROMPT_VERSION_ORDERING", tt.override)
+			if got := Get(name).Version; got != tt.want {
+				t.Errorf("Get = %s, want %s", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestOlder(t *testing.T) {
+	tests := []struct {
+		a, b string
+		want bool
+	}{
+		{"v2", "v10", true},
+		{"v10", "v2", false},
+		{"v1", "v1", false},
+		{"beta", "v1", true}, // Not both numeric: by name
+		{"v1", "alpha", false},
+	}
+	for _, tt := range tests {
+		if got := older(tt.a, tt.b); got != tt.want {
+			t.Errorf("older(%s, %s) = %v, want %v", tt.a, tt.b, got, tt.want)
+		}
+	}
+}
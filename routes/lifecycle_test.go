@@ -0,0 +1,241 @@
+package routes
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"strings"
+	"testing"
+	"time"
+
+	"mend/database"
+	"mend/hub"
+	"mend/llm"
+	"mend/llm/fakellm"
+	"mend/models"
+	"mend/utils"
+
+	"github.com/fasthttp/websocket"
+	"github.com/gofiber/fiber/v2"
+	"github.com/google/uuid"
+	"go.mongodb.org/mongo-driver/bson"
+)
+
+// partner is a seeded user and their access token
+type partner struct {
+	id, token string
+}
+
+// seedCouple stores two linked users, with AI interjections on, and logs both in.
+// Everything the test creates for them is deleted afterwards.
+func seedCouple(t *testing.T) (partner, partner, string) {
+	t.Helper()
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+
+	relationshipId := uuid.NewString()
+	var couple []partner
+	for _, name := range []string{"Alice", "Bob"} {
+		p := partner{id: uuid.NewString()}
+		user := models.User{ID: p.id, Name: name, Email: p.id + "@example.com", EmailVerified: true, CreatedAt: time.Now()}
+		if _, err := database.GetCollection("users").InsertOne(ctx, user); err != nil {
+			t.Fatal(err)
+		}
+		device := models.AuthSession{ID: uuid.NewString(), UserID: p.id, CreatedAt: time.Now().Unix()}
+		if _, err := database.GetCollection("authSessions").InsertOne(ctx, device); err != nil {
+			t.Fatal(err)
+		}
+		token, err := utils.GenerateToken(p.id, device.ID, utils.TokenTypeAccess, utils.AccessTokenTTL)
+		if err != nil {
+			t.Fatal(err)
+		}
+		p.token = token
+		couple = append(couple, p)
+	}
+	alice, bob := couple[0], couple[1]
+
+	_, err := database.GetCollection("relationships").InsertOne(ctx, models.Relationship{
+		ID:          relationshipId,
+		Members:     []string{alice.id, bob.id},
+		InitiatedBy: alice.id,
+		Status:      models.RelationshipStatusActive,
+		StartedAt:   time.Now().Unix(),
+		Settings:    models.RelationshipSettings{AIInterjections: true},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	t.Cleanup(func() {
+		ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+		defer cancel()
+		ids := []string{alice.id, bob.id}
+		database.GetCollection("users").DeleteMany(ctx, bson.M{"id": bson.M{"$in": ids}})
+		database.GetCollection("authSessions").DeleteMany(ctx, bson.M{"userId": bson.M{"$in": ids}})
+		database.GetCollection("reflections").DeleteMany(ctx, bson.M{"userId": bson.M{"$in": ids}})
+		database.GetCollection("sessions").DeleteMany(ctx, bson.M{"relationshipId": relationshipId})
+		database.GetCollection("relationships").DeleteOne(ctx, bson.M{"_id": relationshipId})
+	})
+	return alice, bob, relationshipId
+}
+
+// call makes an API request as p and decodes the JSON response into out
+func call(t *testing.T, base string, p partner, method, path string, body interface{}, out interface{}) int {
+	t.Helper()
+	var raw []byte
+	if body != nil {
+		raw, _ = json.Marshal(body)
+	}
+	req, _ := http.NewRequest(method, base+path, bytes.NewReader(raw))
+	req.Header.Set("Authorization", "Bearer "+p.token)
+	req.Header.Set("Content-Type", "application/json")
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer resp.Body.Close()
+	if out != nil {
+		json.NewDecoder(resp.Body).Decode(out)
+	}
+	return resp.StatusCode
+}
+
+// dial opens p's chat socket for the session
+func dial(t *testing.T, base string, p partner, sessionId string) *websocket.Conn {
+	t.Helper()
+	url := "ws" + strings.TrimPrefix(base, "http") + "/ws/" + p.id + "/" + sessionId + "?token=" + p.token
+	conn, _, err := websocket.DefaultDialer.Dial(url, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { conn.Close() })
+	return conn
+}
+
+// readUntil reads frames until one of the kind arrives that match accepts. match sees every
+// frame read on the way.
+func readUntil(t *testing.T, conn *websocket.Conn, kind string, match func(hub.Envelope) bool) hub.Envelope {
+	t.Helper()
+	conn.SetReadDeadline(time.Now().Add(10 * time.Second))
+	for {
+		_, msg, err := conn.ReadMessage()
+		if err != nil {
+			t.Fatalf("no %s frame: %v", kind, err)
+		}
+		var env hub.Envelope
+		if json.Unmarshal(msg, &env) == nil && match(env) && env.Type == kind {
+			return env
+		}
+	}
+}
+
+// TestSessionLifecycle runs a whole session through the HTTP API and the chat socket, with
+// the fake LLM answering: start, join, chat, the therapist's interjection, end, auto-scoring
+// and the reflection. It needs a MongoDB replica set, since messages are stored in
+// transactions: MONGO_TEST_URI=mongodb://localhost:27017/?replicaSet=rs0. The app always
+// uses the "mend" database, so point it at a throwaway server.
+func TestSessionLifecycle(t *testing.T) {
+	uri := os.Getenv("MONGO_TEST_URI")
+	if uri == "" {
+		t.Skip("MONGO_TEST_URI not set")
+	}
+	t.Setenv("MONGO_URI", uri)
+	t.Setenv("JWT_SECRET", "lifecycle-test-secret")
+	database.ConnectDB()
+	database.EnsureIndexes()
+	defer database.DisconnectDB(context.Background())
+
+	fake := fakellm.New()
+	llmServer := httptest.NewServer(fake)
+	defer llmServer.Close()
+	previous := llm.Default()
+	llm.SetDefault(llm.NewLocal(llmServer.URL+"/v1", "fake", ""))
+	defer llm.SetDefault(previous)
+
+	app := fiber.New()
+	SetupRoutes(app)
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	go app.Listener(ln)
+	defer app.Shutdown()
+	base := "http://" + ln.Addr().String()
+
+	alice, bob, _ := seedCouple(t)
+
+	// ▶️ Alice starts the session and Bob joins it
+	var session models.Session
+	if status := call(t, base, alice, http.MethodPost, "/api/session", nil, &session); status != http.StatusCreated {
+		t.Fatalf("start = %d", status)
+	}
+	if session.Status != models.SessionStatusWaitingForPartner {
+		t.Fatalf("new session is %s, want waiting for partner", session.Status)
+	}
+	if status := call(t, base, bob, http.MethodPatch, "/api/session/join/"+session.ID, nil, &session); status != http.StatusOK || session.Status != models.SessionStatusActive {
+		t.Fatalf("join = %d with status %s, want an active session", status, session.Status)
+	}
+
+	// 💬 Alice says something heated; both get it, the moderator warns and the therapist steps in
+	aliceConn, bobConn := dial(t, base, alice, session.ID), dial(t, base, bob, session.ID)
+	chat, _ := hub.NewEnvelope(hub.KindChat, session.ID, alice.id, hub.ChatPayload{Text: "You never listen to me"})
+	if err := aliceConn.WriteJSON(chat); err != nil {
+		t.Fatal(err)
+	}
+	readUntil(t, aliceConn, hub.KindAck, func(env hub.Envelope) bool { return env.Seq == 1 })
+	readUntil(t, bobConn, hub.KindChat, func(env hub.Envelope) bool { return env.From == alice.id && env.Seq == 1 })
+	// The moderator flags the absolute language while the therapist replies, in either order
+	warned := false
+	interjection := readUntil(t, bobConn, hub.KindAIReply, func(env hub.Envelope) bool {
+		warned = warned || env.Type == hub.KindAIWarning
+		return env.Type == hub.KindAIReply
+	})
+	if !warned {
+		readUntil(t, bobConn, hub.KindAIWarning, func(hub.Envelope) bool { return true })
+	}
+	var reply hub.AIReplyPayload
+	json.Unmarshal(interjection.Payload, &reply)
+	if !strings.Contains(reply.Text, `"I feel"`) || interjection.Seq != 2 {
+		t.Errorf("interjection = %q with seq %d, want the fake therapist's de-escalation stored as seq 2", reply.Text, interjection.Seq)
+	}
+
+	// ⏹️ Alice ends it; both partners get an AI score
+	if status := call(t, base, alice, http.MethodPatch, "/api/session/end/"+session.ID, nil, nil); status != http.StatusOK {
+		t.Fatalf("end = %d", status)
+	}
+	var scored struct {
+		ScoreA models.CommunicationScore `json:"scoreA"`
+		ScoreB models.CommunicationScore `json:"scoreB"`
+	}
+	deadline := time.Now().Add(15 * time.Second)
+	for scored.ScoreA.CreatedAt == 0 || scored.ScoreB.CreatedAt == 0 {
+		if time.Now().After(deadline) {
+			t.Fatalf("scores weren't generated: %+v", scored)
+		}
+		time.Sleep(100 * time.Millisecond)
+		call(t, base, bob, http.MethodGet, "/api/session/score/"+session.ID, nil, &scored)
+	}
+	for _, score := range []models.CommunicationScore{scored.ScoreA, scored.ScoreB} {
+		if score.Empathy != 4 || score.Clarity != 5 || score.PromptVersion == "" {
+			t.Errorf("score = %+v, want the fake's 4s and 5 for one absolute word", score)
+		}
+	}
+
+	// 🪞 Bob asks for an AI reflection on the session
+	var reflection models.Reflection
+	if status := call(t, base, bob, http.MethodPost, "/api/reflection", fiber.Map{"sessionId": session.ID}, &reflection); status != http.StatusCreated {
+		t.Fatalf("reflection = %d", status)
+	}
+	if !strings.HasPrefix(reflection.Text, "You both showed up") || reflection.PromptVersion == "" {
+		t.Errorf("reflection = %+v, want the fake's reflection and its prompt version", reflection)
+	}
+
+	// Every AI step went to the fake: moderation, interjection, two scores, reflection
+	if got := len(fake.Requests()); got != 5 {
+		t.Errorf("fake LLM got %d requests, want 5", got)
+	}
+}
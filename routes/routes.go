@@ -2,6 +2,7 @@ package routes
 
 import (
 	"mend/controllers"
+	"mend/middleware"
 
 	"github.com/gofiber/fiber/v2"
 	"github.com/gofiber/websocket/v2"
@@ -16,48 +17,74 @@ func SetupRoutes(app *fiber.App) {
 	// ─────────────────────────────────────────────
 	api.Post("/register", controllers.RegisterUser)
 	api.Post("/login", controllers.LoginUser)
-	api.Get("/user/:id", controllers.GetUser)
-	api.Post("/invite", controllers.InvitePartner)
-	api.Post("/accept-invite", controllers.AcceptInvite)
+	api.Post("/login/2fa", controllers.LoginTwoFactor)
+	api.Post("/token/refresh", controllers.RefreshToken)
+	api.Post("/password/forgot", controllers.ForgotPassword)
+	api.Post("/password/reset", controllers.ResetPassword)
+	api.Post("/email/verify", controllers.VerifyEmail)
+
+	// Everything below requires a valid access token. Public /api routes must be
+	// registered above this line: the group's middleware matches the whole /api prefix.
+	auth := api.Group("", middleware.RequireAuth)
+
+	auth.Post("/logout", controllers.Logout)
+	auth.Post("/logout/all", controllers.LogoutAll)
+	auth.Post("/email/verify/resend", controllers.ResendVerification)
+	auth.Post("/2fa/setup", controllers.SetupTwoFactor)
+	auth.Post("/2fa/enable", controllers.EnableTwoFactor)
+	auth.Post("/2fa/disable", controllers.DisableTwoFactor)
+	auth.Get("/user/:id", controllers.GetUser)
+	auth.Post("/invite", controllers.InvitePartner)
+	auth.Post("/invite/decline", controllers.DeclineInvite)
+	auth.Delete("/invite/:id", controllers.RevokeInvite)
+	auth.Post("/accept-invite", controllers.AcceptInvite)
+	auth.Post("/partner/unlink", controllers.UnlinkPartner)
+	auth.Get("/relationship", controllers.GetRelationship)
+	auth.Patch("/relationship", controllers.UpdateRelationship)
+	auth.Get("/relationships", controllers.GetRelationships)
+	auth.Patch("/relationship/:id/visibility", controllers.SetTranscriptVisibility)
 
 	// ─────────────────────────────────────────────
 	// 🌱 Onboarding Data
 	// ─────────────────────────────────────────────
-	api.Post("/onboarding", controllers.SubmitOnboarding)
+	auth.Post("/onboarding", controllers.SubmitOnboarding)
 
 	// ─────────────────────────────────────────────
 	// 🗣️ Session Management + AI Moderation
 	// ─────────────────────────────────────────────
-	api.Post("/session", controllers.StartSession)
-	api.Get("/session/active/:userId", controllers.GetActiveSession)
-	api.Patch("/session/end/:sessionId", controllers.EndSession)
-	api.Get("/session/score/:sessionId", controllers.GetSessionScore)
-	api.Post("/moderate", controllers.ModerateChat)
+	auth.Post("/session", controllers.StartSession)
+	auth.Get("/session/active/:userId", controllers.GetActiveSession)
+	auth.Patch("/session/join/:sessionId", controllers.JoinSession)
+	auth.Patch("/session/pause/:sessionId", controllers.PauseSession)
+	auth.Patch("/session/resume/:sessionId", controllers.ResumeSession)
+	auth.Patch("/session/end/:sessionId", controllers.EndSession)
+	auth.Patch("/session/abandon/:sessionId", controllers.AbandonSession)
+	auth.Get("/session/score/:sessionId", controllers.GetSessionScore)
+	auth.Get("/session/:sessionId/presence", controllers.GetSessionPresence)
+	auth.Post("/moderate", controllers.ModerateChat)
+	auth.Post("/moderate/voice", controllers.ModerateVoiceInput)
+	auth.Get("/rtc/config", controllers.GetRTCConfig)
 
 	// ─────────────────────────────────────────────
 	// 🔄 WebSocket Chat Communication
 	// ─────────────────────────────────────────────
+	// Upgrades are authenticated with `?token=<accessToken>` and limited to session participants
+
 	// Legacy text-based chat WebSocket
-	app.Get("/ws/:userId/:sessionId", websocket.New(controllers.HandleWebSocket))
+	app.Get("/ws/:userId/:sessionId", middleware.RequireAuth, controllers.AuthorizeSessionSocket, websocket.New(controllers.HandleWebSocket))
 
 	// New voice chat + AI moderation WebSocket
-	app.Use("/ws-voice/:sessionId/:userId", func(c *fiber.Ctx) error {
-		if websocket.IsWebSocketUpgrade(c) {
-			return c.Next()
-		}
-		return fiber.ErrUpgradeRequired
-	})
-	app.Get("/ws-voice/:sessionId/:userId", websocket.New(controllers.WebSocketHandler2))
+	app.Get("/ws-voice/:sessionId/:userId", middleware.RequireAuth, controllers.AuthorizeSessionSocket, websocket.New(controllers.WebSocketHandler2))
 
 	// ─────────────────────────────────────────────
 	// 🧘 Post-Session Reflections & Scores
 	// ─────────────────────────────────────────────
-	api.Post("/reflection", controllers.SaveReflection)
-	api.Post("/post-resolution", controllers.SavePostResolution)
-	api.Post("/score", controllers.SubmitScore)
+	auth.Post("/reflection", controllers.SaveReflection)
+	auth.Post("/post-resolution", controllers.SavePostResolution)
+	auth.Post("/score", controllers.SubmitScore)
 
 	// ─────────────────────────────────────────────
 	// 📊 Communication Insights
 	// ─────────────────────────────────────────────
-	api.Get("/insights/:userId", controllers.GetInsights)
+	auth.Get("/insights/:userId", controllers.GetInsights)
 }
@@ -2,6 +2,9 @@ package utils
 
 import "golang.org/x/crypto/bcrypt"
 
+// dummyHash has the same cost as HashPassword and matches no real password
+const dummyHash = "$2a$14$dY2bj/7d/suPpjhRgTZhM.FXCMiZDF5QJ8z2U1tmB3rJo7DqSMz8q"
+
 func HashPassword(pw string) string {
 	hash, _ := bcrypt.GenerateFromPassword([]byte(pw), 14)
 	return string(hash)
@@ -11,3 +14,10 @@ func CheckPassword(password, hash string) bool {
 	err := bcrypt.CompareHashAndPassword([]byte(hash), []byte(password))
 	return err == nil
 }
+
+// CheckDummyPassword burns the same bcrypt work as CheckPassword so a login for an
+// unknown email takes as long as one with a wrong password. It always returns false.
+func CheckDummyPassword(password string) bool {
+	CheckPassword(password, dummyHash)
+	return false
+}
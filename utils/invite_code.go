@@ -1,10 +1,38 @@
 package utils
 
 import (
+	"crypto/rand"
+	"strings"
+
 	"github.com/google/uuid"
 )
 
+// Crockford base32: no I, L, O or U, so codes survive being read aloud or retyped
+const inviteAlphabet = "0123456789ABCDEFGHJKMNPQRSTVWXYZ"
+
 // GeneratePartnerID returns a unique UUID for user/session/partner IDs
 func GeneratePartnerID() string {
 	return uuid.NewString()
 }
+
+// GenerateInviteCode returns a short human-friendly partner invite code like "7KQ2-M9XD"
+func GenerateInviteCode() (string, error) {
+	b := make([]byte, 8)
+	if _, err := rand.Read(b); err != nil {
+		return "", err
+	}
+	for i := range b {
+		b[i] = inviteAlphabet[int(b[i])%len(inviteAlphabet)]
+	}
+	return string(b[:4]) + "-" + string(b[4:]), nil
+}
+
+// NormalizeInviteCode canonicalises user input: case, separators and look-alike characters
+func NormalizeInviteCode(code string) string {
+	code = strings.ToUpper(code)
+	code = strings.NewReplacer("-", "", " ", "", "O", "0", "I", "1", "L", "1").Replace(code)
+	if len(code) != 8 {
+		return code
+	}
+	return code[:4] + "-" + code[4:]
+}
@@ -0,0 +1,76 @@
+package utils
+
+import (
+	"errors"
+	"os"
+	"time"
+
+	"github.com/golang-jwt/jwt/v5"
+)
+
+const (
+	AccessTokenTTL  = 15 * time.Minute
+	RefreshTokenTTL = 30 * 24 * time.Hour
+
+	TokenTypeAccess    = "access"
+	TokenTypeTwoFactor = "2fa" // Short-lived proof that the password step of login passed
+)
+
+// TokenClaims are the claims carried by every token issued by Mend
+type TokenClaims struct {
+	Type      string `json:"typ"`
+	SessionID string `json:"sid,omitempty"` // Auth session (device) the token belongs to
+	jwt.RegisteredClaims
+}
+
+// GenerateToken signs a token of the given type for a user's auth session
+func GenerateToken(userID, sessionID, tokenType string, ttl time.Duration) (string, error) {
+	secret, err := jwtSecret()
+	if err != nil {
+		return "", err
+	}
+
+	now := time.Now()
+	claims := TokenClaims{
+		Type:      tokenType,
+		SessionID: sessionID,
+		RegisteredClaims: jwt.RegisteredClaims{
+			Subject:   userID,
+			ID:        GeneratePartnerID(),
+			IssuedAt:  jwt.NewNumericDate(now),
+			ExpiresAt: jwt.NewNumericDate(now.Add(ttl)),
+		},
+	}
+	return jwt.NewWithClaims(jwt.SigningMethodHS256, claims).SignedString(secret)
+}
+
+// ParseToken verifies a token's signature, expiry and type and returns its claims
+func ParseToken(tokenString, tokenType string) (*TokenClaims, error) {
+	secret, err := jwtSecret()
+	if err != nil {
+		return nil, err
+	}
+
+	var claims TokenClaims
+	_, err = jwt.ParseWithClaims(tokenString, &claims, func(t *jwt.Token) (interface{}, error) {
+		return secret, nil
+	}, jwt.WithValidMethods([]string{jwt.SigningMethodHS256.Alg()}), jwt.WithExpirationRequired())
+	if err != nil {
+		return nil, err
+	}
+	if claims.Type != tokenType {
+		return nil, errors.New("unexpected token type")
+	}
+	if claims.Subject == "" {
+		return nil, errors.New("token has no subject")
+	}
+	return &claims, nil
+}
+
+func jwtSecret() ([]byte, error) {
+	secret := os.Getenv("JWT_SECRET")
+	if secret == "" {
+		return nil, errors.New("JWT_SECRET is not set in environment")
+	}
+	return []byte(secret), nil
+}
@@ -0,0 +1,114 @@
+package utils
+
+import (
+	"sync"
+	"time"
+)
+
+// AttemptLimiter tracks failed attempts per key (an IP, an account) and decides
+// how long that key has to back off before it may try again.
+type AttemptLimiter interface {
+	// Check returns how long the key must wait before its next attempt; 0 means allowed
+	Check(key string) time.Duration
+	// Fail records a failed attempt for the key
+	Fail(key string)
+	// Reset forgets the key after a successful attempt
+	Reset(key string)
+}
+
+// LimiterConfig controls back-off and lockout
+type LimiterConfig struct {
+	FreeAttempts int           // Failures allowed before back-off starts
+	BaseDelay    time.Duration // First back-off, doubled for every further failure
+	MaxDelay     time.Duration // Cap for the exponential back-off
+	LockoutAfter int           // Failures that trigger a temporary lockout
+	LockoutFor   time.Duration // Lockout length
+	Window       time.Duration // Failures older than this are forgotten
+}
+
+type attemptEntry struct {
+	failures     int
+	lastFailure  time.Time
+	blockedUntil time.Time
+}
+
+// MemoryLimiter is an in-process AttemptLimiter. Now can be swapped for a fixed clock.
+type MemoryLimiter struct {
+	Now func() time.Time
+
+	cfg     LimiterConfig
+	mu      sync.Mutex
+	entries map[string]*attemptEntry
+}
+
+// NewMemoryLimiter creates an in-memory limiter with the given policy
+func NewMemoryLimiter(cfg LimiterConfig) *MemoryLimiter {
+	return &MemoryLimiter{
+		Now:     time.Now,
+		cfg:     cfg,
+		entries: make(map[string]*attemptEntry),
+	}
+}
+
+func (m *MemoryLimiter) Check(key string) time.Duration {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	entry, ok := m.entries[key]
+	if !ok {
+		return 0
+	}
+	now := m.Now()
+	if wait := entry.blockedUntil.Sub(now); wait > 0 {
+		return wait
+	}
+	if now.Sub(entry.lastFailure) > m.cfg.Window {
+		delete(m.entries, key)
+	}
+	return 0
+}
+
+func (m *MemoryLimiter) Fail(key string) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	now := m.Now()
+	entry, ok := m.entries[key]
+	if !ok || (now.Sub(entry.lastFailure) > m.cfg.Window && !now.Before(entry.blockedUntil)) {
+		entry = &attemptEntry{}
+		m.entries[key] = entry
+	}
+	entry.failures++
+	entry.lastFailure = now
+
+	switch {
+	case m.cfg.LockoutAfter > 0 && entry.failures >= m.cfg.LockoutAfter:
+		entry.blockedUntil = now.Add(m.cfg.LockoutFor)
+	case entry.failures > m.cfg.FreeAttempts:
+		delay := m.cfg.BaseDelay << (entry.failures - m.cfg.FreeAttempts - 1)
+		if delay <= 0 || delay > m.cfg.MaxDelay {
+			delay = m.cfg.MaxDelay
+		}
+		entry.blockedUntil = now.Add(delay)
+	}
+
+	m.sweep(now)
+}
+
+func (m *MemoryLimiter) Reset(key string) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	delete(m.entries, key)
+}
+
+// sweep drops stale keys so sprayed IPs/emails don't grow the map forever
+func (m *MemoryLimiter) sweep(now time.Time) {
+	if len(m.entries) < 10000 {
+		return
+	}
+	for key, entry := range m.entries {
+		if now.Sub(entry.lastFailure) > m.cfg.Window && !now.Before(entry.blockedUntil) {
+			delete(m.entries, key)
+		}
+	}
+}
@@ -0,0 +1,128 @@
+package utils
+
+import (
+	"testing"
+	"time"
+)
+
+var testLimiterConfig = LimiterConfig{
+	FreeAttempts: 2,
+	BaseDelay:    time.Second,
+	MaxDelay:     4 * time.Second,
+	LockoutAfter: 6,
+	LockoutFor:   time.Hour,
+	Window:       10 * time.Minute,
+}
+
+// fakeClock is a settable clock for MemoryLimiter.Now
+type fakeClock struct{ now time.Time }
+
+func (c *fakeClock) Now() time.Time          { return c.now }
+func (c *fakeClock) Advance(d time.Duration) { c.now = c.now.Add(d) }
+
+func newTestLimiter() (*MemoryLimiter, *fakeClock) {
+	clock := &fakeClock{now: time.Date(2025, 1, 1, 12, 0, 0, 0, time.UTC)}
+	limiter := NewMemoryLimiter(testLimiterConfig)
+	limiter.Now = clock.Now
+	return limiter, clock
+}
+
+func TestMemoryLimiterBackoff(t *testing.T) {
+	tests := []struct {
+		name     string
+		failures int
+		want     time.Duration
+	}{
+		{"no failures", 0, 0},
+		{"within free attempts", 2, 0},
+		{"first back-off", 3, time.Second},
+		{"doubles", 4, 2 * time.Second},
+		{"capped at max delay", 5, 4 * time.Second},
+		{"locked out", 6, time.Hour},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			limiter, _ := newTestLimiter()
+			for i := 0; i < tt.failures; i++ {
+				limiter.Fail("ip:1.2.3.4")
+			}
+			if got := limiter.Check("ip:1.2.3.4"); got != tt.want {
+				t.Errorf("Check after %d failures = %v, want %v", tt.failures, got, tt.want)
+			}
+		})
+	}
+}
+
+func TestMemoryLimiterBackoffElapses(t *testing.T) {
+	limiter, clock := newTestLimiter()
+	for i := 0; i < 4; i++ {
+		limiter.Fail("k")
+	}
+
+	clock.Advance(time.Second)
+	if got := limiter.Check("k"); got != time.Second {
+		t.Fatalf("Check part way through back-off = %v, want 1s", got)
+	}
+	clock.Advance(time.Second)
+	if got := limiter.Check("k"); got != 0 {
+		t.Fatalf("Check after back-off = %v, want 0", got)
+	}
+
+	// Failures inside the window keep counting
+	limiter.Fail("k")
+	if got := limiter.Check("k"); got != 4*time.Second {
+		t.Fatalf("Check after a further failure = %v, want 4s", got)
+	}
+}
+
+func TestMemoryLimiterWindowForgetsFailures(t *testing.T) {
+	limiter, clock := newTestLimiter()
+	for i := 0; i < 3; i++ {
+		limiter.Fail("k")
+	}
+
+	clock.Advance(testLimiterConfig.Window + time.Second)
+	if got := limiter.Check("k"); got != 0 {
+		t.Fatalf("Check after the window = %v, want 0", got)
+	}
+
+	// The count starts over, so the next failure is free again
+	limiter.Fail("k")
+	if got := limiter.Check("k"); got != 0 {
+		t.Fatalf("Check after a fresh failure = %v, want 0", got)
+	}
+}
+
+func TestMemoryLimiterLockoutOutlastsWindow(t *testing.T) {
+	limiter, clock := newTestLimiter()
+	for i := 0; i < testLimiterConfig.LockoutAfter; i++ {
+		limiter.Fail("k")
+	}
+
+	clock.Advance(testLimiterConfig.Window + time.Minute)
+	want := testLimiterConfig.LockoutFor - testLimiterConfig.Window - time.Minute
+	if got := limiter.Check("k"); got != want {
+		t.Fatalf("Check during lockout = %v, want %v", got, want)
+	}
+
+	clock.Advance(want)
+	if got := limiter.Check("k"); got != 0 {
+		t.Fatalf("Check after lockout = %v, want 0", got)
+	}
+}
+
+func TestMemoryLimiterResetAndKeys(t *testing.T) {
+	limiter, _ := newTestLimiter()
+	for i := 0; i < 3; i++ {
+		limiter.Fail("email:a@example.com")
+	}
+
+	if got := limiter.Check("email:b@example.com"); got != 0 {
+		t.Errorf("unrelated key is throttled for %v", got)
+	}
+
+	limiter.Reset("email:a@example.com")
+	if got := limiter.Check("email:a@example.com"); got != 0 {
+		t.Errorf("Check after Reset = %v, want 0", got)
+	}
+}
@@ -0,0 +1,132 @@
+package utils
+
+import (
+	"net/url"
+	"regexp"
+	"strings"
+	"testing"
+	"time"
+)
+
+// rfc6238Secret is the RFC 6238 appendix B SHA-1 key "12345678901234567890" in base32
+const rfc6238Secret = "GEZDGNBVGY3TQOJQGEZDGNBVGY3TQOJQ"
+
+// The RFC's 8-digit SHA-1 vectors, cut to the last 6 digits that TOTPDigits produces
+var rfc6238Vectors = []struct {
+	unix int64
+	code string
+}{
+	{59, "287082"},          // 94287082
+	{1111111109, "081804"},  // 07081804
+	{1111111111, "050471"},  // 14050471
+	{1234567890, "005924"},  // 89005924
+	{2000000000, "279037"},  // 69279037
+	{20000000000, "353130"}, // 65353130
+}
+
+func TestTOTPCodeRFC6238(t *testing.T) {
+	for _, v := range rfc6238Vectors {
+		at := time.Unix(v.unix, 0).UTC()
+		got, err := TOTPCode(rfc6238Secret, TOTPStep(at))
+		if err != nil {
+			t.Fatalf("TOTPCode at %d: %v", v.unix, err)
+		}
+		if got != v.code {
+			t.Errorf("TOTPCode at %d = %s, want %s", v.unix, got, v.code)
+		}
+	}
+}
+
+func TestValidateTOTP(t *testing.T) {
+	now := time.Unix(1111111111, 0)
+	step := TOTPStep(now)
+	code := func(step int64) string {
+		c, err := TOTPCode(rfc6238Secret, step)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return c
+	}
+
+	tests := []struct {
+		name     string
+		secret   string
+		code     string
+		wantStep int64
+		wantOK   bool
+	}{
+		{"current step", rfc6238Secret, code(step), step, true},
+		{"one step behind", rfc6238Secret, code(step - 1), step - 1, true},
+		{"one step ahead", rfc6238Secret, code(step + 1), step + 1, true},
+		{"two steps behind", rfc6238Secret, code(step - 2), 0, false},
+		{"spaces are ignored", rfc6238Secret, " 050 471 ", step, true},
+		{"lower-case secret", strings.ToLower(rfc6238Secret), code(step), step, true},
+		{"wrong code", rfc6238Secret, "000000", 0, false},
+		{"too short", rfc6238Secret, "05047", 0, false},
+		{"too long", rfc6238Secret, "0504712", 0, false},
+		{"invalid secret", "not base32!", "050471", 0, false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			gotStep, ok := ValidateTOTP(tt.secret, tt.code, now)
+			if ok != tt.wantOK || gotStep != tt.wantStep {
+				t.Errorf("ValidateTOTP = (%d, %v), want (%d, %v)", gotStep, ok, tt.wantStep, tt.wantOK)
+			}
+		})
+	}
+}
+
+func TestGenerateTOTPSecret(t *testing.T) {
+	secret, err := GenerateTOTPSecret()
+	if err != nil {
+		t.Fatal(err)
+	}
+	key, err := totpEncoding.DecodeString(secret)
+	if err != nil {
+		t.Fatalf("secret %q is not base32: %v", secret, err)
+	}
+	if len(key) != 20 {
+		t.Errorf("secret is %d bytes, want 20", len(key))
+	}
+	if _, err := TOTPCode(secret, 1); err != nil {
+		t.Errorf("generated secret can't make codes: %v", err)
+	}
+}
+
+func TestTOTPProvisioningURI(t *testing.T) {
+	uri := TOTPProvisioningURI(rfc6238Secret, "sam@example.com", "Mend")
+	parsed, err := url.Parse(uri)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if parsed.Scheme != "otpauth" || parsed.Host != "totp" || parsed.Path != "/Mend:sam@example.com" {
+		t.Errorf("unexpected URI %s", uri)
+	}
+	q := parsed.Query()
+	for key, want := range map[string]string{"secret": rfc6238Secret, "issuer": "Mend", "algorithm": "SHA1", "digits": "6", "period": "30"} {
+		if q.Get(key) != want {
+			t.Errorf("%s = %q, want %q", key, q.Get(key), want)
+		}
+	}
+}
+
+func TestGenerateRecoveryCodes(t *testing.T) {
+	codes, err := GenerateRecoveryCodes(8)
+	if err != nil {
+		t.Fatal(err)
+	}
+	format := regexp.MustCompile(`^[a-z2-7]{4}-[a-z2-7]{4}$`)
+	seen := map[string]bool{}
+	for _, code := range codes {
+		if !format.MatchString(code) {
+			t.Errorf("recovery code %q doesn't look like k7qp-2xmd", code)
+		}
+		if seen[code] {
+			t.Errorf("recovery code %q repeated", code)
+		}
+		seen[code] = true
+	}
+	if len(codes) != 8 {
+		t.Errorf("got %d codes, want 8", len(codes))
+	}
+}
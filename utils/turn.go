@@ -0,0 +1,21 @@
+package utils
+
+import (
+	"crypto/hmac"
+	"crypto/sha1"
+	"encoding/base64"
+	"fmt"
+	"time"
+)
+
+// TURNCredentials returns time-limited TURN credentials in the TURN REST API style
+// (coturn's use-auth-secret): the username is "<expiry unix time>:<userId>" and the
+// password is base64(HMAC-SHA1(secret, username)). The TURN server shares the secret
+// and rejects the credentials once the expiry has passed.
+func TURNCredentials(secret, userId string, ttl time.Duration) (username, credential string, expiresAt int64) {
+	expiresAt = time.Now().Add(ttl).Unix()
+	username = fmt.Sprintf("%d:%s", expiresAt, userId)
+	mac := hmac.New(sha1.New, []byte(secret))
+	mac.Write([]byte(username))
+	return username, base64.StdEncoding.EncodeToString(mac.Sum(nil)), expiresAt
+}